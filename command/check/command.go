@@ -6,6 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/dihedron/rawdata"
 	"github.com/dihedron/snoop/command/base"
@@ -25,22 +28,36 @@ type Check struct {
 	// application will look for a viable configuration file named .snoop.yaml
 	// under a few well-known paths: /etc, the current directory etc.
 	Profile string `short:"p" long:"profile" description:"The path to the file containing the RabbitMQ connection info (aka profile)." required:"yes" env:"SNOOP_PROFILE"`
+	// JSON is used to output the results in machine-readable JSON format.
+	JSON bool `short:"j" long:"json" description:"Whether to output the results in JSON format." optional:"yes"`
+	// Timeout is the timeout for each step of the check.
+	Timeout time.Duration `short:"T" long:"timeout" description:"The timeout for each step of the check." default:"10s" env:"SNOOP_TIMEOUT"`
 }
 
-const MaxMessages = 1
+// Report is the machine-readable result of the Check command.
+type Report struct {
+	// Status is the overall status: "ok", "warning" or "critical".
+	Status string `json:"status" yaml:"status"`
+	// Servers contains the results of the check on each server (and virtual
+	// host, if the bindings span more than one).
+	Servers []*rabbitmq.Health `json:"servers" yaml:"servers"`
+}
 
-// Execute is the real implementation of the Check command.
+// Execute is the real implementation of the Check command; it returns a
+// common.ExitError whose code is ExitOK if all servers are healthy,
+// ExitWarning if at least one server is reachable and ExitCritical
+// if none is; configuration errors result in ExitUnknown.
 func (cmd *Check) Execute(args []string) error {
 
 	// TODO: is this needed?
 	if cmd.Profile == "" {
 		slog.Error("no profile provided")
-		return errors.New("no profile provided")
+		return &common.ExitError{Code: common.ExitUnknown, Err: errors.New("no profile provided")}
 	}
 
 	if err := common.Validate(cmd); err != nil {
 		slog.Error("error validating command struct", "error", err)
-		return err
+		return &common.ExitError{Code: common.ExitUnknown, Err: err}
 	}
 
 	slog.Debug("connection profile available", "path", cmd.Profile)
@@ -53,49 +70,116 @@ func (cmd *Check) Execute(args []string) error {
 
 	if err := common.Validate(rmq); err != nil {
 		slog.Error("error validating connection info struct", "error", err)
-		return err
+		return &common.ExitError{Code: common.ExitUnknown, Err: err}
 	}
 
 	slog.Debug("RabbitMQ connection info file in JSON format", "connection info", format.ToJSON(rmq))
-	fmt.Printf("%s:\n%s", color.YellowString("connection info"), color.BlueString(format.ToYAML(rmq)))
-
-	// check if servers can be contacted, one at a time
-	servers := rmq.Servers
-	var result error = nil
-	for _, server := range servers {
-
-		func() {
-			fmt.Printf("connecting to server %s on port %s:", color.YellowString(server.Address), color.YellowString(fmt.Sprintf("%d", server.Port)))
-
-			// overwrite the address of the serves in the configuration
-			// with the single one under test now
-			rmq.Servers = []rabbitmq.Server{server}
-			rmq.Reset()
-
-			// now prepare the processing chain
-			slog.Debug("initialising context...")
-			ctx := context.Background()
-
-			count := 0
-			for m := range rmq.All(ctx) {
-				fmt.Printf(" %s", color.GreenString(" ✔"))
-				m.Nack(true, true)
-				//slog.Debug("message received", "value", format.ToPrettyJSON(m))
-				slog.Debug("range loop: message received")
-				count++
-				if count >= MaxMessages {
-					slog.Debug("range loop: maximum count of messages, breaking from loop")
-					break
-				}
+	if !cmd.JSON {
+		fmt.Printf("%s:\n%s", color.YellowString("connection info"), color.BlueString(format.ToYAML(rmq)))
+	}
+
+	// check the servers one at a time
+	ctx := context.Background()
+	report := &Report{
+		Servers: []*rabbitmq.Health{},
+	}
+	reachable := 0
+	healthy := 0
+	for _, server := range rmq.Servers {
+		slog.Debug("probing server", "address", server.Address, "port", server.Port)
+		for _, health := range rmq.Probe(ctx, server, cmd.Timeout) {
+			if health.Reachable() {
+				reachable++
 			}
-			if err := rmq.Err(); err != nil {
-				slog.Error("error from iterator", "error", err)
-				fmt.Printf(" %s (%s)", color.RedString("✘"), err.Error())
-				result = errors.Join(result, fmt.Errorf("error connecting to %s: %w", server.Address, err))
+			if health.Healthy() {
+				healthy++
 			}
-			fmt.Println()
-		}()
+			report.Servers = append(report.Servers, health)
+		}
 	}
 
+	var result error
+	switch {
+	case healthy == len(report.Servers):
+		report.Status = "ok"
+	case reachable > 0:
+		report.Status = "warning"
+		result = &common.ExitError{Code: common.ExitWarning, Err: fmt.Errorf("%d out of %d servers are not healthy", len(report.Servers)-healthy, len(report.Servers))}
+	default:
+		report.Status = "critical"
+		result = &common.ExitError{Code: common.ExitCritical, Err: errors.New("no server is reachable")}
+	}
+
+	if cmd.JSON {
+		fmt.Println(format.ToPrettyJSON(report))
+	} else {
+		printTable(report)
+	}
 	return result
 }
+
+// printTable prints the report as a per-server table, followed by the
+// list of errors (if any).
+func printTable(report *Report) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERVER\tTCP\tAMQP\tAUTH\tVHOST\tQUEUE\tEXCHANGES\tMESSAGES\tCONSUMERS\tLATENCY")
+	for _, h := range report.Servers {
+		ok := 0
+		for _, exchange := range h.Exchanges {
+			if exchange.Status == rabbitmq.StatusOK {
+				ok++
+			}
+		}
+		exchanges := fmt.Sprintf("%d/%d", ok, len(h.Exchanges))
+		if ok == len(h.Exchanges) {
+			exchanges = color.GreenString(exchanges)
+		} else {
+			exchanges = color.RedString(exchanges)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%.1fms\n",
+			endpoint(h),
+			mark(h.Connection), mark(h.Handshake), mark(h.Authentication), mark(h.VHostAccess), mark(h.Queue),
+			exchanges, h.Messages, h.Consumers, h.Latency)
+	}
+	writer.Flush()
+
+	for _, h := range report.Servers {
+		steps := []struct {
+			name    string
+			outcome rabbitmq.Outcome
+		}{
+			{"connection", h.Connection},
+			{"handshake", h.Handshake},
+			{"authentication", h.Authentication},
+			{"vhost", h.VHostAccess},
+			{"queue", h.Queue},
+		}
+		for _, exchange := range h.Exchanges {
+			steps = append(steps, struct {
+				name    string
+				outcome rabbitmq.Outcome
+			}{"exchange " + exchange.Name, exchange.Outcome})
+		}
+		for _, step := range steps {
+			if step.outcome.Status == rabbitmq.StatusFailed {
+				fmt.Printf("%s %s %s: %s\n", color.RedString("✘"), endpoint(h), step.name, step.outcome.Error)
+			}
+		}
+	}
+	fmt.Printf("%s: %s\n", color.YellowString("status"), report.Status)
+}
+
+func endpoint(h *rabbitmq.Health) string {
+	return fmt.Sprintf("%s:%d [%s]", h.Address, h.Port, h.VHost)
+}
+
+func mark(outcome rabbitmq.Outcome) string {
+	switch outcome.Status {
+	case rabbitmq.StatusOK:
+		return color.GreenString("✔")
+	case rabbitmq.StatusFailed:
+		return color.RedString("✘")
+	default:
+		return color.YellowString("-")
+	}
+}
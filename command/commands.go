@@ -2,20 +2,22 @@ package command
 
 import (
 	"github.com/dihedron/snoop/command/check"
+	"github.com/dihedron/snoop/command/count"
+	"github.com/dihedron/snoop/command/diff"
+	"github.com/dihedron/snoop/command/discover"
+	"github.com/dihedron/snoop/command/index"
+	"github.com/dihedron/snoop/command/inspect"
+	"github.com/dihedron/snoop/command/latency"
 	"github.com/dihedron/snoop/command/playback"
 	"github.com/dihedron/snoop/command/record"
+	"github.com/dihedron/snoop/command/split"
+	"github.com/dihedron/snoop/command/stats"
+	"github.com/dihedron/snoop/command/timeline"
 	"github.com/dihedron/snoop/command/version"
 )
 
 // Commands is the set of root command groups.
 type Commands struct {
-	// // Inspect provides facilities to inspect AMQP, Oslo and OpenStack messages.
-	// Inspect inspect.Inspect `command:"inspect" alias:"i" description:"Interactively inspect the contents of one or more files."`
-	// // Aggregate provides a way to split into multiple files a single log.
-	// Aggregate aggregate.Aggregate `command:"aggregate" alias:"A" description:"Split a large log into multiple files according to some pattern."`
-	// // Split provides a way to split into multiple files a single log.
-	// Split split.Split `command:"split" alias:"s" description:"Split a large log into multiple files according to some pattern."`
-
 	// // Admin provides a set of cluster administation tools.
 	// Admin admin.Admin `command:"administration" alias:"admin" alias:"a" description:"Run administration command against the cluster."`
 
@@ -31,12 +33,39 @@ type Commands struct {
 	// Check checks the connectivity to RabbitMQ.
 	Check check.Check `command:"check" alias:"c" description:"Try to connect to the RabbitMQ server."`
 
+	// Count counts the notifications per key in time windows.
+	Count count.Count `command:"count" alias:"cnt" description:"Count the notifications in one or more recordings on disk per key, in time windows."`
+
+	// Diff compares notifications about the same resource, or any two records.
+	Diff diff.Diff `command:"diff" alias:"df" description:"Compare the notifications in one or more recordings on disk."`
+
+	// Discover queries the RabbitMQ management API to generate the bindings.
+	Discover discover.Discover `command:"discover" alias:"d" description:"Discover the OpenStack notification exchanges via the RabbitMQ management API."`
+
 	// Record reads messages from RabbitMQ and outputs them (to disk or STDOUT).
 	Record record.Record `command:"record" alias:"r" description:"Read messages from RabbitMQ and output them (to disk or STDOUT)."`
 
+	// Index builds the index of one or more recordings.
+	Index index.Index `command:"index" alias:"idx" description:"Build or update the index of one or more recordings on disk."`
+
+	// Inspect provides facilities to inspect AMQP, Oslo and OpenStack messages.
+	Inspect inspect.Inspect `command:"inspect" alias:"i" description:"Interactively inspect the contents of one or more files."`
+
+	// Latency reports how long the operations in one or more recordings took.
+	Latency latency.Latency `command:"latency" alias:"lat" description:"Report the durations of the operations in one or more recordings on disk."`
+
 	// Playback reads messages from a text file and outputs them (to disk or STDOUT).
 	Playback playback.Playback `command:"playback" alias:"p" description:"Plays messages back from a recording on disk."`
 
+	// Split writes the notifications in one or more recordings to one file per key.
+	Split split.Split `command:"split" alias:"s" description:"Split the notifications in one or more recordings on disk into one file per key."`
+
+	// Stats reports statistics about the notifications in one or more recordings.
+	Stats stats.Stats `command:"stats" alias:"st" description:"Report statistics about the notifications in a recording on disk."`
+
+	// Timeline prints the lifecycle of a virtual machine.
+	Timeline timeline.Timeline `command:"timeline" alias:"tl" description:"Print the lifecycle of a virtual machine from one or more recordings on disk."`
+
 	// Version prints brokerd version information and exits.
 	//lint:ignore SA5008 commands can have multiple aliases
 	Version version.Version `command:"version" alias:"ver" alias:"v" description:"Show the command version and exit."`
@@ -69,3 +69,38 @@ func GetWriter(path string, truncate *bool) (io.Writer, error) {
 	slog.Debug("writer is ready")
 	return file, nil
 }
+
+// Exit codes returned by commands that can be used as monitoring probes;
+// they follow the Nagios plugin conventions.
+const (
+	// ExitOK means that everything is fine.
+	ExitOK = 0
+	// ExitWarning means that something is degraded but still working.
+	ExitWarning = 1
+	// ExitCritical means that something is not working.
+	ExitCritical = 2
+	// ExitUnknown means that the status could not be determined.
+	ExitUnknown = 3
+)
+
+// ExitError is an error that carries the exit code the application should
+// return to the caller.
+type ExitError struct {
+	// Code is the exit code.
+	Code int
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the message of the underlying error.
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
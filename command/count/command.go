@@ -0,0 +1,136 @@
+package count
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/generator/textfile"
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/openstack/stats"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/dihedron/snoop/transform/transformers"
+	"github.com/fatih/color"
+)
+
+// Count is the command that reads the messages from one or more recordings
+// and counts the notifications per key in time windows; windows are driven
+// by the notification timestamps, so the counts are the same as they would
+// have been live.
+// ./snoop count --event-type compute.instance.create.error --by host --size 5m 20220818.amqp.messages
+type Count struct {
+	base.Command
+	// EventTypes are the event types of the notifications counted; if
+	// empty, all notifications are counted.
+	EventTypes []string `short:"e" long:"event-type" description:"Count only the notifications of the given event type (can be repeated)."`
+	// By is the key the notifications are counted by.
+	By string `short:"b" long:"by" description:"The key the notifications are counted by." choice:"host" choice:"event_type" choice:"project_id" choice:"instance_id" default:"host"`
+	// Size is the size of the windows.
+	Size time.Duration `long:"size" description:"The size of the windows (e.g. 5m)." default:"5m"`
+	// Slide is how often a window starts; if 0 or equal to the size, the
+	// windows do not overlap.
+	Slide time.Duration `long:"slide" description:"How often a window starts, for overlapping windows (0 for contiguous ones)." default:"0"`
+	// Lateness is how late a notification can arrive, compared to the latest
+	// one, and still be counted.
+	Lateness time.Duration `long:"lateness" description:"How late a notification can arrive, compared to the latest one, and still be counted." default:"1m"`
+	// Output is the format of the counts.
+	Output string `short:"o" long:"output" description:"The format of the counts." choice:"text" choice:"json" default:"text"`
+}
+
+// Execute is the real implementation of the Count command.
+func (cmd *Count) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+	if cmd.Size <= 0 {
+		slog.Error("invalid window size", "size", cmd.Size)
+		return fmt.Errorf("invalid window size: %s", cmd.Size)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	windower := transformers.SlidingWindow(
+		cmd.Size,
+		cmd.Slide,
+		func(n notification.Notification) time.Time {
+			return n.Summary().Timestamp
+		},
+		keyer(cmd.By),
+		transformers.WithAllowedLateness(cmd.Lateness),
+	)
+	xform := chain.Of3(
+		notification.Decode(),
+		transformers.AcceptIf(func(n notification.Notification) bool {
+			return len(cmd.EventTypes) == 0 || slices.Contains(cmd.EventTypes, n.Summary().EventType)
+		}),
+		windower.Add(),
+	)
+	files := textfile.New()
+	counts, err := chain.Run(ctx, files.AllLinesContext(ctx, args...), xform, cmd.print, chain.WithErrorHandler(func(line string, err error) error {
+		slog.Debug("error decoding line", "line", line, "error", err)
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	cmd.print(windower.Flush())
+	slog.Debug("counting complete", "stats", counts, "late", windower.Late())
+	if late := windower.Late(); late > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d notifications arrived too late to be counted\n", color.RedString("late"), late)
+	}
+	return nil
+}
+
+// row is the count of the notifications with the same key in a window.
+type row struct {
+	Key   string    `json:"key" yaml:"key"`
+	Start time.Time `json:"start" yaml:"start"`
+	End   time.Time `json:"end" yaml:"end"`
+	Count int       `json:"count" yaml:"count"`
+}
+
+// print prints the count of each of the given windows.
+func (cmd *Count) print(windows []*transformers.Window[string, notification.Notification]) error {
+	for _, window := range windows {
+		if cmd.Output == "json" {
+			fmt.Println(format.ToJSON(row{Key: window.Key, Start: window.Start, End: window.End, Count: window.Count()}))
+			continue
+		}
+		fmt.Printf("%s - %s  %-40s %d\n", color.YellowString(window.Start.Format(time.RFC3339)), color.YellowString(window.End.Format(time.RFC3339)), window.Key, window.Count())
+	}
+	return nil
+}
+
+// keyer returns the function that extracts the given key from the
+// notifications.
+func keyer(by string) func(n notification.Notification) string {
+	switch by {
+	case "event_type":
+		return func(n notification.Notification) string {
+			return n.Summary().EventType
+		}
+	case "project_id":
+		return func(n notification.Notification) string {
+			return cmp.Or(n.Summary().ProjectID, "unknown")
+		}
+	case "instance_id":
+		return func(n notification.Notification) string {
+			return cmp.Or(notification.InstanceID(n), "unknown")
+		}
+	default:
+		return func(n notification.Notification) string {
+			return cmp.Or(stats.Host(n), "unknown")
+		}
+	}
+}
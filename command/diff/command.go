@@ -0,0 +1,173 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/generator/textfile"
+	"github.com/dihedron/snoop/openstack/diff"
+	"github.com/dihedron/snoop/openstack/index"
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/fatih/color"
+)
+
+// Diff is the command that compares the notifications in one or more
+// recordings: either each notification about an instance, port or security
+// group with the previous one about the same resource, or two records.
+// ./snoop diff --kind instance 20220818.amqp.messages
+// ./snoop diff --record 3 --record 7 20220818.amqp.messages
+type Diff struct {
+	base.Command
+	// Records are the positions of the two records to compare, starting
+	// at 1, across all the recordings.
+	Records []int `short:"r" long:"record" description:"The position of a record to compare, starting at 1 across all recordings (give it twice)."`
+	// All is used to compare whole records, not just their payloads.
+	All bool `short:"a" long:"all" description:"Whether whole records should be compared, instead of just their payloads." optional:"yes"`
+	// Kind is the kind of resources whose notifications are compared.
+	Kind string `short:"k" long:"kind" description:"Compare only the notifications about resources of the given kind." choice:"instance" choice:"port" choice:"security_group"`
+	// ID is the ID of the resource whose notifications are compared.
+	ID string `long:"id" description:"Compare only the notifications about the resource with the given ID."`
+	// MaxResources is the maximum number of resources tracked at once.
+	MaxResources int `long:"max-resources" description:"The maximum number of resources tracked at once, forgetting those notified least recently first (0 for no limit)." default:"100000"`
+	// Output is the format of the changes.
+	Output string `short:"o" long:"output" description:"The format of the changes." choice:"text" choice:"json" default:"text"`
+}
+
+// Execute is the real implementation of the Diff command.
+func (cmd *Diff) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch len(cmd.Records) {
+	case 0:
+		return cmd.successive(ctx, args)
+	case 2:
+		return cmd.records(ctx, args)
+	default:
+		slog.Error("invalid number of records", "records", cmd.Records)
+		return errors.New("--record must be given exactly twice")
+	}
+}
+
+// successive prints the changes in each notification about a resource
+// since the previous one about the same resource.
+func (cmd *Diff) successive(ctx context.Context, recordings []string) error {
+	tracker := diff.NewTracker(cmd.MaxResources)
+	files := textfile.New()
+	stats, err := chain.Run(ctx, files.AllLinesContext(ctx, recordings...), notification.Decode(), func(n notification.Notification) error {
+		update := tracker.Track(n)
+		if update == nil || update.First || len(update.Changes) == 0 ||
+			(cmd.Kind != "" && update.Kind != cmd.Kind) || (cmd.ID != "" && update.ID != cmd.ID) {
+			return nil
+		}
+		if cmd.Output == "json" {
+			fmt.Println(format.ToJSON(update))
+			return nil
+		}
+		summary := n.Summary()
+		fmt.Printf("%s %s %s %s\n", color.YellowString(summary.Timestamp.Format(time.RFC3339Nano)), summary.EventType, update.Kind, update.ID)
+		for _, change := range update.Changes {
+			fmt.Printf("    %s\n", change)
+		}
+		return nil
+	}, chain.WithErrorHandler(func(line string, err error) error {
+		slog.Debug("error decoding line", "line", line, "error", err)
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	slog.Debug("comparison complete", "stats", stats, "resources", tracker.Len())
+	return nil
+}
+
+// records prints the changes between the two records given on the
+// command line.
+func (cmd *Diff) records(ctx context.Context, recordings []string) error {
+	indexes, err := open(ctx, recordings)
+	if err != nil {
+		return err
+	}
+	notifications := [2]notification.Notification{}
+	for i, position := range cmd.Records {
+		line, err := record(ctx, indexes, position)
+		if err != nil {
+			return err
+		}
+		if notifications[i], err = notification.Decode()(line); err != nil {
+			slog.Error("error decoding record", "record", position, "error", err)
+			return fmt.Errorf("record %d: %w", position, err)
+		}
+	}
+	var from, to any = notifications[0], notifications[1]
+	if !cmd.All {
+		from, to = diff.Payload(notifications[0]), diff.Payload(notifications[1])
+	}
+	changes := diff.Compare(from, to)
+	if cmd.Output == "json" {
+		fmt.Println(format.ToPrettyJSON(changes))
+		return nil
+	}
+	fmt.Printf("%s → %s\n",
+		color.YellowString("#%d %s", cmd.Records[0], notifications[0].Summary().EventType),
+		color.YellowString("#%d %s", cmd.Records[1], notifications[1].Summary().EventType))
+	for _, change := range changes {
+		fmt.Printf("    %s\n", change)
+	}
+	return nil
+}
+
+// open opens the indexes of the recordings, building those that are
+// missing or stale, and saves them so that they are not built again; an
+// index that cannot be saved is still used.
+func open(ctx context.Context, recordings []string) ([]*index.Index, error) {
+	indexes := make([]*index.Index, 0, len(recordings))
+	for _, recording := range recordings {
+		i, err := index.Open(ctx, recording)
+		if err != nil {
+			slog.Error("error indexing recording", "path", recording, "error", err)
+			return nil, err
+		}
+		if err := i.Save(); err != nil {
+			slog.Warn("error saving index", "path", index.Path(recording), "error", err)
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, nil
+}
+
+// record reads the record at the given position, starting at 1, across
+// the recordings, through their indexes.
+func record(ctx context.Context, indexes []*index.Index, position int) (string, error) {
+	if position < 1 {
+		slog.Error("invalid record position", "record", position)
+		return "", fmt.Errorf("invalid record: %d", position)
+	}
+	offset := position - 1
+	for _, i := range indexes {
+		if offset >= len(i.Entries) {
+			offset -= len(i.Entries)
+			continue
+		}
+		for line := range i.Lines(ctx, i.Entries[offset:offset+1]) {
+			return line, nil
+		}
+		return "", fmt.Errorf("record %d cannot be read from %s", position, i.Recording)
+	}
+	slog.Error("record out of range", "record", position)
+	return "", fmt.Errorf("record %d is out of range", position)
+}
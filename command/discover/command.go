@@ -0,0 +1,216 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/dihedron/rawdata"
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/command/common"
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/generator/rabbitmq"
+	"github.com/dihedron/snoop/management"
+	"github.com/fatih/color"
+)
+
+// Discover is the command that queries the RabbitMQ management API to find
+// the OpenStack notification exchanges and topics, and generates a
+// ready-to-use bindings section for the profile.
+// ./snoop discover --profile=_tests/snoop-lab.yaml bindings.yaml
+type Discover struct {
+	base.Command
+	// Profile contains the path to the configuration file to use to connect to
+	// a RabbitMQ instance.
+	Profile string `short:"p" long:"profile" description:"The path to the file containing the RabbitMQ connection info (aka profile)." required:"yes" env:"SNOOP_PROFILE"`
+	// URL is the (optional) base URL of the management API; it overrides the
+	// one in the profile; if neither is given, it is derived from the first
+	// server in the profile.
+	URL *string `short:"u" long:"url" description:"The base URL of the RabbitMQ management API (e.g. http://rmq-1:15672)." env:"SNOOP_MANAGEMENT_URL"`
+	// VHost is the virtual host to inspect; if empty, all virtual hosts are
+	// inspected.
+	VHost string `short:"V" long:"vhost" description:"The virtual host to inspect (all virtual hosts if not given)." env:"SNOOP_VHOST"`
+	// All is used to include all exchanges with bindings, not only those
+	// carrying OpenStack notifications.
+	All bool `short:"a" long:"all" description:"Whether to include all exchanges with bindings, not only OpenStack notification ones." optional:"yes"`
+	// Truncate is used to specify whether the output file (if one is specified)
+	// should be truncated before writing to it.
+	Truncate *bool `short:"t" long:"truncate" description:"Whether the output file should be truncated or appended to (default)." optional:"yes" env:"SNOOP_TRUNCATE"`
+}
+
+// Execute is the real implementation of the Discover command.
+func (cmd *Discover) Execute(args []string) error {
+	slog.Debug("discovering topology via RabbitMQ management API")
+
+	if err := common.Validate(*cmd); err != nil {
+		slog.Error("error validating command struct", "error", err)
+		return err
+	}
+
+	rmq := &rabbitmq.RabbitMQ{}
+	if err := rawdata.UnmarshalInto("@"+cmd.Profile, rmq); err != nil {
+		slog.Error("error reading connection info", "error", err)
+		return err
+	}
+	slog.Debug("RabbitMQ connection info file in JSON format", "configuration", format.ToJSON(rmq))
+
+	client, err := cmd.client(rmq)
+	if err != nil {
+		slog.Error("error creating management API client", "error", err)
+		return err
+	}
+
+	ctx := context.Background()
+	exchanges, err := client.Exchanges(ctx, cmd.VHost)
+	if err != nil {
+		slog.Error("error retrieving exchanges", "error", err)
+		return err
+	}
+	bindings, err := client.Bindings(ctx, cmd.VHost)
+	if err != nil {
+		slog.Error("error retrieving bindings", "error", err)
+		return err
+	}
+	queues, err := client.Queues(ctx, cmd.VHost)
+	if err != nil {
+		slog.Error("error retrieving queues", "error", err)
+		return err
+	}
+	slog.Info("topology retrieved", "exchanges", len(exchanges), "bindings", len(bindings), "queues", len(queues))
+
+	discovered := management.Discover(exchanges, bindings, queues, cmd.All)
+
+	// print a summary of the findings on STDERR so it does not get mixed
+	// up with the generated YAML when writing to STDOUT
+	fmt.Fprintf(os.Stderr, "%s (%d exchanges, %d bindings, %d queues):\n", color.YellowString("topology"), len(exchanges), len(bindings), len(queues))
+	for _, d := range discovered {
+		note := ""
+		if d.Defaulted {
+			note = color.RedString(" (no notification queue bound, using defaults)")
+		}
+		fmt.Fprintf(os.Stderr, "  %s %s [%s]%s\n", color.BlueString(d.Exchange.VHost), color.GreenString(d.Exchange.Name), d.Exchange.Type, note)
+		for _, topic := range d.Topics {
+			fmt.Fprintf(os.Stderr, "    - %s\n", topic)
+		}
+		// notifications sent to queues that nobody consumes pile up on the
+		// broker
+		for _, queue := range d.Queues {
+			note := ""
+			if queue.Consumers == 0 {
+				note = color.RedString(" (no consumers)")
+			}
+			fmt.Fprintf(os.Stderr, "    queue %s: %d messages, %d consumers%s\n", color.GreenString(queue.Name), queue.Messages, queue.Consumers, note)
+		}
+	}
+	if len(discovered) == 0 {
+		fmt.Fprintf(os.Stderr, "  %s\n", color.RedString("no notification exchanges found"))
+		return errors.New("no notification exchanges found")
+	}
+
+	// get output path
+	path := "-" // stdout
+	if len(args) > 0 {
+		path = args[0]
+	}
+	writer, err := common.GetWriter(path, cmd.Truncate)
+	if err != nil {
+		slog.Error("error getting writer", "error", err)
+		return err
+	}
+	if w, ok := writer.(io.Closer); ok {
+		defer w.Close()
+	}
+
+	profile := struct {
+		Bindings []rabbitmq.Binding `json:"bindings" yaml:"bindings"`
+	}{
+		Bindings: ToBindings(discovered),
+	}
+	fmt.Fprintf(writer, "%s", format.ToYAML(profile))
+	return nil
+}
+
+// client returns a management API client, using the URL on the command
+// line, or the one in the profile, or one derived from the first server.
+func (cmd *Discover) client(rmq *rabbitmq.RabbitMQ) (*management.Client, error) {
+	var (
+		url      string
+		username *string
+		password *string
+		tlsinfo  *rabbitmq.TLSInfo
+	)
+	if rmq.Management != nil {
+		url = rmq.Management.URL
+		username = rmq.Management.Username
+		password = rmq.Management.Password
+		tlsinfo = rmq.Management.TLSInfo
+	}
+	if len(rmq.Servers) > 0 {
+		server := rmq.Servers[0]
+		if url == "" {
+			proto := "http"
+			if server.TLSInfo != nil && server.TLSInfo.EnableTLS {
+				proto = "https"
+			}
+			url = fmt.Sprintf("%s://%s:%d", proto, server.Address, management.DefaultPort)
+		}
+		if username == nil && password == nil {
+			username = server.Username
+			password = server.Password
+		}
+		if tlsinfo == nil {
+			tlsinfo = server.TLSInfo
+		}
+	}
+	if cmd.URL != nil && *cmd.URL != "" {
+		url = *cmd.URL
+	}
+	if url == "" {
+		return nil, errors.New("no management API URL available")
+	}
+	slog.Info("using management API", "url", url)
+
+	options := []management.Option{}
+	if username != nil && password != nil {
+		options = append(options, management.WithCredentials(*username, *password))
+	}
+	if tlsinfo != nil && strings.HasPrefix(url, "https") {
+		config, err := tlsinfo.Config()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, management.WithTLS(config))
+	}
+	return management.New(url, options...)
+}
+
+// ToBindings converts the discovered notification exchanges into a set of
+// bindings that can be pasted into a profile.
+func ToBindings(discovered []management.Notifications) []rabbitmq.Binding {
+	bindings := []rabbitmq.Binding{}
+	for _, d := range discovered {
+		exchange := &rabbitmq.Exchange{
+			Name:       d.Exchange.Name,
+			Durable:    d.Exchange.Durable,
+			AutoDelete: d.Exchange.AutoDelete,
+		}
+		if err := exchange.Type.Parse(d.Exchange.Type); err != nil {
+			slog.Warn("unsupported exchange type, assuming topic", "exchange", d.Exchange.Name, "type", d.Exchange.Type)
+			exchange.Type = rabbitmq.ExchangeTypeTopic
+		}
+		binding := rabbitmq.Binding{
+			Exchange:    exchange,
+			RoutingKeys: d.Topics,
+		}
+		// the root virtual host is the default, no need to specify it
+		if d.Exchange.VHost != "/" {
+			binding.VHost = d.Exchange.VHost
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings
+}
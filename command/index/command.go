@@ -0,0 +1,76 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/openstack/index"
+	"github.com/fatih/color"
+)
+
+// Index is the command that builds the index of one or more recordings, or
+// brings it up to date; the index is written next to the recording.
+// ./snoop index 20220818.amqp.messages
+type Index struct {
+	base.Command
+	// Rebuild is used to index the recordings from scratch, even if they
+	// already have an index.
+	Rebuild bool `short:"r" long:"rebuild" description:"Whether to rebuild existing indexes from scratch." optional:"yes"`
+}
+
+// Execute is the real implementation of the Index command.
+func (cmd *Index) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, recording := range args {
+		slog.Debug("indexing recording", "path", recording, "rebuild", cmd.Rebuild)
+		start := time.Now()
+		var (
+			i   *index.Index
+			err error
+		)
+		if cmd.Rebuild {
+			i, err = index.Build(ctx, recording)
+		} else {
+			i, err = index.Open(ctx, recording)
+		}
+		if err != nil {
+			slog.Error("error indexing recording", "path", recording, "error", err)
+			return err
+		}
+		if err := i.Save(); err != nil {
+			return err
+		}
+		var first, last time.Time
+		for _, entry := range i.Entries {
+			if entry.Timestamp.IsZero() {
+				continue
+			}
+			if first.IsZero() || entry.Timestamp.Before(first) {
+				first = entry.Timestamp
+			}
+			if entry.Timestamp.After(last) {
+				last = entry.Timestamp
+			}
+		}
+		fmt.Printf("%s: %d messages", color.YellowString(index.Path(recording)), len(i.Entries))
+		if !first.IsZero() {
+			fmt.Printf(" from %s to %s", first.Format(time.RFC3339), last.Format(time.RFC3339))
+		}
+		fmt.Printf(" (%s)\n", time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
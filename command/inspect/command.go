@@ -0,0 +1,68 @@
+package inspect
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/openstack/index"
+)
+
+// Inspect is the command that loads one or more recordings and lets the user
+// browse them interactively, record by record, looking at each message as it
+// is unwrapped from AMQP to Oslo to OpenStack notification.
+// ./snoop inspect 20220818.amqp.messages
+type Inspect struct {
+	base.Command
+	// Filter is the condition the notifications must satisfy to be listed,
+	// as a template pipeline (e.g. 'hasPrefix "compute." .EventType').
+	Filter string `short:"f" long:"filter" description:"List only the notifications matching the given condition, as a template pipeline."`
+	// RequestID is the ID of the request whose notifications are listed.
+	RequestID string `long:"request-id" description:"List only the notifications of the given request (e.g. req-abc)."`
+}
+
+// Execute is the real implementation of the Inspect command.
+func (cmd *Inspect) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+
+	// the records are listed from the index of the recordings, which is
+	// built on the fly (and saved) for those that are not indexed yet
+	ctx := context.Background()
+	records := []record{}
+	for _, recording := range args {
+		i, err := index.Open(ctx, recording)
+		if err != nil {
+			slog.Error("error indexing recording", "path", recording, "error", err)
+			return err
+		}
+		// save the index so that it is not built again next time; an index
+		// that cannot be saved is still used
+		if err := i.Save(); err != nil {
+			slog.Warn("error saving index", "path", index.Path(recording), "error", err)
+		}
+		for _, entry := range i.Entries {
+			records = append(records, record{index: i, entry: entry})
+		}
+	}
+	slog.Debug("recordings loaded", "files", len(args), "records", len(records))
+
+	inspector := newInspector(records)
+	defer inspector.close()
+	if cmd.RequestID != "" {
+		inspector.follow(cmd.RequestID)
+	}
+	if cmd.Filter != "" {
+		if err := inspector.apply(cmd.Filter); err != nil {
+			return err
+		}
+	}
+	if err := inspector.run(nil); err != nil {
+		slog.Error("error running inspector", "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,589 @@
+package inspect
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/goccy/go-json"
+	"github.com/rivo/tview"
+
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/dihedron/snoop/openstack/index"
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/openstack/oslo"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/dihedron/snoop/transform/transformers"
+)
+
+// help is the list of keys shown in the status bar.
+const help = "[yellow]/[-] search  [yellow]n/N[-] next/previous  [yellow]f[-] filter  [yellow]r[-] follow request  [yellow]c[-] clear  [yellow]1-3[-] layer  [yellow]tab[-] focus  [yellow]q[-] quit"
+
+// layers are the regions of the details view, one per unwrapping layer.
+var layers = []string{"amqp", "oslo", "notification"}
+
+// progressEvery is the number of records a background job goes through
+// between updates of its progress in the status bar.
+const progressEvery = 4096
+
+// record is a message in one of the recordings being inspected.
+type record struct {
+	index *index.Index
+	entry index.Entry
+}
+
+// selection is the set of conditions the listed records satisfy.
+type selection struct {
+	// requestID is the ID of the request of the records, if any.
+	requestID string
+	// filter is the condition the notifications satisfy, as a template
+	// pipeline, if any.
+	filter string
+	// match decodes a record and matches it against the filter.
+	match chain.X[string, notification.Notification]
+}
+
+// String returns the description of the selection.
+func (s selection) String() string {
+	parts := []string{}
+	if s.requestID != "" {
+		parts = append(parts, "request "+s.requestID)
+	}
+	if s.filter != "" {
+		parts = append(parts, "filter "+s.filter)
+	}
+	if len(parts) == 0 {
+		return "all records"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// inspector is the terminal UI that lists the records and shows the details
+// of the selected one. Filtering and searching decode the records, which
+// takes a while on large recordings, so they run in the background and
+// update the UI once done; all the other fields are only accessed on the UI
+// goroutine.
+type inspector struct {
+	records []record
+	// visible are the positions in records of those currently listed; it
+	// is replaced, never modified, so that background jobs can read it.
+	visible []int
+	// current is the selection of the records currently listed.
+	current selection
+	search  string
+	layer   string
+	// filtering and searching cancel the background jobs, if running.
+	filtering context.CancelFunc
+	searching context.CancelFunc
+
+	// lock protects files, which are the recordings opened for random
+	// access, as they are read by the background jobs too.
+	lock  sync.Mutex
+	files map[string]*os.File
+
+	app     *tview.Application
+	table   *tview.Table
+	details *tview.TextView
+	status  *tview.TextView
+	prompt  *tview.InputField
+}
+
+// newInspector creates the terminal UI to inspect the given records.
+func newInspector(records []record) *inspector {
+	in := &inspector{
+		records: records,
+		files:   map[string]*os.File{},
+		layer:   "notification",
+		app:     tview.NewApplication(),
+		table:   tview.NewTable(),
+		details: tview.NewTextView(),
+		status:  tview.NewTextView(),
+		prompt:  tview.NewInputField(),
+	}
+	in.clear()
+
+	in.table.SetContent(&rows{in: in})
+	in.table.SetFixed(1, 0)
+	in.table.SetSelectable(true, false)
+	in.table.SetBorder(true)
+	in.table.SetTitle(" records ")
+	in.table.SetSelectionChangedFunc(func(row int, _ int) {
+		in.show(row - 1)
+	})
+
+	in.details.SetDynamicColors(true)
+	in.details.SetRegions(true)
+	in.details.SetBorder(true)
+	in.details.SetTitle(" details ")
+
+	in.status.SetDynamicColors(true)
+	in.prompt.SetFieldBackgroundColor(tcell.ColorDefault)
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(in.table, 0, 1, true).
+		AddItem(in.details, 0, 2, false).
+		AddItem(in.status, 1, 0, false).
+		AddItem(in.prompt, 1, 0, false)
+	in.app.SetRoot(layout, true)
+	in.app.SetInputCapture(in.keys)
+	return in
+}
+
+// run runs the terminal UI until the user quits; if screen is nil, the
+// terminal is used.
+func (in *inspector) run(screen tcell.Screen) error {
+	if screen != nil {
+		in.app.SetScreen(screen)
+	}
+	in.refresh()
+	return in.app.Run()
+}
+
+// close stops the background jobs and closes the recordings.
+func (in *inspector) close() {
+	in.cancel(&in.filtering)
+	in.cancel(&in.searching)
+	in.lock.Lock()
+	defer in.lock.Unlock()
+	for _, file := range in.files {
+		file.Close()
+	}
+}
+
+// keys handles the keys that are not for the prompt.
+func (in *inspector) keys(event *tcell.EventKey) *tcell.EventKey {
+	if in.app.GetFocus() == in.prompt {
+		return event
+	}
+	switch event.Key() {
+	case tcell.KeyTab, tcell.KeyBacktab:
+		if in.app.GetFocus() == in.table {
+			in.app.SetFocus(in.details)
+		} else {
+			in.app.SetFocus(in.table)
+		}
+		return nil
+	case tcell.KeyEscape:
+		if in.filtering != nil || in.searching != nil {
+			in.cancel(&in.filtering)
+			in.cancel(&in.searching)
+			in.message("[yellow]cancelled")
+		}
+		in.app.SetFocus(in.table)
+		return nil
+	case tcell.KeyRune:
+	default:
+		return event
+	}
+	switch event.Rune() {
+	case 'q':
+		in.app.Stop()
+	case '/':
+		in.ask("search: ", func(text string) {
+			in.search = strings.ToLower(text)
+			in.find(1)
+		})
+	case 'n':
+		in.find(1)
+	case 'N':
+		in.find(-1)
+	case 'f':
+		in.ask("filter: ", func(text string) {
+			if err := in.apply(text); err != nil {
+				in.message("[red]invalid filter: %s", tview.Escape(err.Error()))
+			}
+		})
+	case 'r':
+		if r, ok := in.selected(); ok {
+			if r.entry.RequestID == "" {
+				in.message("[red]no request ID in the selected record")
+				return nil
+			}
+			in.follow(r.entry.RequestID)
+		}
+	case 'c':
+		in.clear()
+	case '1', '2', '3':
+		in.layer = layers[event.Rune()-'1']
+		in.details.Highlight(in.layer)
+		in.details.ScrollToHighlight()
+	default:
+		return event
+	}
+	return nil
+}
+
+// ask shows the prompt with the given label, and calls done with the text
+// entered, unless the user cancels it.
+func (in *inspector) ask(label string, done func(text string)) {
+	in.prompt.SetLabel(label)
+	in.prompt.SetText("")
+	in.prompt.SetDoneFunc(func(key tcell.Key) {
+		text := in.prompt.GetText()
+		in.prompt.SetLabel("")
+		in.prompt.SetText("")
+		in.app.SetFocus(in.table)
+		if key == tcell.KeyEnter {
+			done(text)
+		}
+	})
+	in.app.SetFocus(in.prompt)
+}
+
+// clear lists all the records.
+func (in *inspector) clear() {
+	in.narrow(selection{})
+}
+
+// follow lists only the records of the given request that satisfy the
+// current filter, if any.
+func (in *inspector) follow(requestID string) {
+	s := in.current
+	s.requestID = requestID
+	in.narrow(s)
+}
+
+// apply lists only the records of the current request, if any, whose
+// notification satisfies the given condition, as a template pipeline; an
+// empty condition removes the filter.
+func (in *inspector) apply(condition string) error {
+	s := in.current
+	s.filter, s.match = condition, nil
+	if condition != "" {
+		match, err := transformers.Match[notification.Notification](condition)
+		if err != nil {
+			return err
+		}
+		s.match = chain.Of2(notification.Decode(), match)
+	}
+	in.narrow(s)
+	return nil
+}
+
+// narrow lists the records in the given selection; the records of the
+// request are selected through the index, whereas the filter decodes them
+// in the background, and the list is replaced once it is done.
+func (in *inspector) narrow(s selection) {
+	in.cancel(&in.filtering)
+	in.cancel(&in.searching)
+	candidates := make([]int, 0, len(in.records))
+	for i, r := range in.records {
+		if s.requestID == "" || r.entry.RequestID == s.requestID {
+			candidates = append(candidates, i)
+		}
+	}
+	if s.match == nil {
+		in.current, in.visible = s, candidates
+		in.refresh()
+		return
+	}
+	in.message("[yellow]filtering...")
+	in.start(&in.filtering, func(ctx context.Context) {
+		visible := []int{}
+		for n, i := range candidates {
+			if ctx.Err() != nil {
+				return
+			}
+			if _, err := s.match(in.line(in.records[i])); err == nil {
+				visible = append(visible, i)
+			}
+			in.progress(ctx, "filtering", n+1, len(candidates))
+		}
+		in.done(ctx, &in.filtering, func() {
+			in.current, in.visible = s, visible
+			in.refresh()
+		})
+	})
+}
+
+// find selects the next (or previous, if step is negative) listed record
+// that contains the search text (case insensitive), wrapping around; the
+// records are searched in the background.
+func (in *inspector) find(step int) {
+	if in.search == "" || len(in.visible) == 0 {
+		return
+	}
+	row, _ := in.table.GetSelection()
+	current, visible, search := row-1, in.visible, in.search
+	in.message("[yellow]searching...")
+	in.start(&in.searching, func(ctx context.Context) {
+		for n := 1; n <= len(visible); n++ {
+			if ctx.Err() != nil {
+				return
+			}
+			i := ((current+n*step)%len(visible) + len(visible)) % len(visible)
+			if strings.Contains(strings.ToLower(in.text(in.records[visible[i]])), search) {
+				in.done(ctx, &in.searching, func() {
+					in.table.Select(i+1, 0)
+				})
+				return
+			}
+			in.progress(ctx, "searching", n, len(visible))
+		}
+		in.done(ctx, &in.searching, func() {
+			in.message("[red]not found: %s", tview.Escape(search))
+		})
+	})
+}
+
+// start runs the given job on its own goroutine, after cancelling the one
+// in the given slot, if any; the job must return as soon as its context is
+// done.
+func (in *inspector) start(slot *context.CancelFunc, job func(ctx context.Context)) {
+	in.cancel(slot)
+	ctx, cancel := context.WithCancel(context.Background())
+	*slot = cancel
+	go job(ctx)
+}
+
+// cancel cancels the job in the given slot, if any.
+func (in *inspector) cancel(slot *context.CancelFunc) {
+	if *slot != nil {
+		(*slot)()
+		*slot = nil
+	}
+}
+
+// progress shows the progress of a background job in the status bar, every
+// so often.
+func (in *inspector) progress(ctx context.Context, action string, n int, total int) {
+	if n%progressEvery != 0 || ctx.Err() != nil {
+		return
+	}
+	in.app.QueueUpdateDraw(func() {
+		if ctx.Err() == nil {
+			in.message("[yellow]%s: %d/%d[-] (esc to cancel)", action, n, total)
+		}
+	})
+}
+
+// done applies the result of a background job on the UI goroutine, unless
+// the job has been cancelled in the meantime, and frees its slot.
+func (in *inspector) done(ctx context.Context, slot *context.CancelFunc, apply func()) {
+	if ctx.Err() != nil {
+		return
+	}
+	in.app.QueueUpdateDraw(func() {
+		if ctx.Err() != nil {
+			return
+		}
+		in.cancel(slot)
+		apply()
+	})
+}
+
+// refresh lists the visible records and selects the first one.
+func (in *inspector) refresh() {
+	in.table.SetTitle(fmt.Sprintf(" records (%s) ", tview.Escape(in.current.String())))
+	in.table.ScrollToBeginning()
+	if len(in.visible) > 0 {
+		in.table.Select(1, 0)
+	}
+	in.show(0)
+}
+
+// message shows a message in the status bar, until the next selection.
+func (in *inspector) message(text string, args ...any) {
+	in.status.SetText(fmt.Sprintf(text, args...))
+}
+
+// selected returns the selected record, if any.
+func (in *inspector) selected() (record, bool) {
+	row, _ := in.table.GetSelection()
+	if row < 1 || row > len(in.visible) {
+		return record{}, false
+	}
+	return in.records[in.visible[row-1]], true
+}
+
+// line reads the message of the given record from its recording.
+func (in *inspector) line(r record) string {
+	in.lock.Lock()
+	file, ok := in.files[r.index.Recording]
+	if !ok {
+		var err error
+		if file, err = os.Open(r.index.Recording); err != nil {
+			in.lock.Unlock()
+			slog.Error("error opening recording", "path", r.index.Recording, "error", err)
+			return ""
+		}
+		in.files[r.index.Recording] = file
+	}
+	in.lock.Unlock()
+	buffer := make([]byte, r.entry.Length)
+	if _, err := file.ReadAt(buffer, r.entry.Offset); err != nil {
+		slog.Error("error reading message from recording", "path", r.index.Recording, "offset", r.entry.Offset, "error", err)
+		return ""
+	}
+	return string(buffer)
+}
+
+// text returns the searchable text of the given record: the recorded
+// message, along with its body, which is recorded base64-encoded.
+func (in *inspector) text(r record) string {
+	line := in.line(r)
+	if message, err := amqp.JSONToMessage()([]byte(line)); err == nil {
+		return line + "\n" + string(message.Body)
+	}
+	return line
+}
+
+// show shows the details of the i-th listed record.
+func (in *inspector) show(i int) {
+	in.status.SetText(fmt.Sprintf("%d/%d  %s", min(i+1, len(in.visible)), len(in.visible), help))
+	if i < 0 || i >= len(in.visible) {
+		in.details.SetText("")
+		return
+	}
+	r := in.records[in.visible[i]]
+	in.details.SetTitle(fmt.Sprintf(" %s @ %d ", tview.Escape(r.index.Recording), r.entry.Offset))
+	in.details.SetText(describe(in.line(r)))
+	in.details.Highlight(in.layer)
+	in.details.ScrollToHighlight()
+}
+
+// describe returns the message at each unwrapping layer, as colored YAML,
+// in a region per layer.
+func describe(line string) string {
+	var builder strings.Builder
+	section := func(region string, title string, body string) {
+		fmt.Fprintf(&builder, "[\"%s\"][::b]── %s ──[::-][\"\"]\n%s\n", region, tview.Escape(title), body)
+	}
+	failure := func(err error) string {
+		return "[red]" + tview.Escape(err.Error()) + "[-]\n"
+	}
+
+	message, err := amqp.JSONToMessage()([]byte(line))
+	if err != nil {
+		section("amqp", "amqp.Message", failure(err))
+		return builder.String()
+	}
+	// the body is shown decoded in the next layer
+	envelope := *message
+	envelope.Body = nil
+	section("amqp", "amqp.Message", colorize(format.ToYAML(&envelope))+fmt.Sprintf("[yellow]body[-]: [grey](%d bytes)[-]\n", len(message.Body)))
+
+	o, err := oslo.MessageToOslo(false)(message)
+	if err != nil {
+		section("oslo", "oslo.Oslo", failure(err))
+		return builder.String()
+	}
+	payload := map[string]any{}
+	if err := json.Unmarshal([]byte(o.Payload), &payload); err != nil {
+		section("oslo", "oslo.Oslo", failure(err))
+		return builder.String()
+	}
+	section("oslo", "oslo.Oslo "+o.Version, colorize(format.ToYAML(payload)))
+
+	n, err := notification.OsloToNotification(false)(o)
+	if err != nil {
+		section("notification", "notification", failure(err))
+		return builder.String()
+	}
+	section("notification", format.TypeAsString(n), colorize(format.ToYAML(n)))
+	return builder.String()
+}
+
+// yamlLine matches a YAML line with a key and an optional value.
+var yamlLine = regexp.MustCompile(`^(\s*(?:- )?)([^\s:][^:]*):(?:\s(.*))?$`)
+
+// colorize adds colors to the given YAML: keys are yellow, and values are
+// colored according to their type.
+func colorize(yaml string) string {
+	var builder strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(yaml, "\n"), "\n") {
+		if match := yamlLine.FindStringSubmatch(line); match != nil {
+			builder.WriteString(match[1] + "[yellow]" + tview.Escape(match[2]) + "[-]:")
+			if match[3] != "" {
+				builder.WriteString(" " + value(match[3]))
+			}
+		} else if trimmed := strings.TrimLeft(line, " "); strings.HasPrefix(trimmed, "- ") {
+			builder.WriteString(line[:len(line)-len(trimmed)] + "- " + value(trimmed[2:]))
+		} else {
+			builder.WriteString(tview.Escape(line))
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// value colors a YAML scalar according to its type.
+func value(v string) string {
+	color := "green"
+	switch {
+	case v == "true" || v == "false":
+		color = "fuchsia"
+	case v == "null" || v == "~" || v == "{}" || v == "[]":
+		color = "grey"
+	case isNumber(v):
+		color = "aqua"
+	}
+	return "[" + color + "]" + tview.Escape(v) + "[-]"
+}
+
+// isNumber returns whether the given YAML scalar is a number.
+func isNumber(v string) bool {
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
+
+// rows is the content of the table, read straight from the records so that
+// recordings with millions of messages can be listed.
+type rows struct {
+	tview.TableContentReadOnly
+	in *inspector
+}
+
+// columns are the headers of the table.
+var columns = []string{"#", "TIME", "EVENT TYPE", "USER", "PROJECT", "REQUEST"}
+
+// GetCell returns the cell at the given position.
+func (t *rows) GetCell(row, column int) *tview.TableCell {
+	if row == 0 {
+		return tview.NewTableCell(columns[column]).SetTextColor(tcell.ColorYellow).SetSelectable(false)
+	}
+	if row > len(t.in.visible) || column >= len(columns) {
+		return nil
+	}
+	i := t.in.visible[row-1]
+	entry := t.in.records[i].entry
+	var text string
+	switch column {
+	case 0:
+		text = strconv.Itoa(i + 1)
+	case 1:
+		if !entry.Timestamp.IsZero() {
+			text = entry.Timestamp.Format(time.DateTime + ".000")
+		}
+	case 2:
+		if entry.EventType == "" {
+			return tview.NewTableCell("(undecodable)").SetTextColor(tcell.ColorRed)
+		}
+		text = entry.EventType
+	case 3:
+		text = entry.User
+	case 4:
+		text = entry.Project
+	case 5:
+		text = entry.RequestID
+	}
+	return tview.NewTableCell(tview.Escape(text))
+}
+
+// GetRowCount returns the number of rows, including the header.
+func (t *rows) GetRowCount() int {
+	return len(t.in.visible) + 1
+}
+
+// GetColumnCount returns the number of columns.
+func (t *rows) GetColumnCount() int {
+	return len(columns)
+}
@@ -0,0 +1,158 @@
+package inspect
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/goccy/go-json"
+
+	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/dihedron/snoop/openstack/index"
+	"github.com/dihedron/snoop/test"
+)
+
+// message returns a recorded message containing a compute instance
+// notification.
+func message(t *testing.T, eventType string, requestID string, instanceID string) string {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"message_id":          eventType + "-" + instanceID,
+		"event_type":          eventType,
+		"publisher_id":        "compute.compute-01",
+		"timestamp":           "2024-05-21 14:00:00.000000",
+		"_context_request_id": requestID,
+		"payload": map[string]any{
+			"instance_id": instanceID,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]string{
+		"oslo.version": "2.0",
+		"oslo.message": string(payload),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&amqp.Message{Exchange: "nova", Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestInspector(t *testing.T) {
+	test.Setup(t)
+
+	recording := filepath.Join(t.TempDir(), "test.amqp.messages")
+	lines := []string{
+		message(t, "compute.instance.create.start", "req-1", "vm-1"),
+		message(t, "compute.instance.create.start", "req-2", "vm-2"),
+		message(t, "compute.instance.create.end", "req-2", "vm-2"),
+		message(t, "compute.instance.create.end", "req-1", "vm-1"),
+		message(t, "compute.instance.delete.start", "req-3", "vm-1"),
+	}
+	if err := os.WriteFile(recording, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	i, err := index.Open(context.Background(), recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []record{}
+	for _, entry := range i.Entries {
+		records = append(records, record{index: i, entry: entry})
+	}
+
+	in := newInspector(records)
+	defer in.close()
+	screen := tcell.NewSimulationScreen("UTF-8")
+	screen.SetSize(120, 40)
+	done := make(chan error)
+	go func() {
+		done <- in.run(screen)
+	}()
+
+	// state returns the listed records and the selected row, read on the
+	// UI goroutine once it has handled the keys typed so far
+	state := func() (visible []int, row int) {
+		// QueueUpdate returns once the function has run
+		in.app.QueueUpdate(func() {
+			visible = slices.Clone(in.visible)
+			row, _ = in.table.GetSelection()
+		})
+		return visible, row
+	}
+	// expect waits for the background jobs to list the given records and
+	// select the given row
+	expect := func(visible []int, row int) {
+		t.Helper()
+		var v []int
+		var r int
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if v, r = state(); slices.Equal(v, visible) && r == row {
+				return
+			}
+		}
+		t.Fatalf("unexpected state: records %v, row %d", v, r)
+	}
+	keys := func(text string) {
+		for _, r := range text {
+			screen.InjectKey(tcell.KeyRune, r, tcell.ModNone)
+		}
+	}
+	enter := func() {
+		screen.InjectKey(tcell.KeyEnter, 0, tcell.ModNone)
+	}
+
+	expect([]int{0, 1, 2, 3, 4}, 1)
+
+	// following the selected record's request and then filtering lists the
+	// records that satisfy both
+	keys("r")
+	expect([]int{0, 3}, 1)
+	keys("f")
+	keys(`eq .EventType "compute.instance.create.end"`)
+	enter()
+	expect([]int{3}, 1)
+
+	// and so does filtering and then following a request
+	keys("c")
+	expect([]int{0, 1, 2, 3, 4}, 1)
+	keys("f")
+	keys(`eq .EventType "compute.instance.create.end"`)
+	enter()
+	expect([]int{2, 3}, 1)
+	keys("r")
+	expect([]int{2}, 1)
+	keys("c")
+	expect([]int{0, 1, 2, 3, 4}, 1)
+
+	// searching selects the next matching record, wrapping around
+	keys("/vm-1")
+	enter()
+	expect([]int{0, 1, 2, 3, 4}, 4)
+	keys("n")
+	expect([]int{0, 1, 2, 3, 4}, 5)
+	keys("n")
+	expect([]int{0, 1, 2, 3, 4}, 1)
+	keys("N")
+	expect([]int{0, 1, 2, 3, 4}, 5)
+
+	keys("q")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("inspector did not quit")
+	}
+}
@@ -0,0 +1,189 @@
+package latency
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/generator/textfile"
+	"github.com/dihedron/snoop/openstack/latency"
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/dihedron/snoop/transform/transformers"
+	"github.com/fatih/color"
+)
+
+// Latency is the command that reads the messages from one or more
+// recordings, pairs the .start and .end notifications of each operation
+// and reports how long the operations took.
+// ./snoop latency 20220818.amqp.messages
+type Latency struct {
+	base.Command
+	// Output is the format of the report.
+	Output string `short:"o" long:"output" description:"The format of the report." choice:"table" choice:"json" choice:"csv" default:"table"`
+	// Top is the number of hosts and zones listed for each operation; 0
+	// lists them all.
+	Top int `short:"n" long:"top" description:"The number of slowest hosts and zones listed for each operation (0 for all)." default:"10"`
+	// Dedup is used to pair notifications only once, even if they were
+	// redelivered or received through multiple bindings.
+	Dedup bool `short:"d" long:"dedup" description:"Whether duplicate notifications should be paired only once." optional:"yes"`
+	// Workers is the number of goroutines used to decode the messages.
+	Workers int `short:"w" long:"workers" description:"The number of goroutines used to decode the messages." default:"1" env:"SNOOP_WORKERS"`
+}
+
+const (
+	// dedupTTL is how long a notification identifier is remembered.
+	dedupTTL = 24 * time.Hour
+	// dedupMaxEntries is the maximum number of identifiers remembered.
+	dedupMaxEntries = 1_000_000
+)
+
+// Execute is the real implementation of the Latency command.
+func (cmd *Latency) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+	slog.Debug("reading messages from recording..", "files", args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// messages are decoded on any number of goroutines, but paired in the
+	// order they were recorded
+	pairer := latency.NewPairer()
+	collector := latency.New()
+	pair := chain.Of2(pairer.Pair(), collector.Add())
+	if cmd.Dedup {
+		pair = chain.Of3(
+			transformers.Dedup[notification.Notification](nil, dedupTTL, dedupMaxEntries).Filter(),
+			pairer.Pair(),
+			collector.Add(),
+		)
+	}
+	xform := notification.Decode()
+	files := textfile.New()
+	stats, err := chain.Run(ctx, files.AllLinesContext(ctx, args...), xform, func(n notification.Notification) error {
+		if _, err := pair(n); err != nil && !errors.Is(err, chain.Drop) {
+			return err
+		}
+		return nil
+	}, chain.WithErrorHandler(func(line string, err error) error {
+		slog.Debug("error decoding line", "line", line, "error", err)
+		return nil
+	}), chain.WithWorkers[string](cmd.Workers, true))
+	if err != nil {
+		return err
+	}
+	slog.Debug("latency analysis complete", "stats", stats)
+
+	report := collector.Report(pairer, cmd.Top)
+	switch cmd.Output {
+	case "json":
+		fmt.Println(format.ToPrettyJSON(report))
+	case "csv":
+		return printCSV(report)
+	default:
+		printTable(report)
+	}
+	return nil
+}
+
+// section is a per-dimension list in the report.
+type section struct {
+	name      string
+	latencies []latency.Latency
+}
+
+// sections returns the per-dimension lists in the report, in the order
+// they are printed.
+func sections(report *latency.Report) []section {
+	return []section{
+		{"operation", report.Operations},
+		{"host", report.Hosts},
+		{"zone", report.Zones},
+	}
+}
+
+// printTable prints a table for each dimension, followed by the orphaned
+// starts.
+func printTable(report *latency.Report) {
+	for i, section := range sections(report) {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s:\n", color.YellowString(section.name))
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if section.name == "operation" {
+			fmt.Fprintln(writer, "OPERATION\tCOUNT\tFAILED\tP50\tP95\tP99\tMAX")
+		} else {
+			fmt.Fprintf(writer, "OPERATION\t%s\tCOUNT\tFAILED\tP50\tP95\tP99\tMAX\n", strings.ToUpper(section.name))
+		}
+		for _, l := range section.latencies {
+			if section.name == "operation" {
+				fmt.Fprintf(writer, "%s\t", l.Operation)
+			} else {
+				fmt.Fprintf(writer, "%s\t%s\t", l.Operation, l.Key)
+			}
+			fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%s\t%s\n", l.Count, l.Failed, round(l.P50), round(l.P95), round(l.P99), round(l.Max))
+		}
+		writer.Flush()
+	}
+
+	fmt.Printf("\n%s: %d\n", color.YellowString("orphaned starts"), len(report.Orphans))
+	if len(report.Orphans) > 0 {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "START\tOPERATION\tREQUEST\tINSTANCE\tHOST")
+		for _, o := range report.Orphans {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", o.Start.Format(time.RFC3339Nano), o.Name, o.RequestID, o.InstanceID, o.Host)
+		}
+		writer.Flush()
+	}
+	fmt.Printf("%s: %d\n", color.YellowString("unmatched ends"), report.Unmatched)
+}
+
+// round rounds a duration for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+// printCSV prints the report as dimension,operation,key,count,failed,p50,
+// p95,p99,max rows, with durations in seconds, followed by the orphaned
+// starts, if any, as a separate table of operation,request,instance,host,
+// start rows, after an empty line.
+func printCSV(report *latency.Report) error {
+	writer := csv.NewWriter(os.Stdout)
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	rows := [][]string{
+		{"dimension", "operation", "key", "count", "failed", "p50", "p95", "p99", "max"},
+	}
+	for _, section := range sections(report) {
+		for _, l := range section.latencies {
+			rows = append(rows, []string{section.name, l.Operation, l.Key, strconv.FormatInt(l.Count, 10), strconv.FormatInt(l.Failed, 10), seconds(l.P50), seconds(l.P95), seconds(l.P99), seconds(l.Max)})
+		}
+	}
+	if len(report.Orphans) > 0 {
+		rows = append(rows, nil, []string{"operation", "request", "instance", "host", "start"})
+		for _, o := range report.Orphans {
+			rows = append(rows, []string{o.Name, o.RequestID, o.InstanceID, o.Host, o.Start.Format(time.RFC3339Nano)})
+		}
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		slog.Error("error writing report as CSV", "error", err)
+		return err
+	}
+	return nil
+}
@@ -2,37 +2,124 @@ package playback
 
 import (
 	"context"
-	_ "embed"
+	"embed"
 	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/dihedron/snoop/command/common"
+	"github.com/dihedron/snoop/format"
 	"github.com/dihedron/snoop/generator/textfile"
 	"github.com/dihedron/snoop/metadata"
+	"github.com/dihedron/snoop/metrics"
 	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/dihedron/snoop/openstack/index"
 	"github.com/dihedron/snoop/openstack/notification"
 	"github.com/dihedron/snoop/openstack/oslo"
 	"github.com/dihedron/snoop/syslog"
 	"github.com/dihedron/snoop/transform/chain"
 	"github.com/dihedron/snoop/transform/transformers"
-	"github.com/juju/rfc/v2/rfc5424"
 )
 
-// Embed the file content as string.
+// templates are the built-in templates, which can be referred to by name
+// (e.g. "compute.instance") with --template.
 //
-//go:embed compute.instance.tmpl
-var templ string
-
-type Handler func(n *notification.Notification, sl *syslog.Syslog) error
-
-var handlers map[string]Handler
+//go:embed *.tmpl
+var templates embed.FS
 
 // Playback is the command that reads message from a recording on file and
-// processes them one by one.
-// ./snoop playback 20220818.amqp.messages
+// processes them one by one: the notifications are filtered, rendered and
+// written to the selected sink.
+// ./snoop playback --filter 'hasPrefix "compute.instance." .EventType' --output yaml 20220818.amqp.messages
 type Playback struct {
+	// Workers is the number of goroutines used to decode the messages.
+	Workers int `short:"w" long:"workers" description:"The number of goroutines used to decode the messages." default:"1" env:"SNOOP_WORKERS"`
+	// Unordered is used to let decoded messages be handled as soon as they
+	// are ready, instead of in the order they were recorded.
+	Unordered bool `short:"u" long:"unordered" description:"Whether messages can be handled out of order when decoding on multiple goroutines." optional:"yes"`
+	// Filters are the conditions the notifications must satisfy to be
+	// played back, as template pipelines (e.g. 'eq .EventType "identity.authenticate"').
+	Filters []string `short:"f" long:"filter" description:"A condition the notifications must satisfy, as a template pipeline (can be repeated)."`
+	// From is the time of the earliest notification played back.
+	From string `long:"from" description:"The time of the earliest notification played back (RFC3339 or OpenStack format)."`
+	// To is the time of the latest notification played back.
+	To string `long:"to" description:"The time before which notifications are played back (RFC3339 or OpenStack format)."`
+	// RequestID is the ID of the request whose notifications are played
+	// back.
+	RequestID string `long:"request-id" description:"Play back only the notifications of the given request (e.g. req-abc)."`
+	// InstanceID is the ID of the virtual machine whose notifications are
+	// played back.
+	InstanceID string `long:"instance-id" description:"Play back only the notifications about the given virtual machine."`
+	// Output is the format of the notifications played back.
+	Output string `short:"o" long:"output" description:"The output format (default: template if one is given, json otherwise)." choice:"json" choice:"yaml" choice:"template"`
+	// Template is the template used to render the notifications, either
+	// inline or as the name of a built-in template.
+	Template string `short:"t" long:"template" description:"The template used to render the notifications, inline or the name of a built-in one (compute.instance, seeker, auth_failed, template)."`
+	// TemplateFile is the path to the file containing the template used to
+	// render the notifications.
+	TemplateFile string `short:"T" long:"template-file" description:"The path to the file containing the template used to render the notifications."`
+	// Routes are the templates used to render the notifications of specific
+	// event types, as TYPE=TEMPLATE, where the template is either inline or
+	// the name of a built-in one; the other notifications are rendered as
+	// given by Output.
+	Routes []string `short:"R" long:"route" description:"Render the notifications of an event type with a template, inline or the name of a built-in one, as TYPE=TEMPLATE (can be repeated)."`
+	// Sink is where the notifications are written to.
+	Sink string `short:"s" long:"sink" description:"Where the notifications are written to." choice:"stdout" choice:"syslog" choice:"file" default:"stdout"`
+	// File is the path to the file notifications are written to, with the
+	// file sink.
+	File string `long:"file" description:"The path to the file the notifications are written to with the file sink."`
+	// Truncate is used to specify whether the output file should be
+	// truncated before writing to it.
+	Truncate bool `long:"truncate" description:"Whether the output file should be truncated or appended to (default)." optional:"yes"`
+	// Dedup is used to drop notifications that have already been seen, e.g.
+	// because they were redelivered or received through multiple bindings.
+	Dedup bool `short:"d" long:"dedup" description:"Whether duplicate notifications should be dropped." optional:"yes"`
+	// DedupState is the file where the identifiers of the notifications seen
+	// are persisted, so that duplicates are detected across runs.
+	DedupState string `long:"dedup-state" description:"The file where the deduplication state is persisted across runs." env:"SNOOP_DEDUP_STATE"`
+	// Sample is the fraction of notifications that are handled.
+	Sample float64 `long:"sample" description:"The fraction of notifications that are handled, between 0 and 1." default:"1"`
+	// SamplePerType is the maximum rate of notifications of each event type
+	// that are handled, so that bursts (e.g. Nova's periodic audit) are
+	// thinned out without losing rare events.
+	SamplePerType float64 `long:"sample-per-type" description:"The maximum number of notifications per second handled for each event type (0 for no limit)." default:"0"`
+	// RateLimit is the maximum rate of notifications that are handled.
+	RateLimit float64 `long:"rate-limit" description:"The maximum number of notifications per second handled (0 for no limit)." default:"0" env:"SNOOP_RATE_LIMIT"`
+	// Burst is the number of notifications that can be handled at once
+	// above the rate limit.
+	Burst int `long:"burst" description:"The number of notifications that can be handled at once above the rate limit." default:"10"`
+	// DropExcess is used to drop the notifications above the rate limit,
+	// instead of waiting.
+	DropExcess bool `long:"drop-excess" description:"Whether notifications above the rate limit should be dropped instead of delayed." optional:"yes"`
+	// Speed is used to replay the notifications with the same gaps as when
+	// they were emitted, sped up (e.g. "10x") or slowed down (e.g. "0.5x").
+	Speed string `long:"speed" description:"Replay the notifications with their original timing, at the given speed (e.g. 1x, 10x, 0.5x)."`
+	// MaxGap caps the time waited between two notifications when replaying
+	// with their original timing.
+	MaxGap time.Duration `long:"max-gap" description:"The maximum time waited between two notifications when replaying with their original timing (0 for no limit)." default:"0"`
+	// StartAt is the point of the recording the playback starts from, as a
+	// time or as an offset from the first notification.
+	StartAt string `long:"start-at" description:"Start from the given time (RFC3339 or OpenStack format) or offset from the first notification (e.g. 10m)."`
+	// MetricsListen is the address on which Prometheus metrics are exposed;
+	// if empty, no metrics are exposed.
+	MetricsListen string `short:"m" long:"metrics-listen" description:"The address to expose Prometheus metrics on (e.g. :9110)." env:"SNOOP_METRICS_LISTEN"`
 }
 
+const (
+	// dedupTTL is how long a notification identifier is remembered.
+	dedupTTL = 24 * time.Hour
+	// dedupMaxEntries is the maximum number of identifiers remembered.
+	dedupMaxEntries = 1_000_000
+)
+
 // Execute is the real implementation of the Playback command.
 func (cmd *Playback) Execute(args []string) error {
 	if len(args) == 0 {
@@ -41,143 +128,433 @@ func (cmd *Playback) Execute(args []string) error {
 	}
 	slog.Debug("reading messages from recording..", "files", args)
 
-	unwrap := chain.Of4(
-		transformers.StringToByteArray(),
-		amqp.JSONToMessage(),
-		oslo.MessageToOslo(false),
-		notification.OsloToNotification(false),
-	)
+	// stop promptly on CTRL+C, even during a slow replay
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	sl, err := syslog.New(syslog.WithApplication(metadata.Name))
+	// duplicates, notifications outside the time bounds or not matching the
+	// filters, samples and notifications above the rate limit are dropped
+	// before they are rendered
+	filters, deduplicator, err := cmd.filters(ctx)
 	if err != nil {
-		slog.Error("error initialising syslog", "error", err)
 		return err
 	}
-
-	ctx := context.Background()
-	files := textfile.New()
-	for line := range files.AllLinesContext(ctx, args...) {
-		var (
-			n   notification.Notification
-			err error
-		)
-		if n, err = unwrap(line); err != nil {
-			slog.Error("error processing line", "line", line)
-			continue
+	// when replaying with the original timing, notifications are paced
+	// once they have been selected, on a single goroutine
+	workers := cmd.Workers
+	replayer, err := cmd.replayer()
+	if err != nil {
+		return err
+	}
+	if replayer != nil {
+		filters = append(filters, chain.Bind(ctx, replayer.Pace()))
+		workers = 1
+	}
+	render, err := cmd.render()
+	if err != nil {
+		return err
+	}
+	var m *metrics.Metrics
+	if cmd.MetricsListen != "" {
+		m = metrics.New()
+		if err := m.Serve(ctx, cmd.MetricsListen); err != nil {
+			return err
 		}
-		slog.Info("processed line", "line", line, "notification", n)
+	}
+	pipeline := chain.NewPipeline(
+		chain.Named("decode", decode(m)),
+		chain.Filters(filters...),
+		chain.Named("render", sourced(render)),
+	)
+	xform, err := chain.Build[string, rendered](pipeline)
+	if err != nil {
+		return err
+	}
+	sink, closer, err := cmd.sink(ctx, m)
+	if err != nil {
+		return err
+	}
+	defer closer()
 
-		switch n.Summary().EventType {
-		case "identity.authenticate":
-			if n, ok := n.(*notification.Identity); ok {
-				onIdentityAuthenticate(n, sl)
+	stats, err := chain.Run(ctx, cmd.source(ctx, args), xform, sink, chain.WithErrorHandler(logError), chain.WithWorkers[string](workers, !cmd.Unordered))
+	if err != nil {
+		return err
+	}
+	slog.Info("playback complete", "stats", stats)
+	if replayer != nil {
+		slog.Info("notifications before the starting point skipped", "count", replayer.Skipped())
+	}
+	if deduplicator != nil {
+		slog.Info("duplicates dropped", "count", deduplicator.Duplicates())
+		if cmd.DedupState != "" {
+			if err := deduplicator.Save(cmd.DedupState); err != nil {
+				return err
 			}
+		}
+	}
+	return nil
+}
+
+// decode returns the transformer that decodes the recorded messages into
+// OpenStack notifications; if metrics are given, the failures of each stage
+// and the notifications by event type are counted, and the time taken to
+// decode is observed (pacing and rate limiting would skew it otherwise).
+func decode(m *metrics.Metrics) chain.X[string, notification.Notification] {
+	if m == nil {
+		return notification.Decode()
+	}
+	return metrics.Timed(m, chain.Of4(
+		metrics.Instrument(m, "amqp", chain.Of2(transformers.StringToByteArray(), amqp.JSONToMessage())),
+		metrics.Instrument(m, "oslo", oslo.MessageToOslo(false)),
+		metrics.Instrument(m, "notification", notification.OsloToNotification(false)),
+		func(n notification.Notification) (notification.Notification, error) {
+			m.Notifications.WithLabelValues(n.Summary().EventType).Inc()
+			return n, nil
+		},
+	))
+}
 
+// filters returns the filters that select the notifications to play back,
+// along with the deduplicator, if any, so its state can be saved.
+func (cmd *Playback) filters(ctx context.Context) ([]chain.F[notification.Notification], *transformers.Deduplicator[notification.Notification], error) {
+	filters := []chain.F[notification.Notification]{}
+	var deduplicator *transformers.Deduplicator[notification.Notification]
+	if cmd.Dedup || cmd.DedupState != "" {
+		deduplicator = transformers.Dedup[notification.Notification](nil, dedupTTL, dedupMaxEntries)
+		if cmd.DedupState != "" {
+			if err := deduplicator.Load(cmd.DedupState); err != nil {
+				return nil, nil, err
+			}
+		}
+		filters = append(filters, deduplicator.Filter())
+	}
+	if cmd.From != "" || cmd.To != "" {
+		from, to, err := bounds(cmd.From, cmd.To)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, transformers.AcceptIf(func(n notification.Notification) bool {
+			t := n.Summary().Timestamp
+			return !t.IsZero() && (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+		}))
+	}
+	if cmd.RequestID != "" {
+		filters = append(filters, transformers.AcceptIf(func(n notification.Notification) bool {
+			return n.Summary().RequestID == cmd.RequestID
+		}))
+	}
+	if cmd.InstanceID != "" {
+		filters = append(filters, transformers.AcceptIf(func(n notification.Notification) bool {
+			return notification.InstanceID(n) == cmd.InstanceID
+		}))
+	}
+	for _, condition := range cmd.Filters {
+		filter, err := transformers.Match[notification.Notification](condition)
+		if err != nil {
+			return nil, nil, err
 		}
+		filters = append(filters, filter)
+	}
+	if cmd.Sample < 1 {
+		filters = append(filters, transformers.Sample[notification.Notification](cmd.Sample))
+	}
+	if cmd.SamplePerType > 0 {
+		filters = append(filters, transformers.SampleByKey(func(n notification.Notification) string {
+			return n.Summary().EventType
+		}, cmd.SamplePerType).Filter())
+	}
+	if cmd.RateLimit > 0 {
+		limiter := transformers.RateLimit[notification.Notification](cmd.RateLimit, cmd.Burst)
+		if cmd.DropExcess {
+			filters = append(filters, limiter.Drop())
+		} else {
+			filters = append(filters, chain.Bind(ctx, limiter.Block()))
+		}
+	}
+	return filters, deduplicator, nil
+}
 
+// replayer returns the Replayer that paces the notifications according to
+// --speed, --max-gap and --start-at, or nil if none is given.
+func (cmd *Playback) replayer() (*transformers.Replayer[notification.Notification], error) {
+	if cmd.Speed == "" && cmd.StartAt == "" {
+		return nil, nil
 	}
+	speed := 0.0
+	if cmd.Speed != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(cmd.Speed), "x"), 64); err != nil || speed <= 0 {
+			slog.Error("invalid replay speed", "value", cmd.Speed, "error", err)
+			return nil, fmt.Errorf("invalid replay speed: %q", cmd.Speed)
+		}
+	}
+	options := []transformers.ReplayOption{transformers.WithMaxGap(cmd.MaxGap)}
+	if cmd.StartAt != "" {
+		if offset, err := time.ParseDuration(cmd.StartAt); err == nil {
+			options = append(options, transformers.WithStartOffset(offset))
+		} else if start := notification.ParseTimestamp(cmd.StartAt); !start.IsZero() {
+			options = append(options, transformers.WithStartAt(start))
+		} else {
+			slog.Error("invalid starting point", "value", cmd.StartAt)
+			return nil, fmt.Errorf("invalid starting point: %q", cmd.StartAt)
+		}
+	}
+	if cmd.Workers > 1 {
+		slog.Warn("replaying with the original timing on a single goroutine", "workers", cmd.Workers)
+	}
+	return transformers.Replay(func(n notification.Notification) time.Time {
+		return n.Summary().Timestamp
+	}, speed, options...), nil
+}
 
-	return nil
+// source returns the messages in the recordings: if the notifications are
+// selected by time, request or instance and all the recordings are indexed,
+// only the matching messages are read, otherwise the recordings are scanned.
+func (cmd *Playback) source(ctx context.Context, recordings []string) iter.Seq[string] {
+	files := textfile.New()
+	if cmd.From == "" && cmd.To == "" && cmd.RequestID == "" && cmd.InstanceID == "" {
+		return files.AllLinesContext(ctx, recordings...)
+	}
+	from, to, _ := bounds(cmd.From, cmd.To)
+	lines, ok := index.Matching(ctx, recordings, func(e index.Entry) bool {
+		return (from.IsZero() || !e.Timestamp.Before(from)) &&
+			(to.IsZero() || (!e.Timestamp.IsZero() && e.Timestamp.Before(to))) &&
+			(cmd.RequestID == "" || e.RequestID == cmd.RequestID) &&
+			(cmd.InstanceID == "" || e.InstanceID == cmd.InstanceID)
+	})
+	if !ok {
+		slog.Debug("recordings not indexed, scanning", "files", recordings)
+		return files.AllLinesContext(ctx, recordings...)
+	}
+	return lines
 }
 
-func onIdentityAuthenticate(n *notification.Identity, sl *syslog.Syslog) error {
-	fmt.Printf("identity.authenticate: user %s\n", n.Payload.Reason.ReasonType)
-	type SyslogIdentityEvent struct {
-		UserID  string  `json:"userid"`
-		Success bool    `json:"success"`
-		Reason  *string `json:"reason,omitempty"`
+// bounds parses the time bounds of the playback; an empty value means no
+// bound.
+func bounds(from string, to string) (time.Time, time.Time, error) {
+	var bounds [2]time.Time
+	for i, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+		if bounds[i] = notification.ParseTimestamp(value); bounds[i].IsZero() {
+			slog.Error("invalid time bound", "value", value)
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid time bound: %q", value)
+		}
 	}
-	e := &SyslogIdentityEvent{
-		UserID: n.ContextUserID,
-		//...
+	if !bounds[0].IsZero() && !bounds[1].IsZero() && !bounds[0].Before(bounds[1]) {
+		slog.Error("empty time range", "from", from, "to", to)
+		return time.Time{}, time.Time{}, fmt.Errorf("empty time range: %s to %s", from, to)
 	}
+	return bounds[0], bounds[1], nil
+}
 
-	if err := sl.Send(&syslog.Message{
-		Facility: rfc5424.FacilityAuthpriv,
-		Severity: rfc5424.SeverityEmergency,
-		ID:       "Login",
-		Content:  e,
-		Data: map[string][]string{
-			"user":   {"name=John", "surname=Doe", "id=a123456"},
-			"tenant": {"region=regionOne", "name=event-broker", "id=1234567890"},
+// render returns the transformer that renders the notifications in the
+// selected output format, or with the template routed to their event type.
+func (cmd *Playback) render() (chain.X[notification.Notification, string], error) {
+	render, err := cmd.output()
+	if err != nil {
+		return nil, err
+	}
+	if len(cmd.Routes) == 0 {
+		return render, nil
+	}
+	// route the notifications of the given event types to their own
+	// template, and render the others as usual
+	routes := map[string]chain.X[notification.Notification, string]{}
+	for _, route := range cmd.Routes {
+		eventType, text, ok := strings.Cut(route, "=")
+		if !ok || eventType == "" || text == "" {
+			slog.Error("invalid route", "route", route)
+			return nil, fmt.Errorf("invalid route %q: expected TYPE=TEMPLATE", route)
+		}
+		xform, err := transformers.Template[notification.Notification](builtin(text))
+		if err != nil {
+			return nil, err
+		}
+		routes[eventType] = xform
+	}
+	eventType := func(n notification.Notification) string {
+		return n.Summary().EventType
+	}
+	return chain.If(
+		func(n notification.Notification) bool {
+			_, ok := routes[eventType(n)]
+			return ok
 		},
-	}); err != nil {
-		slog.Error("error submitting message to syslog", "error", err)
-		return err
+		chain.Switch(eventType, routes),
+		render,
+	), nil
+}
+
+// output returns the transformer that renders the notifications in the
+// output format given on the command line.
+func (cmd *Playback) output() (chain.X[notification.Notification, string], error) {
+	output := cmd.Output
+	if output == "" {
+		output = "json"
+		if cmd.Template != "" || cmd.TemplateFile != "" {
+			output = "template"
+		}
+	}
+	switch output {
+	case "yaml":
+		return func(n notification.Notification) (string, error) {
+			return "---\n" + format.ToYAML(n), nil
+		}, nil
+	case "template":
+		text, err := cmd.template()
+		if err != nil {
+			return nil, err
+		}
+		return transformers.Template[notification.Notification](text)
+	default:
+		return func(n notification.Notification) (string, error) {
+			return format.ToJSON(n) + "\n", nil
+		}, nil
 	}
-	return nil
 }
 
-/*
-// Execute is the real implementation of the Playback command.
-func (cmd *Playback) writeOutSingleEventsAsFiles(args []string) error {
-	if len(args) == 0 {
-		slog.Error("no input files")
-		return errors.New("no input files provided")
+// template returns the text of the template given on the command line,
+// either inline, as the name of a built-in template or as a file.
+func (cmd *Playback) template() (string, error) {
+	switch {
+	case cmd.Template != "" && cmd.TemplateFile != "":
+		slog.Error("both inline template and template file provided")
+		return "", errors.New("--template and --template-file are mutually exclusive")
+	case cmd.TemplateFile != "":
+		data, err := os.ReadFile(cmd.TemplateFile)
+		if err != nil {
+			slog.Error("error reading template file", "path", cmd.TemplateFile, "error", err)
+			return "", err
+		}
+		return string(data), nil
+	case cmd.Template != "":
+		return builtin(cmd.Template), nil
+	default:
+		slog.Error("no template provided")
+		return "", errors.New("template output requires --template or --template-file")
 	}
-	slog.Debug("reading messages from recording..", "files", args)
+}
 
-	stopwatch := &transformers.StopWatch[string, notification.Notification]{}
-	multicache := &transformers.MultiCache[string, notification.Notification]{}
-	xform := chain.Of7(
-		stopwatch.Start(),
-		transformers.StringToByteArray(),
-		amqp.JSONToMessage(),
-		oslo.MessageToOslo(false),
-		notification.OsloToNotification(false),
-		multicache.Set(func(n notification.Notification) string {
-			return n.Summary().EventType
-		}),
-		stopwatch.Stop(),
-	)
+// builtin returns the text of the built-in template with the given name,
+// or the given text itself if there is no such template.
+func builtin(text string) string {
+	if data, err := templates.ReadFile(text + ".tmpl"); err == nil {
+		slog.Debug("using built-in template", "name", text)
+		return string(data)
+	}
+	return text
+}
 
-	ctx := context.Background()
-	files := textfile.New()
-	for line := range files.AllLinesContext(ctx, args...) {
-		if value, err := xform(line); err != nil {
-			slog.Error("error processing line", "line", line)
-		} else {
-			slog.Info("processed line", "line", line, "output", value)
+// rendered is a notification rendered for output; it keeps the source of
+// the notification, which the syslog sink sends as structured data.
+type rendered struct {
+	text   string
+	source map[string][]string
+}
+
+// String returns the rendered notification.
+func (r rendered) String() string {
+	return r.text
+}
+
+// Source returns the source cluster and region of the notification, if
+// any.
+func (r rendered) Source() map[string][]string {
+	return r.source
+}
+
+// sourced wraps the given renderer so that the rendered notifications keep
+// their source.
+func sourced(render chain.X[notification.Notification, string]) chain.X[notification.Notification, rendered] {
+	return func(n notification.Notification) (rendered, error) {
+		text, err := render(n)
+		if err != nil {
+			return rendered{}, err
+		}
+		r := rendered{text: text}
+		if s, ok := n.(transformers.Sourced); ok {
+			r.source = s.Source()
 		}
+		return r, nil
 	}
+}
 
-	for k, messages := range multicache.Contents() {
-		path, err := filepath.Abs(filepath.Join(".", "output", k)) //strings.Replace(k, ".", "_", -1)))
+// sink returns the sink the rendered notifications are written to, along
+// with a function to release its resources; if metrics are given, the
+// events that could not be forwarded to syslog are counted.
+func (cmd *Playback) sink(ctx context.Context, m *metrics.Metrics) (chain.Sink[rendered], func(), error) {
+	switch cmd.Sink {
+	case "syslog":
+		sl, err := syslog.New(syslog.WithApplication(metadata.Name))
 		if err != nil {
-			slog.Error("error making absolute path of path", "path", filepath.Join(".", "output", k), "error", err)
+			slog.Error("error initialising syslog", "error", err)
+			return nil, nil, err
+		}
+		// forwarding to syslog is retried on transient failures, and a
+		// circuit breaker stops a flapping endpoint from stalling the
+		// playback; events that could not be forwarded are reported by
+		// the error handler. A send that times out may still reach syslog,
+		// so retried events may be delivered more than once
+		resilience := &chain.Metrics{}
+		if m != nil {
+			m.CounterVecFunc("syslog_resilience_total", "The number of retries, exhausted retries, timeouts, circuit breaker trips and short circuits when forwarding to syslog.", "event", func() map[string]int64 {
+				snapshot := resilience.Snapshot()
+				return map[string]int64{
+					"retry":         snapshot.Retries,
+					"exhausted":     snapshot.Exhausted,
+					"timeout":       snapshot.Timeouts,
+					"trip":          snapshot.Trips,
+					"short-circuit": snapshot.ShortCircuits,
+				}
+			})
+		}
+		forward := chain.BreakerContext(
+			chain.RetryContext(
+				chain.TimeoutContext(transformers.WriteToSyslog[rendered](sl, nil), 5*time.Second, resilience),
+				chain.RetryPolicy{Attempts: 3, Initial: 200 * time.Millisecond, Max: 2 * time.Second, Jitter: 0.2, Metrics: resilience},
+			),
+			chain.BreakerConfig{Failures: 5, Cooldown: 30 * time.Second, Metrics: resilience},
+		)
+		sink := func(r rendered) error {
+			_, err := forward(ctx, r)
+			if err != nil && m != nil {
+				m.SyslogErrors.Inc()
+			}
 			return err
 		}
-		fmt.Printf("would make directory: %s (%d items)\n", path, len(messages))
-		err = os.MkdirAll(path, 0755)
+		return sink, func() {
+			slog.Info("syslog forwarding complete", "syslog", resilience.Snapshot())
+		}, nil
+	case "file":
+		if cmd.File == "" {
+			slog.Error("no output file provided")
+			return nil, nil, errors.New("the file sink requires --file")
+		}
+		writer, err := common.GetWriter(cmd.File, &cmd.Truncate)
 		if err != nil {
-			slog.Error("error creating directories", "path", path, "error", err)
-			return err
+			return nil, nil, err
 		}
-		for i, m := range messages {
-			func(i int, message notification.Notification) {
-				name := filepath.Join(path, fmt.Sprintf("%04d-%s.yaml", i, k))
-				file, err := os.Create(name)
-				if err != nil {
-					slog.Error("error creating output file", "name", name, "error", err)
-					return
-				}
-				defer file.Close()
-				file.WriteString(format.ToYAML(message))
-			}(i, m)
+		file, ok := writer.(*os.File)
+		if !ok {
+			slog.Error("unexpected writer for output file", "path", cmd.File, "type", fmt.Sprintf("%T", writer))
+			return nil, nil, fmt.Errorf("cannot write to %s: unexpected writer %T", cmd.File, writer)
 		}
+		return write(file), func() { file.Close() }, nil
+	default:
+		return write(os.Stdout), func() { os.Stdout.Sync() }, nil
 	}
+}
 
-	// fmt.Println("# --------------------------------------------------------------------------------")
-	// fmt.Printf("%s", format.ToYAML(value))
-
-	// fmt.Println("# --------------------------------------------------------------------------------")
-	// os.Stdout.Sync()
-
-	// return printEventsAsYAML(args, func(n notification.Notification) bool {
-	// 	return strings.HasPrefix(n.Summary().EventType, "compute.instance.")
-	// })
-
-	// return printEventsAsYAML(args, func(n notification.Notification) bool { return true })
-	return nil
+// write returns a sink that writes the rendered notifications to the given
+// writer.
+func write(writer io.Writer) chain.Sink[rendered] {
+	return func(r rendered) error {
+		if _, err := io.WriteString(writer, r.text); err != nil {
+			slog.Error("error writing notification", "error", err)
+			return err
+		}
+		return nil
+	}
 }
-*/
@@ -216,8 +216,6 @@ func (cmd *Process) Execute(args []string) error {
 }
 
 func (cmd *Process) processFromFile(args []string) error {
-	var err error
-
 	slog.Debug("playing back messages from recordings...", "files", args)
 
 	ctx := context.Background()
@@ -237,19 +235,14 @@ func (cmd *Process) processFromFile(args []string) error {
 	)
 
 	files := textfile.New()
-	for line := range files.AllLinesContext(ctx, args...) {
-		var n notification.Notification
-
-		if n, err = unwrap(line); err != nil {
-			slog.Error("error unwrapping line", "line", line, "error", err)
-			continue
-		}
-		slog.Info("unwrapped line", "line", line, "output", n, "elapsed", stopwatch.Elapsed())
-
-		err = cmd.processNotification(n)
-		if err != nil {
-			slog.Error("error processing notification", "error", err)
-		}
+	if _, err := chain.Run(ctx, files.AllLinesContext(ctx, args...), unwrap, func(n notification.Notification) error {
+		slog.Info("unwrapped line", "output", n, "elapsed", stopwatch.Elapsed())
+		return cmd.processNotification(n)
+	}, chain.WithErrorHandler(func(line string, err error) error {
+		slog.Error("error processing line", "line", line, "error", err)
+		return nil
+	})); err != nil {
+		return err
 	}
 
 	stats, _ := multicounter.Count()
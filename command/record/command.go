@@ -10,12 +10,13 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/dihedron/rawdata"
 	"github.com/dihedron/snoop/command/base"
 	"github.com/dihedron/snoop/command/common"
 	"github.com/dihedron/snoop/format"
 	"github.com/dihedron/snoop/generator/rabbitmq"
+	"github.com/dihedron/snoop/metrics"
 	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/dihedron/snoop/openstack/index"
 	"github.com/dihedron/snoop/transform/chain"
 	"github.com/dihedron/snoop/transform/transformers"
 	"github.com/rabbitmq/amqp091-go"
@@ -34,6 +35,26 @@ type Record struct {
 	Truncate *bool `short:"t" long:"truncate" description:"Whether the output file should be truncated or appended to (default)." optional:"yes" env:"SNOOP_TRUNCATE"`
 	// Limit is used to specify the number of messages to process before exiting.
 	Limit *int `short:"l" long:"limit" description:"Whether to process only the given amount of messages." optional:"yes" hidden:"yes" env:"SNOOP_LIMIT"`
+	// MaxFailures is the number of times a message can fail to be processed
+	// before it is considered poison.
+	MaxFailures int `short:"F" long:"max-failures" description:"The number of processing failures after which a message is considered poison." default:"3" env:"SNOOP_MAX_FAILURES"`
+	// Poison is the action to take on poison messages.
+	Poison string `short:"P" long:"poison" description:"What to do with poison messages." choice:"reject" choice:"dead-letter" choice:"quarantine" default:"reject" env:"SNOOP_POISON"`
+	// DeadLetterExchange is the exchange poison messages are republished to
+	// when the dead-letter action is selected.
+	DeadLetterExchange string `short:"x" long:"dead-letter-exchange" description:"The exchange to republish poison messages to (with --poison=dead-letter)." env:"SNOOP_DEAD_LETTER_EXCHANGE"`
+	// DeadLetterRoutingKey is the routing key to use when republishing poison
+	// messages; if empty, the original routing key is used.
+	DeadLetterRoutingKey string `short:"k" long:"dead-letter-routing-key" description:"The routing key to republish poison messages with (default: the original one)." env:"SNOOP_DEAD_LETTER_ROUTING_KEY"`
+	// Quarantine is the path to the file poison messages are written to when
+	// the quarantine action is selected.
+	Quarantine string `short:"q" long:"quarantine" description:"The path to the file to write poison messages to (with --poison=quarantine)." env:"SNOOP_QUARANTINE"`
+	// MetricsListen is the address on which Prometheus metrics are exposed;
+	// if empty, no metrics are exposed.
+	MetricsListen string `short:"m" long:"metrics-listen" description:"The address to expose Prometheus metrics on (e.g. :9110)." env:"SNOOP_METRICS_LISTEN"`
+	// Index is used to keep an index of the recording, so that messages can
+	// be selected and read back without scanning the whole file.
+	Index bool `short:"i" long:"index" description:"Whether to keep an index of the recording next to it." optional:"yes" env:"SNOOP_INDEX"`
 }
 
 // Execute is the real implementation of the Record command.
@@ -62,6 +83,21 @@ func (cmd *Record) Execute(args []string) error {
 		defer w.Close()
 	}
 
+	// the index is written along with the recording, and it is brought up
+	// to date first if messages were recorded without it
+	var indexer *index.Writer
+	if cmd.Index {
+		if path == "-" {
+			slog.Error("cannot index a recording to STDOUT")
+			return errors.New("cannot index a recording to STDOUT")
+		}
+		if indexer, err = index.NewWriter(context.Background(), path); err != nil {
+			slog.Error("error opening recording index", "error", err)
+			return err
+		}
+		defer indexer.Close()
+	}
+
 	// get the RabbitMQ connection
 	if cmd.Profile == "" {
 		slog.Error("no connection info provided")
@@ -70,53 +106,176 @@ func (cmd *Record) Execute(args []string) error {
 
 	slog.Debug("reading connection info", "connection info", cmd.Profile)
 
-	rmq := &rabbitmq.RabbitMQ{}
-	err = rawdata.UnmarshalInto("@"+cmd.Profile, rmq)
+	// the profile may contain a single cluster or multiple named clusters,
+	// in which case they are consumed concurrently and the messages are
+	// tagged with their source cluster and region
+	source, err := rabbitmq.Load(cmd.Profile)
 	if err != nil {
 		slog.Error("error reading connection info", "error", err)
+		return err
 	}
-	slog.Debug("RabbitMQ connection info file in JSON format", "configuration", format.ToJSON(rmq))
+	slog.Debug("RabbitMQ connection info file in JSON format", "configuration", format.ToJSON(source))
 
 	// now prepare the processing chain
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// every delivery is acked or nacked: messages that fail too many times
+	// are handled as poison according to the command line flags
+	poison, closer, err := cmd.poison()
+	if err != nil {
+		slog.Error("error preparing poison message handler", "error", err)
+		return err
+	}
+	defer closer()
+
+	var m *metrics.Metrics
+	if cmd.MetricsListen != "" {
+		m = metrics.New()
+		m.CounterFunc("reconnects_total", "The number of times the connection to RabbitMQ was re-established.", rabbitmq.Reconnects)
+		m.CounterVecFunc("messages_settled_total", "The number of messages settled, by outcome.", "outcome", func() map[string]int64 {
+			settled := poison.Stats()
+			return map[string]int64{
+				"acked":         settled.Acked,
+				"requeued":      settled.Requeued,
+				"rejected":      settled.Rejected,
+				"dead-lettered": settled.DeadLettered,
+				"quarantined":   settled.Quarantined,
+				"error":         settled.Errors,
+			}
+		})
+		if err := m.Serve(ctx, cmd.MetricsListen); err != nil {
+			return err
+		}
+	}
+
 	stopwatch := &transformers.StopWatch[*amqp091.Delivery, []byte]{}
 	xform := chain.Of4(
 		stopwatch.Start(),
-		amqp.DeliveryToMessage(false),
-		transformers.ToJSON[*amqp.Message](),
+		instrument(m, "amqp", amqp.DeliveryToMessage(false)),
+		instrument(m, "json", transformers.ToJSON[*amqp.Message]()),
 		stopwatch.Stop(),
 	)
+	if m != nil {
+		xform = metrics.Timed(m, chain.Of2(received(m), xform))
+	}
 
-	count := 0
 	limit := 0
 	if cmd.Limit != nil && *cmd.Limit > 0 {
 		limit = *cmd.Limit
 	}
-	for m := range rmq.All(ctx) {
+	count := 0
+	stats, err := chain.Run(ctx, source.All(ctx), xform, func(value []byte) error {
 		count++
 		if count%100 == 0 {
 			fmt.Printf(". ")
 		}
-		if cmd.Limit != nil && count >= limit {
-			break
+		slog.Debug("AMQP091 message received", "value", format.ToPrettyJSON(value))
+		if _, err := fmt.Fprintf(writer, "%s\n", value); err != nil {
+			return err
 		}
-		value, err := xform(m)
-		if err != nil {
-			slog.Error("error applying chain to message", "error", err)
-		} else {
-			slog.Debug("AMQP091 message received", "value", format.ToPrettyJSON(value))
-			fmt.Fprintf(writer, "%s\n", value)
-			slog.Debug("acknowledging incoming AMQP message")
-			if err := m.Ack(false); err != nil {
-				slog.Error("error acknowledging message", "id", m.MessageId, "error", err)
+		if indexer == nil && m == nil {
+			return nil
+		}
+		// the message is decoded once, both to count its event type and to
+		// index it
+		entry := index.Describe(value)
+		if m != nil {
+			if entry.EventType == "" {
+				m.Failures.WithLabelValues("notification").Inc()
+			} else {
+				m.Notifications.WithLabelValues(entry.EventType).Inc()
 			}
 		}
+		// the sink is called on a single goroutine, so messages are indexed
+		// in the same order they are appended to the recording
+		if indexer != nil {
+			return indexer.Append(entry)
+		}
+		return nil
+	},
+		chain.WithLimit[*amqp091.Delivery](limit),
+		chain.WithErrorHandler(func(m *amqp091.Delivery, err error) error {
+			slog.Error("error applying chain to message", "id", m.MessageId, "error", err)
+			return nil
+		}),
+		chain.WithDone(func(m *amqp091.Delivery, err error) {
+			// dropped messages are not errors, they are acknowledged
+			if errors.Is(err, chain.Drop) {
+				err = nil
+			}
+			if err := poison.Settle(ctx, m, err); err != nil {
+				slog.Error("error settling message", "id", m.MessageId, "error", err)
+			}
+		}),
+	)
+	if err != nil {
+		slog.Error("error recording messages", "error", err)
 	}
-	if err := rmq.Err(); err != nil {
+	slog.Info("recording complete", "read", stats.Read, "written", stats.Written, "dropped", stats.Dropped, "failed", stats.Failed, "elapsed", stats.Elapsed)
+	if err := source.Err(); err != nil {
 		slog.Error("error connecting to RabbitMQ", "error", err)
 	}
 
+	settled := poison.Stats()
+	slog.Info("messages settled", "acked", settled.Acked, "requeued", settled.Requeued, "rejected", settled.Rejected, "dead-lettered", settled.DeadLettered, "quarantined", settled.Quarantined, "errors", settled.Errors)
+
 	return nil
 }
+
+// poison creates the poison message handler as per the command line flags,
+// along with a function to close the quarantine file, if any.
+func (cmd *Record) poison() (*rabbitmq.Poison, func(), error) {
+	options := []rabbitmq.PoisonOption{
+		rabbitmq.WithMaxFailures(cmd.MaxFailures),
+	}
+	closer := func() {}
+	switch rabbitmq.PoisonAction(cmd.Poison) {
+	case rabbitmq.PoisonDeadLetter:
+		options = append(options, rabbitmq.WithDeadLetter(cmd.DeadLetterExchange, cmd.DeadLetterRoutingKey))
+	case rabbitmq.PoisonQuarantine:
+		if cmd.Quarantine == "" {
+			return nil, nil, errors.New("no quarantine file provided")
+		}
+		truncate := false
+		writer, err := common.GetWriter(cmd.Quarantine, &truncate)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c, ok := writer.(io.Closer); ok {
+			closer = func() {
+				if err := c.Close(); err != nil {
+					slog.Error("error closing quarantine file", "path", cmd.Quarantine, "error", err)
+				}
+			}
+		}
+		options = append(options, rabbitmq.WithQuarantine(writer))
+	default:
+		options = append(options, rabbitmq.WithReject())
+	}
+	poison, err := rabbitmq.NewPoison(options...)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+	return poison, closer, nil
+}
+
+// instrument counts the failures of the given stage under the given name,
+// if metrics are collected.
+func instrument[S any, T any](m *metrics.Metrics, stage string, xform chain.X[S, T]) chain.X[S, T] {
+	if m == nil {
+		return xform
+	}
+	return metrics.Instrument(m, stage, xform)
+}
+
+// received counts the deliveries received, by exchange and routing key.
+func received(m *metrics.Metrics) chain.F[*amqp091.Delivery] {
+	return func(delivery *amqp091.Delivery) (*amqp091.Delivery, error) {
+		if delivery != nil {
+			m.Received.WithLabelValues(delivery.Exchange, delivery.RoutingKey).Inc()
+		}
+		return delivery, nil
+	}
+}
@@ -0,0 +1,127 @@
+package split
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/generator/textfile"
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/dihedron/snoop/transform/transformers"
+	"github.com/fatih/color"
+)
+
+// Split is the command that reads the messages from one or more recordings
+// and writes the notifications they contain to one file per key (e.g. per
+// event type) in an output directory; the notifications are written as they
+// are read, so recordings of any size can be split.
+// ./snoop split --by request_id --format yaml --out requests 20220818.amqp.messages
+type Split struct {
+	base.Command
+	// By is the key the notifications are split by.
+	By string `short:"b" long:"by" description:"The key the notifications are split by (project is the same as project_id)." choice:"event_type" choice:"project" choice:"project_id" choice:"project_name" choice:"request_id" choice:"instance_id" default:"event_type"`
+	// Format is the format of the notifications in the output files.
+	Format string `short:"f" long:"format" description:"The format of the notifications in the output files." choice:"jsonl" choice:"yaml" default:"jsonl"`
+	// Out is the directory the output files are written to.
+	Out string `short:"o" long:"out" description:"The directory the output files are written to." default:"output"`
+	// MaxOpen is the maximum number of output files kept open at once.
+	MaxOpen int `long:"max-open" description:"The maximum number of output files kept open at once." default:"64"`
+	// Workers is the number of goroutines used to decode the messages.
+	Workers int `short:"w" long:"workers" description:"The number of goroutines used to decode the messages." default:"1" env:"SNOOP_WORKERS"`
+}
+
+// errWrite marks the errors writing the notifications to the output files.
+var errWrite = errors.New("error writing notification")
+
+// Execute is the real implementation of the Split command.
+func (cmd *Split) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+	slog.Debug("splitting recordings..", "files", args, "by", cmd.By, "format", cmd.Format, "out", cmd.Out)
+
+	if err := os.MkdirAll(cmd.Out, 0755); err != nil {
+		slog.Error("error creating output directory", "path", cmd.Out, "error", err)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	extension, render := ".jsonl", func(n notification.Notification) (string, error) {
+		return format.ToJSON(n) + "\n", nil
+	}
+	if cmd.Format == "yaml" {
+		extension, render = ".yaml", func(n notification.Notification) (string, error) {
+			return "---\n" + format.ToYAML(n), nil
+		}
+	}
+	splitter := transformers.Split(cmd.Out, extension, keyer(cmd.By), render, cmd.MaxOpen)
+	write := splitter.Write()
+
+	xform := notification.Decode()
+	files := textfile.New()
+	stats, err := chain.Run(ctx, files.AllLinesContext(ctx, args...), xform, func(n notification.Notification) error {
+		if _, err := write(n); err != nil {
+			return fmt.Errorf("%w: %w", errWrite, err)
+		}
+		return nil
+	}, chain.WithErrorHandler(func(line string, err error) error {
+		// lines that cannot be decoded are skipped, but a notification that
+		// cannot be written is lost, so the split fails
+		if errors.Is(err, errWrite) {
+			slog.Error("error writing notification", "error", err)
+			return err
+		}
+		slog.Debug("error decoding line", "line", line, "error", err)
+		return nil
+	}), chain.WithWorkers[string](cmd.Workers, true))
+	if e := splitter.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("split complete", "stats", stats)
+
+	counts := splitter.Counts()
+	for _, path := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Printf("%s: %d notifications\n", color.YellowString(path), counts[path])
+	}
+	return nil
+}
+
+// keyer returns the function that extracts the given key from the
+// notifications.
+func keyer(by string) func(n notification.Notification) string {
+	switch by {
+	case "project", "project_id":
+		return func(n notification.Notification) string {
+			return n.Summary().ProjectID
+		}
+	case "project_name":
+		return func(n notification.Notification) string {
+			return n.Summary().ProjectName
+		}
+	case "request_id":
+		return func(n notification.Notification) string {
+			return n.Summary().RequestID
+		}
+	case "instance_id":
+		return notification.InstanceID
+	default:
+		return func(n notification.Notification) string {
+			return n.Summary().EventType
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package stats
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/generator/textfile"
+	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/openstack/oslo"
+	"github.com/dihedron/snoop/openstack/stats"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/dihedron/snoop/transform/transformers"
+	"github.com/fatih/color"
+)
+
+// Stats is the command that reads the messages from one or more recordings
+// and reports statistics about the notifications they contain.
+// ./snoop stats 20220818.amqp.messages
+type Stats struct {
+	base.Command
+	// Output is the format of the report.
+	Output string `short:"o" long:"output" description:"The format of the report." choice:"table" choice:"json" choice:"csv" default:"table"`
+	// Top is the number of entries in each list; 0 lists them all.
+	Top int `short:"n" long:"top" description:"The number of entries in each list (0 for all)." default:"10"`
+	// Dedup is used to count notifications only once, even if they were
+	// redelivered or received through multiple bindings.
+	Dedup bool `short:"d" long:"dedup" description:"Whether duplicate notifications should be counted only once." optional:"yes"`
+	// Workers is the number of goroutines used to decode the messages.
+	Workers int `short:"w" long:"workers" description:"The number of goroutines used to decode the messages." default:"1" env:"SNOOP_WORKERS"`
+}
+
+const (
+	// dedupTTL is how long a notification identifier is remembered.
+	dedupTTL = 24 * time.Hour
+	// dedupMaxEntries is the maximum number of identifiers remembered.
+	dedupMaxEntries = 1_000_000
+)
+
+// Execute is the real implementation of the Stats command.
+func (cmd *Stats) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+	slog.Debug("reading messages from recording..", "files", args)
+
+	collector := stats.New()
+	xform := chain.Of4(
+		transformers.StringToByteArray(),
+		stats.Track(collector, "amqp", amqp.JSONToMessage()),
+		stats.Track(collector, "oslo", oslo.MessageToOslo(false)),
+		stats.Track(collector, "notification", stats.Unsupported(collector, notification.OsloToNotification(false))),
+	)
+	sink := collector.Add()
+	if cmd.Dedup {
+		sink = chain.Of2(
+			transformers.Dedup[notification.Notification](nil, dedupTTL, dedupMaxEntries).Filter(),
+			collector.Add(),
+		)
+	}
+
+	ctx := context.Background()
+	files := textfile.New()
+	counts, err := chain.Run(ctx, files.AllLinesContext(ctx, args...), xform, func(n notification.Notification) error {
+		_, err := sink(n)
+		return err
+	}, chain.WithErrorHandler(func(line string, err error) error {
+		slog.Debug("error decoding line", "line", line, "error", err)
+		return nil
+	}), chain.WithWorkers[string](cmd.Workers, true))
+	if err != nil {
+		return err
+	}
+	slog.Debug("statistics complete", "stats", counts)
+
+	report := collector.Report(cmd.Top)
+	switch cmd.Output {
+	case "json":
+		fmt.Println(format.ToPrettyJSON(report))
+	case "csv":
+		return printCSV(report)
+	default:
+		printTable(report)
+	}
+	return nil
+}
+
+// section is a per-dimension list in the report.
+type section struct {
+	name   string
+	counts []stats.Count
+}
+
+// sections returns the per-dimension lists in the report, in the order they
+// are printed.
+func sections(report *stats.Report) []section {
+	return []section{
+		{"event type", report.EventTypes},
+		{"publisher", report.Publishers},
+		{"project", report.Projects},
+		{"user", report.Users},
+		{"host", report.Hosts},
+		{"failure", report.Failures},
+		{"unsupported", report.UnsupportedTypes},
+	}
+}
+
+// printTable prints the summary followed by a table for each dimension.
+func printTable(report *stats.Report) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "%s\t%d\n", color.YellowString("notifications"), report.Total)
+	fmt.Fprintf(writer, "%s\t%d\n", color.YellowString("failures"), report.Failed)
+	fmt.Fprintf(writer, "%s\t%d\n", color.YellowString("unsupported"), report.Unsupported)
+	if !report.First.IsZero() {
+		fmt.Fprintf(writer, "%s\t%s\n", color.YellowString("first"), report.First.Format(time.RFC3339))
+		fmt.Fprintf(writer, "%s\t%s\n", color.YellowString("last"), report.Last.Format(time.RFC3339))
+		fmt.Fprintf(writer, "%s\t%s\n", color.YellowString("span"), report.Span)
+		fmt.Fprintf(writer, "%s\t%.1f/min (peak %d/min)\n", color.YellowString("rate"), report.Rate, report.PeakRate)
+	}
+	writer.Flush()
+
+	for _, section := range sections(report) {
+		if len(section.counts) == 0 {
+			continue
+		}
+		total := report.Total
+		switch section.name {
+		case "failure":
+			total = report.Failed
+		case "unsupported":
+			total = report.Unsupported
+		}
+		fmt.Printf("\n%s:\n", color.YellowString(section.name))
+		writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "KEY\tCOUNT\tPERCENT")
+		for _, count := range section.counts {
+			fmt.Fprintf(writer, "%s\t%d\t%.1f%%\n", count.Key, count.Count, 100*float64(count.Count)/float64(max(total, 1)))
+		}
+		writer.Flush()
+	}
+}
+
+// printCSV prints the report as dimension,key,count rows, starting with the
+// summary.
+func printCSV(report *stats.Report) error {
+	writer := csv.NewWriter(os.Stdout)
+	rows := [][]string{
+		{"dimension", "key", "count"},
+		{"summary", "notifications", strconv.FormatInt(report.Total, 10)},
+		{"summary", "failures", strconv.FormatInt(report.Failed, 10)},
+		{"summary", "unsupported", strconv.FormatInt(report.Unsupported, 10)},
+	}
+	if !report.First.IsZero() {
+		rows = append(rows,
+			[]string{"summary", "first", report.First.Format(time.RFC3339Nano)},
+			[]string{"summary", "last", report.Last.Format(time.RFC3339Nano)},
+			[]string{"summary", "span", strconv.FormatFloat(report.Span.Seconds(), 'f', -1, 64)},
+			[]string{"summary", "rate", strconv.FormatFloat(report.Rate, 'f', 2, 64)},
+			[]string{"summary", "peakrate", strconv.FormatInt(report.PeakRate, 10)},
+		)
+	}
+	for _, section := range sections(report) {
+		for _, count := range section.counts {
+			rows = append(rows, []string{section.name, count.Key, strconv.FormatInt(count.Count, 10)})
+		}
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		slog.Error("error writing report as CSV", "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package timeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dihedron/snoop/command/base"
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/generator/textfile"
+	"github.com/dihedron/snoop/openstack/index"
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/openstack/timeline"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/fatih/color"
+)
+
+// Timeline is the command that reads the messages from one or more
+// recordings and prints the lifecycle of a virtual machine, from
+// scheduling to deletion, with the time taken by each operation.
+// ./snoop timeline --instance 2f7b2ef4-2b2c-4b8e-9d6a-7d2e5a0c1f3e 20220818.amqp.messages
+type Timeline struct {
+	base.Command
+	// Instance is the ID of the virtual machine.
+	Instance string `short:"i" long:"instance" description:"The ID of the virtual machine." required:"yes"`
+	// Output is the format of the timeline.
+	Output string `short:"o" long:"output" description:"The format of the timeline." choice:"text" choice:"json" choice:"yaml" default:"text"`
+	// Workers is the number of goroutines used to decode the messages.
+	Workers int `short:"w" long:"workers" description:"The number of goroutines used to decode the messages." default:"1" env:"SNOOP_WORKERS"`
+}
+
+// Execute is the real implementation of the Timeline command.
+func (cmd *Timeline) Execute(args []string) error {
+	if len(args) == 0 {
+		slog.Error("no input files")
+		return errors.New("no input files provided")
+	}
+	slog.Debug("reading messages from recording..", "files", args, "instance", cmd.Instance)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// if all the recordings are indexed, only the messages about the
+	// instance are read
+	lines, ok := index.Matching(ctx, args, func(e index.Entry) bool {
+		return e.InstanceID == cmd.Instance
+	})
+	if !ok {
+		slog.Debug("recordings not indexed, scanning", "files", args)
+		lines = textfile.New().AllLinesContext(ctx, args...)
+	}
+
+	builder := timeline.New(cmd.Instance)
+	xform := chain.Of2(notification.Decode(), builder.Add())
+	stats, err := chain.Run(ctx, lines, xform, func(n notification.Notification) error {
+		return nil
+	}, chain.WithErrorHandler(func(line string, err error) error {
+		slog.Debug("error decoding line", "line", line, "error", err)
+		return nil
+	}), chain.WithWorkers[string](cmd.Workers, false))
+	if err != nil {
+		return err
+	}
+	slog.Debug("timeline complete", "stats", stats, "notifications", builder.Len())
+	if builder.Len() == 0 {
+		slog.Error("no notifications about instance", "instance", cmd.Instance)
+		return fmt.Errorf("no notifications about instance %s", cmd.Instance)
+	}
+
+	t := builder.Timeline()
+	switch cmd.Output {
+	case "json":
+		fmt.Println(format.ToPrettyJSON(t))
+	case "yaml":
+		fmt.Print(format.ToYAML(t))
+	default:
+		printTimeline(t)
+	}
+	return nil
+}
+
+// printTimeline prints the events in the timeline, followed by its phases.
+func printTimeline(t *timeline.Timeline) {
+	fmt.Printf("%s %s", color.YellowString("instance"), t.InstanceID)
+	if t.Name != "" {
+		fmt.Printf(" (%s)", t.Name)
+	}
+	fmt.Println()
+
+	start := t.Events[0].Timestamp
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TIME\tELAPSED\tPHASE\tEVENT TYPE\tHOST\tDURATION\tDETAILS")
+	for _, event := range t.Events {
+		duration := ""
+		if event.Duration > 0 {
+			duration = event.Duration.String()
+		}
+		details := event.Details
+		if event.Error {
+			details = color.RedString("%s", details)
+		}
+		fmt.Fprintf(writer, "%s\t+%s\t%s\t%s\t%s\t%s\t%s\n", event.Timestamp.Format(time.RFC3339Nano), event.Timestamp.Sub(start), event.Phase, event.EventType, event.Host, duration, details)
+	}
+	writer.Flush()
+
+	if len(t.Phases) == 0 {
+		return
+	}
+	fmt.Printf("\n%s:\n", color.YellowString("phases"))
+	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "PHASE\tSTART\tDURATION\tOUTCOME")
+	for _, phase := range t.Phases {
+		duration, outcome := phase.Duration.String(), "completed"
+		switch {
+		case phase.End.IsZero():
+			duration, outcome = "", color.YellowString("not completed")
+		case phase.Failed:
+			outcome = color.RedString("failed")
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", phase.Name, phase.Start.Format(time.RFC3339Nano), duration, outcome)
+	}
+	writer.Flush()
+}
@@ -33,7 +33,7 @@ func Merge[T any](ctx context.Context, sequences ...iter.Seq[T]) iter.Seq[T] {
 			}(c)
 			channels = append(channels, c)
 		}
-		out := merge(channels...)
+		out := merge(ctx, channels...)
 		defer func() {
 			// slog.Info("Merge: main waiting for goroutines to close...")
 			wg.Wait()
@@ -41,7 +41,11 @@ func Merge[T any](ctx context.Context, sequences ...iter.Seq[T]) iter.Seq[T] {
 		}()
 		for {
 			select {
-			case value := <-out:
+			case value, ok := <-out:
+				if !ok {
+					// all sequences are exhausted
+					return
+				}
 				// slog.Info("Merge: forwarding value received from channel", "value", value)
 				if !yield(value) {
 					return
@@ -85,7 +89,7 @@ func Merge2[K any, V any](ctx context.Context, sequences ...iter.Seq2[K, V]) ite
 			}(c)
 			channels = append(channels, c)
 		}
-		out := merge(channels...)
+		out := merge(ctx, channels...)
 		defer func() {
 			// slog.Info("Merge2: main waiting for goroutines to close...")
 			wg.Wait()
@@ -93,7 +97,11 @@ func Merge2[K any, V any](ctx context.Context, sequences ...iter.Seq2[K, V]) ite
 		}()
 		for {
 			select {
-			case value := <-out:
+			case value, ok := <-out:
+				if !ok {
+					// all sequences are exhausted
+					return
+				}
 				// slog.Info("Merge2: forwarding value received from channel", "value", value)
 				if !yield(value.k, value.v) {
 					return
@@ -106,7 +114,7 @@ func Merge2[K any, V any](ctx context.Context, sequences ...iter.Seq2[K, V]) ite
 	}
 }
 
-func merge[T any](cs ...<-chan T) <-chan T {
+func merge[T any](ctx context.Context, cs ...<-chan T) <-chan T {
 	out := make(chan T, 100)
 	var wg sync.WaitGroup
 	for _, c := range cs {
@@ -114,7 +122,11 @@ func merge[T any](cs ...<-chan T) <-chan T {
 		wg.Add(1)
 		go func(c <-chan T) {
 			for v := range c {
-				out <- v
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					// keep draining c until its producer closes it
+				}
 			}
 			wg.Done()
 		}(c)
@@ -37,3 +37,40 @@ func TestMergeContextGenerator(t *testing.T) {
 func TestMerge2ContextGenerator(t *testing.T) {
 	// TODO
 }
+
+func TestMergeFiniteSequences(t *testing.T) {
+	test.Setup(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	count := 0
+	for n := range Merge(ctx, integer.Sequence(0, 10, 1), integer.Sequence(100, 110, 1), integer.Sequence(200, 205, 1)) {
+		slog.Debug("received item", "value", n)
+		count++
+	}
+	if count != 25 {
+		t.Fatalf("expected 25 items, got %d", count)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("merge did not terminate when all sequences were exhausted")
+	}
+}
+
+func TestMergeBreak(t *testing.T) {
+	test.Setup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	for n := range Merge(ctx, integer.SequenceContext(ctx, 0, 0, 1), integer.SequenceContext(ctx, 1000, 0, 1)) {
+		slog.Debug("received item", "value", n)
+		count++
+		if count == 10 {
+			// the sequences are infinite, so they must be cancelled
+			// for the merge to be able to return
+			cancel()
+			break
+		}
+	}
+	if count != 10 {
+		t.Fatalf("expected 10 items, got %d", count)
+	}
+}
@@ -0,0 +1,170 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+
+	"github.com/dihedron/rawdata"
+	"github.com/dihedron/snoop/generator/merge"
+	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/go-playground/validator/v10"
+)
+
+// Source is a generator of RabbitMQ deliveries; it is implemented both by
+// a single RabbitMQ cluster and by a set of named Clusters.
+type Source interface {
+	// All returns an iterator over the deliveries.
+	All(ctx context.Context) iter.Seq[*amqp091.Delivery]
+	// Err returns the error produced during the execution (if any).
+	Err() error
+	// Reset resets the internal state so the generator can be reused.
+	Reset()
+}
+
+// Cluster is a named RabbitMQ cluster, e.g. the one serving the OpenStack
+// services in a region.
+type Cluster struct {
+	// Name is the name of the cluster.
+	Name string `json:"name" yaml:"name" validate:"required"`
+	// Region is the (optional) OpenStack region served by the cluster.
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
+	// RabbitMQ contains the connection info and topology of the cluster.
+	RabbitMQ `json:",inline" yaml:",inline"`
+}
+
+// Clusters is a set of named RabbitMQ clusters that are consumed concurrently;
+// each delivery is tagged with the name and region of the cluster it comes
+// from, in the amqp.HeaderCluster and amqp.HeaderRegion headers.
+type Clusters struct {
+	// Clusters is the set of RabbitMQ clusters.
+	Clusters []*Cluster `json:"clusters" yaml:"clusters" validate:"required,dive,required"`
+	// err is the internal field keeping track of errors.
+	err error
+}
+
+// Err returns the error produced during the execution (if any).
+func (c *Clusters) Err() error {
+	return c.err
+}
+
+// Reset resets the internal state so the generator can be reused.
+func (c *Clusters) Reset() {
+	c.err = nil
+	for _, cluster := range c.Clusters {
+		cluster.Reset()
+	}
+}
+
+// Validate validates the configuration.
+func (c *Clusters) Validate() error {
+	validate := validator.New()
+	if err := validate.Struct(*c); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, cluster := range c.Clusters {
+		if names[cluster.Name] {
+			return fmt.Errorf("duplicate cluster name %q", cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+	return nil
+}
+
+// All connects to all the clusters in the configuration and returns an
+// iterator over the merged stream of their deliveries; a cluster that cannot
+// be connected to does not prevent the others from being consumed, and its
+// error is available via Err().
+func (c *Clusters) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
+	slog.Debug("starting generator on multiple RabbitMQ clusters")
+	c.err = nil
+
+	if err := c.Validate(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		c.err = err
+		return nil
+	}
+
+	if ctx == nil {
+		slog.Debug("no context provided, allocating default context...")
+		ctx = context.Background()
+	}
+
+	return func(yield func(*amqp091.Delivery) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		sequences := []iter.Seq[*amqp091.Delivery]{}
+		for _, cluster := range c.Clusters {
+			sequence := cluster.All(ctx)
+			if sequence == nil {
+				slog.Error("error connecting to cluster", "cluster", cluster.Name, "region", cluster.Region, "error", cluster.Err())
+				c.err = errors.Join(c.err, fmt.Errorf("cluster %s: %w", cluster.Name, cluster.Err()))
+				continue
+			}
+			slog.Info("consuming messages from cluster", "cluster", cluster.Name, "region", cluster.Region)
+			sequences = append(sequences, tag(cluster, sequence))
+		}
+		if len(sequences) == 0 {
+			slog.Error("no cluster available")
+			return
+		}
+		for message := range merge.Merge(ctx, sequences...) {
+			if !yield(message) {
+				// cancel before breaking out, so that the per-cluster
+				// consumers stop waiting for messages and exit
+				cancel()
+				break
+			}
+		}
+		for _, cluster := range c.Clusters {
+			if err := cluster.Err(); err != nil {
+				c.err = errors.Join(c.err, fmt.Errorf("cluster %s: %w", cluster.Name, err))
+			}
+		}
+	}
+}
+
+// tag sets the name and region of the source cluster in the headers of
+// each delivery.
+func tag(cluster *Cluster, sequence iter.Seq[*amqp091.Delivery]) iter.Seq[*amqp091.Delivery] {
+	return func(yield func(*amqp091.Delivery) bool) {
+		for delivery := range sequence {
+			if delivery.Headers == nil {
+				delivery.Headers = amqp091.Table{}
+			}
+			delivery.Headers[amqp.HeaderCluster] = cluster.Name
+			if cluster.Region != "" {
+				delivery.Headers[amqp.HeaderRegion] = cluster.Region
+			}
+			if !yield(delivery) {
+				return
+			}
+		}
+	}
+}
+
+// Load reads a connection profile from the given path; if the profile
+// contains a set of named clusters, it returns a Clusters, otherwise it
+// returns a single RabbitMQ.
+func Load(path string) (Source, error) {
+	clusters := &Clusters{}
+	if err := rawdata.UnmarshalInto("@"+path, clusters); err != nil {
+		slog.Error("error reading connection info", "path", path, "error", err)
+		return nil, err
+	}
+	if len(clusters.Clusters) > 0 {
+		slog.Debug("profile contains multiple clusters", "count", len(clusters.Clusters))
+		return clusters, nil
+	}
+	rmq := &RabbitMQ{}
+	if err := rawdata.UnmarshalInto("@"+path, rmq); err != nil {
+		slog.Error("error reading connection info", "path", path, "error", err)
+		return nil, err
+	}
+	return rmq, nil
+}
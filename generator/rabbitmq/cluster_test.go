@@ -0,0 +1,140 @@
+package rabbitmq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+
+	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/dihedron/snoop/test"
+)
+
+const clusters = `
+clusters:
+- name: rmq-east
+  region: RegionOne
+  client:
+    id: snoop
+    tag: snoop
+  servers:
+  - address: rmq-east.example.com
+    port: 5672
+  queue:
+    name: snoop
+  bindings:
+  - exchange:
+      name: nova
+      type: topic
+    routingkeys:
+    - notifications.info
+- name: rmq-west
+  region: RegionTwo
+  client:
+    id: snoop
+    tag: snoop
+  servers:
+  - address: rmq-west.example.com
+    port: 5672
+  queue:
+    name: snoop
+  bindings:
+  - exchange:
+      name: keystone
+      type: topic
+    routingkeys:
+    - notifications.info
+`
+
+const single = `
+client:
+  id: snoop
+  tag: snoop
+servers:
+- address: rmq-1.example.com
+  port: 5672
+queue:
+  name: snoop
+bindings:
+- exchange:
+    name: nova
+    type: topic
+  routingkeys:
+  - notifications.info
+`
+
+func TestLoad(t *testing.T) {
+	test.Setup(t)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "clusters.yaml")
+	if err := os.WriteFile(path, []byte(clusters), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := source.(*Clusters)
+	if !ok {
+		t.Fatalf("expected *Clusters, got %T", source)
+	}
+	if len(c.Clusters) != 2 || c.Clusters[1].Name != "rmq-west" || c.Clusters[1].Region != "RegionTwo" {
+		t.Fatalf("unexpected clusters: %+v", c.Clusters)
+	}
+	if c.Clusters[1].Servers[0].Address != "rmq-west.example.com" || c.Clusters[1].Bindings[0].Exchange.Name != "keystone" {
+		t.Fatalf("unexpected cluster configuration: %+v", c.Clusters[1].RabbitMQ)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	c.Clusters[1].Name = "rmq-east"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error on duplicate cluster names")
+	}
+
+	path = filepath.Join(dir, "single.yaml")
+	if err := os.WriteFile(path, []byte(single), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rmq, ok := source.(*RabbitMQ); !ok || len(rmq.Servers) != 1 {
+		t.Fatalf("expected *RabbitMQ with one server, got %T", source)
+	}
+}
+
+func TestTag(t *testing.T) {
+	test.Setup(t)
+	sequence := func(yield func(*amqp091.Delivery) bool) {
+		for _, key := range []string{"a", "b", "c"} {
+			if !yield(&amqp091.Delivery{RoutingKey: key}) {
+				return
+			}
+		}
+	}
+	cluster := &Cluster{Name: "rmq-east", Region: "RegionOne"}
+	count := 0
+	for delivery := range tag(cluster, sequence) {
+		message, err := amqp.DeliveryToMessage(false)(delivery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Cluster != "rmq-east" || message.Region != "RegionOne" {
+			t.Fatalf("unexpected source: %s/%s", message.Cluster, message.Region)
+		}
+		if source := message.Source(); len(source["source"]) != 2 {
+			t.Fatalf("unexpected structured data: %v", source)
+		}
+		count++
+		if count == 2 {
+			break
+		}
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 messages, got %d", count)
+	}
+}
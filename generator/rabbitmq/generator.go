@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"iter"
 	"log/slog"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/goccy/go-json"
 	amqp091 "github.com/rabbitmq/amqp091-go"
 
+	"github.com/dihedron/snoop/generator/merge"
 	"github.com/go-playground/validator/v10"
 	"github.com/streamdal/rabbit"
 )
@@ -50,6 +52,9 @@ type RabbitMQ struct {
 	Queue Queue `json:"queue" yaml:"queue" validate:"required"`
 	// Bindings is the set of bindings to establish the RabbitMQ topology.
 	Bindings []Binding `json:"bindings" yaml:"bindings" validate:"required,dive,required"`
+	// Management contains the (optional) info to connect to the RabbitMQ
+	// management HTTP API.
+	Management *Management `json:"management,omitempty" yaml:"management,omitempty"`
 	// err is the internal field keeping track of errors.
 	err error
 }
@@ -67,7 +72,9 @@ func (r *RabbitMQ) Reset() {
 // All connects to the servers and exchanges in the configuration and returns
 // an iterator that can be used inside a range loop; if an error occurs, or
 // the context is cancelled, the iterator stops yielding values to the range
-// loop and the Err() method can be used to retrieve the error.
+// loop and the Err() method can be used to retrieve the error. If the
+// bindings span multiple virtual hosts, one consumer is opened per virtual
+// host and the resulting streams are merged.
 func (r *RabbitMQ) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
 	slog.Debug("starting generator on RabbitMQ queue")
 	r.err = nil
@@ -85,21 +92,78 @@ func (r *RabbitMQ) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
 		ctx = context.Background()
 	}
 
+	queues := []*rabbit.Rabbit{}
+	for _, group := range r.groups() {
+		queue, err := r.connect(group.vhost, group.bindings)
+		if err != nil {
+			// do not leak the connections to the other virtual hosts
+			for _, queue := range queues {
+				if err := queue.Close(); err != nil {
+					slog.Warn("error closing RabbitMQ client", "error", err)
+				}
+			}
+			r.err = err
+			return nil
+		}
+		queues = append(queues, queue)
+	}
+
+	if len(queues) == 1 {
+		return drain(ctx, queues[0])
+	}
+
+	slog.Info("merging messages from multiple virtual hosts", "count", len(queues))
+	return func(yield func(*amqp091.Delivery) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		sequences := []iter.Seq[*amqp091.Delivery]{}
+		for _, queue := range queues {
+			sequences = append(sequences, drain(ctx, queue))
+		}
+		for message := range merge.Merge(ctx, sequences...) {
+			if !yield(message) {
+				// cancel before breaking out, so that the per-vhost
+				// consumers stop waiting for messages and exit
+				cancel()
+				break
+			}
+		}
+	}
+}
+
+// group is a set of bindings sharing the same virtual host.
+type group struct {
+	vhost    string
+	bindings []Binding
+}
+
+// groups splits the bindings by virtual host, preserving their order; the
+// bindings with no virtual host use the one of the servers.
+func (r *RabbitMQ) groups() []group {
+	groups := []group{}
+	indexes := map[string]int{}
+	for _, binding := range r.Bindings {
+		i, ok := indexes[binding.VHost]
+		if !ok {
+			i = len(groups)
+			indexes[binding.VHost] = i
+			groups = append(groups, group{vhost: binding.VHost})
+		}
+		groups[i].bindings = append(groups[i].bindings, binding)
+	}
+	return groups
+}
+
+// connect creates a RabbitMQ client for the given virtual host and bindings;
+// if the virtual host is empty, that of each server is used.
+func (r *RabbitMQ) connect(vhost string, bindings []Binding) (*rabbit.Rabbit, error) {
 	// gather the URLs of the servers
 	urls := []string{}
 	for _, server := range r.Servers {
-		proto := ""
-		if server.TLSInfo != nil && server.TLSInfo.EnableTLS {
-			proto = "amqps"
-		} else {
-			proto = "amqp"
-		}
-		url := ""
-		if server.Username != nil && server.Password != nil {
-			url = fmt.Sprintf("%s://%s:%s@%s:%d/", proto, *server.Username, *server.Password, server.Address, server.Port)
-		} else {
-			url = fmt.Sprintf("%s://%s:%d/", proto, server.Address, server.Port)
+		if vhost != "" {
+			server.VHost = vhost
 		}
+		url := server.URL()
 		urls = append(urls, url)
 		slog.Info("RabbitMQ server url", "value", url)
 	}
@@ -107,8 +171,8 @@ func (r *RabbitMQ) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
 	slog.Debug("connecting to RabbitMQ server URLs", "urls", urls)
 
 	binds := []rabbit.Binding{}
-	for _, binding := range r.Bindings {
-		slog.Info("adding exchange with routing keys", "exchange name", binding.Exchange.Name, "routing keys", binding.RoutingKeys)
+	for _, binding := range bindings {
+		slog.Info("adding exchange with routing keys", "exchange name", binding.Exchange.Name, "routing keys", binding.RoutingKeys, "vhost", vhost)
 		binds = append(binds, rabbit.Binding{
 			ExchangeName:       binding.Exchange.Name,
 			ExchangeType:       binding.Exchange.Type.String(),
@@ -136,6 +200,7 @@ func (r *RabbitMQ) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
 		AppID:             DefaultClientID,
 		ConsumerTag:       DefaultClientID,
 		ConnectionTimeout: r.Client.Timeout,
+		Log:               &logger{vhost: vhost},
 	}
 	if r.Client.ID != "" {
 		options.AppID = r.Client.ID
@@ -148,11 +213,15 @@ func (r *RabbitMQ) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
 	queue, err := rabbit.New(options)
 	if err != nil {
 		slog.Error("unable to instantiate RabbitMQ client", "error", err)
-		r.err = err
-		return nil
+		return nil, err
 	}
 	slog.Info("RabbitMQ client ready to drain messages")
+	return queue, nil
+}
 
+// drain returns an iterator over the messages consumed from the given
+// RabbitMQ client.
+func drain(ctx context.Context, queue *rabbit.Rabbit) iter.Seq[*amqp091.Delivery] {
 	return func(yield func(*amqp091.Delivery) bool) {
 		slog.Debug("retrieving events with an interposed channel")
 
@@ -190,7 +259,6 @@ func (r *RabbitMQ) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
 			case message, ok := <-values:
 				if !ok {
 					slog.Debug("inner consumer: message queue is closed")
-					r.err = nil
 					break loop
 				}
 				slog.Debug("inner consumer: yielding dequeued message")
@@ -199,12 +267,10 @@ func (r *RabbitMQ) All(ctx context.Context) iter.Seq[*amqp091.Delivery] {
 				} else {
 					slog.Debug("inner consumer: range loop broke out (cancelling context)")
 					cancel()
-					r.err = nil
 					break loop
 				}
 			case <-ctx.Done():
 				slog.Info("inner consumer: context done")
-				r.err = nil
 				break loop
 			}
 		}
@@ -240,11 +306,56 @@ type Server struct {
 	Username *string `json:"username,omitempty" yaml:"username,omitempty"`
 	// Password is the password to use to connect to the RabbitMQ server.
 	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
+	// VHost is the virtual host to connect to; if empty, the root virtual
+	// host ("/") is used.
+	VHost string `json:"vhost,omitempty" yaml:"vhost,omitempty"`
 	// TLSInfo contains the information to configure TLS on the connection
 	// to the RabbitMQ server.
 	TLSInfo *TLSInfo `json:"tlsinfo,omitempty" yaml:"tlsinfo,omitempty"`
 }
 
+// URL returns the AMQP URL of the server, including the credentials if
+// available and the (escaped) virtual host.
+func (s *Server) URL() string {
+	proto := ""
+	if s.TLSInfo != nil && s.TLSInfo.EnableTLS {
+		proto = "amqps"
+	} else {
+		proto = "amqp"
+	}
+	vhost := ""
+	if s.VHost != "" && s.VHost != "/" {
+		vhost = url.PathEscape(s.VHost)
+	}
+	if s.Username != nil && s.Password != nil {
+		return fmt.Sprintf("%s://%s:%s@%s:%d/%s", proto, *s.Username, *s.Password, s.Address, s.Port, vhost)
+	}
+	return fmt.Sprintf("%s://%s:%d/%s", proto, s.Address, s.Port, vhost)
+}
+
+// GetVHost returns the virtual host of the server, defaulting to "/".
+func (s *Server) GetVHost() string {
+	if s.VHost == "" {
+		return "/"
+	}
+	return s.VHost
+}
+
+// Management contains the information needed to connect to the RabbitMQ
+// management HTTP API; if no credentials are provided, those of the first
+// server are used.
+type Management struct {
+	// URL is the base URL of the management API (e.g. http://rmq-1:15672).
+	URL string `json:"url" yaml:"url" validate:"required,url"`
+	// Username is the username to use to connect to the management API.
+	Username *string `json:"username,omitempty" yaml:"username,omitempty"`
+	// Password is the password to use to connect to the management API.
+	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
+	// TLSInfo contains the information to configure TLS on the connection
+	// to the management API.
+	TLSInfo *TLSInfo `json:"tlsinfo,omitempty" yaml:"tlsinfo,omitempty"`
+}
+
 // TLSInfo contains the information needed to set-up a TLS endpoint
 // or connection, such as a private key/certificate pair; it should
 // be embedded as a pointer into any relevan configuration struct, so
@@ -372,6 +483,9 @@ type Queue struct {
 
 // Binding is the exchange and routing key(s) to use for connecting to RabbitMQ
 type Binding struct {
+	// VHost is the (optional) virtual host of the exchange; if empty, the
+	// virtual host of the servers is used.
+	VHost string `json:"vhost,omitempty" yaml:"vhost,omitempty" mapstructure:"vhost,omitempty"`
 	// Exchange is the name of the RabbitMQ exchange to connect to.
 	Exchange *Exchange `json:"exchange,omitempty" yaml:"exchange,omitempty" mapstructure:"exchange,omitempty"`
 	// RoutingKeys is the set of routing keys to use on the given exchange.
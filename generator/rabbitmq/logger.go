@@ -0,0 +1,66 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// reconnects is the number of times the RabbitMQ clients reconnected to the
+// server after losing the connection.
+var reconnects atomic.Int64
+
+// Reconnects returns the number of times the RabbitMQ clients reconnected to
+// the server after losing the connection, since the program started.
+func Reconnects() int64 {
+	return reconnects.Load()
+}
+
+// logger adapts slog to the logger interface of the RabbitMQ client library;
+// since the library does not otherwise report reconnections, they are
+// counted by watching its log.
+type logger struct {
+	vhost string
+}
+
+func (l *logger) Debug(args ...any) {
+	l.log(slog.LevelDebug, fmt.Sprint(args...))
+}
+
+func (l *logger) Debugf(format string, args ...any) {
+	l.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) Info(args ...any) {
+	l.log(slog.LevelInfo, fmt.Sprint(args...))
+}
+
+func (l *logger) Infof(format string, args ...any) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) Warn(args ...any) {
+	l.log(slog.LevelWarn, fmt.Sprint(args...))
+}
+
+func (l *logger) Warnf(format string, args ...any) {
+	l.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) Error(args ...any) {
+	l.log(slog.LevelError, fmt.Sprint(args...))
+}
+
+func (l *logger) Errorf(format string, args ...any) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) log(level slog.Level, message string) {
+	if strings.HasPrefix(message, "successfully reconnected") {
+		reconnects.Add(1)
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "RabbitMQ client: "+message, "vhost", l.vhost)
+}
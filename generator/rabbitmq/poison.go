@@ -0,0 +1,379 @@
+package rabbitmq
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+
+	"github.com/dihedron/snoop/openstack/amqp"
+)
+
+// DefaultMaxFailures is the default number of times a message can fail to
+// be processed before it is considered poison.
+const DefaultMaxFailures = 3
+
+const (
+	// failuresTTL is how long the failures of a message are remembered
+	// after the last one; a message that is requeued is normally redelivered
+	// right away.
+	failuresTTL = time.Hour
+	// maxFailing is the maximum number of messages whose failures are
+	// remembered at once.
+	maxFailing = 100_000
+)
+
+const (
+	// HeaderError is the header carrying the last processing error of a
+	// dead-lettered message.
+	HeaderError = "x-snoop-error"
+	// HeaderFailures is the header carrying the number of times a
+	// dead-lettered message failed to be processed.
+	HeaderFailures = "x-snoop-failures"
+)
+
+// PoisonAction is what is done with a message that repeatedly fails to be
+// processed.
+type PoisonAction string
+
+const (
+	// PoisonReject rejects (nacks) the message without requeueing it; if
+	// the queue has a dead-letter exchange configured, the broker routes
+	// the message there.
+	PoisonReject PoisonAction = "reject"
+	// PoisonDeadLetter republishes the message to a dead-letter exchange,
+	// then acknowledges it.
+	PoisonDeadLetter PoisonAction = "dead-letter"
+	// PoisonQuarantine writes the message to a local quarantine file, then
+	// acknowledges it.
+	PoisonQuarantine PoisonAction = "quarantine"
+)
+
+// Publisher is implemented by AMQP channels, and is used to republish poison
+// messages to the dead-letter exchange on the broker they came from.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp091.Publishing) error
+}
+
+// PoisonStats contains the counters of the outcomes of message settlement.
+type PoisonStats struct {
+	// Acked is the number of messages successfully processed and acknowledged.
+	Acked int64 `json:"acked" yaml:"acked"`
+	// Requeued is the number of failed messages that were nacked and requeued
+	// for another attempt.
+	Requeued int64 `json:"requeued" yaml:"requeued"`
+	// Rejected is the number of poison messages nacked without requeueing.
+	Rejected int64 `json:"rejected" yaml:"rejected"`
+	// DeadLettered is the number of poison messages republished to the
+	// dead-letter exchange.
+	DeadLettered int64 `json:"deadlettered" yaml:"deadlettered"`
+	// Quarantined is the number of poison messages written to the quarantine
+	// file.
+	Quarantined int64 `json:"quarantined" yaml:"quarantined"`
+	// Errors is the number of messages that could not be settled.
+	Errors int64 `json:"errors" yaml:"errors"`
+}
+
+// Poison settles deliveries: successfully processed ones are acknowledged,
+// failed ones are nacked and requeued until they have failed MaxFailures
+// times, after which they are considered poison and handled according to
+// the configured action; every delivery is either acked or nacked, so none
+// is left pending until the channel closes. The failures of a bounded number
+// of messages are remembered, for a limited time, forgetting those that
+// failed least recently first, as in the Dedup transformer.
+type Poison struct {
+	maxFailures int
+	action      PoisonAction
+	exchange    string
+	routingKey  string
+	quarantine  io.Writer
+	lock        sync.Mutex
+	ttl         time.Duration
+	maxFailing  int
+	failing     *list.List
+	failures    map[string]*list.Element
+	stats       PoisonStats
+}
+
+// failure is the number of times a message failed, with the time of the last
+// failure.
+type failure struct {
+	key   string
+	count int
+	last  time.Time
+}
+
+// PoisonOption is a functional option type that allows us to configure the
+// Poison handler.
+type PoisonOption func(*Poison)
+
+// WithMaxFailures sets the number of failures after which a message is
+// considered poison.
+func WithMaxFailures(n int) PoisonOption {
+	return func(p *Poison) {
+		if n > 0 {
+			p.maxFailures = n
+		}
+	}
+}
+
+// WithReject makes poison messages be nacked without requeueing.
+func WithReject() PoisonOption {
+	return func(p *Poison) {
+		p.action = PoisonReject
+	}
+}
+
+// WithDeadLetter makes poison messages be republished to the given exchange,
+// using the given routing key or the original one if empty.
+func WithDeadLetter(exchange string, routingKey string) PoisonOption {
+	return func(p *Poison) {
+		p.action = PoisonDeadLetter
+		p.exchange = exchange
+		p.routingKey = routingKey
+	}
+}
+
+// WithQuarantine makes poison messages be written to the given writer, one
+// JSON message per line, in the same format used by the recordings.
+func WithQuarantine(writer io.Writer) PoisonOption {
+	return func(p *Poison) {
+		p.action = PoisonQuarantine
+		p.quarantine = writer
+	}
+}
+
+// NewPoison creates a new Poison handler; by default, messages are rejected
+// after DefaultMaxFailures failures.
+func NewPoison(options ...PoisonOption) (*Poison, error) {
+	p := &Poison{
+		maxFailures: DefaultMaxFailures,
+		action:      PoisonReject,
+		ttl:         failuresTTL,
+		maxFailing:  maxFailing,
+		failing:     list.New(),
+		failures:    map[string]*list.Element{},
+	}
+	for _, option := range options {
+		option(p)
+	}
+	switch p.action {
+	case PoisonDeadLetter:
+		if p.exchange == "" {
+			slog.Error("no dead-letter exchange provided")
+			return nil, errors.New("no dead-letter exchange provided")
+		}
+	case PoisonQuarantine:
+		if p.quarantine == nil {
+			slog.Error("no quarantine writer provided")
+			return nil, errors.New("no quarantine writer provided")
+		}
+	}
+	return p, nil
+}
+
+// Settle acknowledges the delivery if the error is nil, otherwise it
+// requeues it or, if it failed too many times, handles it as poison.
+func (p *Poison) Settle(ctx context.Context, delivery *amqp091.Delivery, err error) error {
+	key := identify(delivery)
+
+	if err == nil {
+		p.lock.Lock()
+		p.forget(key)
+		p.lock.Unlock()
+		if err := delivery.Ack(false); err != nil {
+			slog.Error("error acknowledging message", "id", delivery.MessageId, "error", err)
+			p.count(&p.stats.Errors)
+			return err
+		}
+		p.count(&p.stats.Acked)
+		return nil
+	}
+
+	p.lock.Lock()
+	failures := p.fail(key, time.Now())
+	if failures >= p.maxFailures {
+		p.forget(key)
+	}
+	p.lock.Unlock()
+
+	if failures < p.maxFailures {
+		slog.Warn("message processing failed, requeueing", "id", delivery.MessageId, "failures", failures, "max failures", p.maxFailures, "error", err)
+		if err := delivery.Nack(false, true); err != nil {
+			slog.Error("error requeueing message", "id", delivery.MessageId, "error", err)
+			p.count(&p.stats.Errors)
+			return err
+		}
+		p.count(&p.stats.Requeued)
+		return nil
+	}
+
+	slog.Warn("poison message detected", "id", delivery.MessageId, "failures", failures, "action", p.action, "error", err)
+	var result error
+	switch p.action {
+	case PoisonDeadLetter:
+		result = p.deadLetter(ctx, delivery, failures, err)
+	case PoisonQuarantine:
+		result = p.quarantineMessage(delivery)
+	default:
+		if result = delivery.Nack(false, false); result == nil {
+			p.count(&p.stats.Rejected)
+		}
+	}
+	if result != nil {
+		// never hold on to the delivery: if the action failed, reject it
+		slog.Error("error handling poison message, rejecting it", "id", delivery.MessageId, "action", p.action, "error", result)
+		p.count(&p.stats.Errors)
+		if err := delivery.Nack(false, false); err != nil {
+			slog.Error("error rejecting message", "id", delivery.MessageId, "error", err)
+		}
+	}
+	return result
+}
+
+// Stats returns a snapshot of the counters.
+func (p *Poison) Stats() PoisonStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
+
+// fail records a failure of the message with the given key and returns the
+// number of times it failed; it must be called with the lock held.
+func (p *Poison) fail(key string, now time.Time) int {
+	p.evict(now)
+	e, ok := p.failures[key]
+	if !ok {
+		e = p.failing.PushFront(&failure{key: key})
+		p.failures[key] = e
+	} else {
+		p.failing.MoveToFront(e)
+	}
+	f := e.Value.(*failure)
+	f.count++
+	f.last = now
+	p.evict(now)
+	return f.count
+}
+
+// forget forgets the failures of the message with the given key; it must be
+// called with the lock held.
+func (p *Poison) forget(key string) {
+	if e, ok := p.failures[key]; ok {
+		delete(p.failures, key)
+		p.failing.Remove(e)
+	}
+}
+
+// evict forgets the failures that are too old and, if too many messages are
+// failing, those of the messages that failed least recently; it must be
+// called with the lock held.
+func (p *Poison) evict(now time.Time) {
+	for e := p.failing.Back(); e != nil; e = p.failing.Back() {
+		f := e.Value.(*failure)
+		expired := p.ttl > 0 && now.Sub(f.last) >= p.ttl
+		full := p.maxFailing > 0 && p.failing.Len() > p.maxFailing
+		if !expired && !full {
+			return
+		}
+		p.forget(f.key)
+	}
+}
+
+func (p *Poison) count(counter *int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	*counter++
+}
+
+// deadLetter republishes the delivery to the dead-letter exchange over the
+// channel it was received from, then acknowledges it.
+func (p *Poison) deadLetter(ctx context.Context, delivery *amqp091.Delivery, failures int, cause error) error {
+	publisher, ok := delivery.Acknowledger.(Publisher)
+	if !ok {
+		return fmt.Errorf("cannot republish on %T", delivery.Acknowledger)
+	}
+	headers := amqp091.Table{}
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	headers[HeaderError] = cause.Error()
+	headers[HeaderFailures] = int32(failures)
+	routingKey := p.routingKey
+	if routingKey == "" {
+		routingKey = delivery.RoutingKey
+	}
+	err := publisher.PublishWithContext(ctx, p.exchange, routingKey, false, false, amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    delivery.DeliveryMode,
+		Priority:        delivery.Priority,
+		CorrelationId:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		Expiration:      delivery.Expiration,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		UserId:          delivery.UserId,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("poison message sent to dead-letter exchange", "id", delivery.MessageId, "exchange", p.exchange, "routing key", routingKey)
+	if err := delivery.Ack(false); err != nil {
+		return err
+	}
+	p.count(&p.stats.DeadLettered)
+	return nil
+}
+
+// quarantineMessage writes the delivery to the quarantine file, then
+// acknowledges it.
+func (p *Poison) quarantineMessage(delivery *amqp091.Delivery) error {
+	// use the same JSON representation as the recordings, so that the
+	// quarantined messages can be played back
+	message, err := amqp.DeliveryToMessage(false)(delivery)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	_, err = fmt.Fprintf(p.quarantine, "%s\n", line)
+	p.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	slog.Info("poison message quarantined", "id", delivery.MessageId)
+	if err := delivery.Ack(false); err != nil {
+		return err
+	}
+	p.count(&p.stats.Quarantined)
+	return nil
+}
+
+// identify returns a key identifying the delivery across redeliveries.
+func identify(delivery *amqp091.Delivery) string {
+	if delivery.MessageId != "" {
+		return "id:" + delivery.MessageId
+	}
+	hash := sha256.New()
+	hash.Write([]byte(strings.Join([]string{delivery.Exchange, delivery.RoutingKey, delivery.CorrelationId}, "\x00")))
+	hash.Write(delivery.Body)
+	return "hash:" + hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,150 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+
+	"github.com/dihedron/snoop/test"
+)
+
+// channel is a fake AMQP channel that records acks, nacks and publishings.
+type channel struct {
+	acks      int
+	requeues  int
+	rejects   int
+	published []amqp091.Publishing
+	exchange  string
+}
+
+func (c *channel) Ack(tag uint64, multiple bool) error {
+	c.acks++
+	return nil
+}
+
+func (c *channel) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		c.requeues++
+	} else {
+		c.rejects++
+	}
+	return nil
+}
+
+func (c *channel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+func (c *channel) PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp091.Publishing) error {
+	c.exchange = exchange
+	c.published = append(c.published, msg)
+	return nil
+}
+
+func deliver(c *channel, id string) *amqp091.Delivery {
+	return &amqp091.Delivery{
+		Acknowledger: c,
+		MessageId:    id,
+		RoutingKey:   "notifications.info",
+		Body:         []byte(`{"oslo.version": "2.0"}`),
+	}
+}
+
+func TestPoisonReject(t *testing.T) {
+	test.Setup(t)
+	ctx := context.Background()
+	c := &channel{}
+	poison, err := NewPoison(WithMaxFailures(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("decode failure")
+	for range 3 {
+		if err := poison.Settle(ctx, deliver(c, "poison"), failure); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := poison.Settle(ctx, deliver(c, "good"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.requeues != 2 || c.rejects != 1 || c.acks != 1 {
+		t.Fatalf("unexpected settlements: %+v", c)
+	}
+	stats := poison.Stats()
+	if stats.Requeued != 2 || stats.Rejected != 1 || stats.Acked != 1 || stats.Errors != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// the failures of a bounded number of messages are remembered, for a
+	// limited time
+	poison.maxFailing = 2
+	for _, id := range []string{"a", "b", "c"} {
+		if err := poison.Settle(ctx, deliver(c, id), failure); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := poison.failures["id:a"]; ok || len(poison.failures) != 2 || poison.failing.Len() != 2 {
+		t.Fatalf("unexpected failures: %v", poison.failures)
+	}
+	poison.evict(time.Now().Add(failuresTTL))
+	if len(poison.failures) != 0 || poison.failing.Len() != 0 {
+		t.Fatalf("unexpected failures: %v", poison.failures)
+	}
+}
+
+func TestPoisonDeadLetter(t *testing.T) {
+	test.Setup(t)
+	ctx := context.Background()
+	c := &channel{}
+	poison, err := NewPoison(WithMaxFailures(1), WithDeadLetter("snoop.dlx", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := poison.Settle(ctx, deliver(c, "poison"), errors.New("decode failure")); err != nil {
+		t.Fatal(err)
+	}
+	if c.exchange != "snoop.dlx" || len(c.published) != 1 || c.acks != 1 {
+		t.Fatalf("unexpected settlements: %+v", c)
+	}
+	if c.published[0].Headers[HeaderError] != "decode failure" || c.published[0].MessageId != "poison" {
+		t.Fatalf("unexpected publishing: %+v", c.published[0])
+	}
+	if stats := poison.Stats(); stats.DeadLettered != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := NewPoison(WithDeadLetter("", "")); err == nil {
+		t.Fatal("expected error with no dead-letter exchange")
+	}
+}
+
+func TestPoisonQuarantine(t *testing.T) {
+	test.Setup(t)
+	ctx := context.Background()
+	c := &channel{}
+	var buffer bytes.Buffer
+	poison, err := NewPoison(WithMaxFailures(2), WithQuarantine(&buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := poison.Settle(ctx, deliver(c, ""), errors.New("decode failure")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.requeues != 1 || c.acks != 1 {
+		t.Fatalf("unexpected settlements: %+v", c)
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"routingKey":"notifications.info"`) {
+		t.Fatalf("unexpected quarantine file contents: %q", buffer.String())
+	}
+	if stats := poison.Stats(); stats.Quarantined != 1 || stats.Requeued != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
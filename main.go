@@ -18,15 +18,21 @@
 package main
 
 import (
+	"errors"
 	"os"
 
 	"github.com/dihedron/snoop/command"
+	"github.com/dihedron/snoop/command/common"
 	"github.com/jessevdk/go-flags"
 )
 
 func main() {
 	options := command.Commands{}
 	if _, err := flags.NewParser(&options, flags.Default).Parse(); err != nil {
+		var exit *common.ExitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.Code)
+		}
 		switch flagsErr := err.(type) {
 		case flags.ErrorType:
 			if flagsErr == flags.ErrHelp {
@@ -0,0 +1,135 @@
+package management
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// KnownServices is the set of OpenStack services whose exchanges are
+// recognised as notification exchanges even when no notification queue
+// is currently bound to them.
+var KnownServices = []string{
+	"aodh",
+	"barbican",
+	"ceilometer",
+	"cinder",
+	"designate",
+	"glance",
+	"heat",
+	"ironic",
+	"keystone",
+	"magnum",
+	"manila",
+	"neutron",
+	"nova",
+	"octavia",
+	"openstack",
+	"placement",
+	"swift",
+	"trove",
+}
+
+// DefaultTopics is the set of topics suggested for a known service
+// exchange that has no notification queue bound to it.
+var DefaultTopics = []string{
+	"notifications.info",
+	"notifications.error",
+}
+
+// notificationTopic matches the routing keys used by oslo.messaging to
+// publish notifications, e.g. "notifications.info" or
+// "versioned_notifications.error"; wildcards are accepted too.
+var notificationTopic = regexp.MustCompile(`^([a-zA-Z0-9_-]+\.)?(versioned_)?notifications\.(audit|debug|info|warn|warning|error|critical|sample|\*|#)$`)
+
+// Notifications is an exchange on which OpenStack notifications are
+// published, along with the topics (routing keys) it routes them with.
+type Notifications struct {
+	// Exchange is the exchange as reported by the management API.
+	Exchange Exchange `json:"exchange" yaml:"exchange"`
+	// Topics is the sorted set of routing keys carrying notifications.
+	Topics []string `json:"topics" yaml:"topics"`
+	// Defaulted is true if no notification binding was found and the
+	// topics are the DefaultTopics.
+	Defaulted bool `json:"defaulted,omitempty" yaml:"defaulted,omitempty"`
+	// Queues are the queues already bound to the exchange with any of the
+	// topics, sorted by name.
+	Queues []Queue `json:"queues,omitempty" yaml:"queues,omitempty"`
+}
+
+// IsNotificationTopic returns whether the given routing key looks like
+// one used by oslo.messaging to publish notifications.
+func IsNotificationTopic(key string) bool {
+	return notificationTopic.MatchString(key)
+}
+
+// Discover inspects the given exchanges and bindings and returns those
+// exchanges that carry OpenStack notifications, together with the topics
+// found in their bindings and the queues bound with them, sorted by virtual
+// host and name; if all is set, every non-system exchange having at least
+// one binding is returned, with all its routing keys, including the empty
+// one used to bind to fanout and headers exchanges.
+func Discover(exchanges []Exchange, bindings []Binding, queues []Queue, all bool) []Notifications {
+	type key struct {
+		vhost string
+		name  string
+	}
+	byName := map[key]Queue{}
+	for _, queue := range queues {
+		byName[key{vhost: queue.VHost, name: queue.Name}] = queue
+	}
+	topics := map[key][]string{}
+	bound := map[key][]Queue{}
+	for _, binding := range bindings {
+		if binding.Source == "" {
+			continue
+		}
+		if !all && !IsNotificationTopic(binding.RoutingKey) {
+			continue
+		}
+		k := key{vhost: binding.VHost, name: binding.Source}
+		if !slices.Contains(topics[k], binding.RoutingKey) {
+			topics[k] = append(topics[k], binding.RoutingKey)
+		}
+		if binding.DestinationType != "queue" {
+			continue
+		}
+		queue, ok := byName[key{vhost: binding.VHost, name: binding.Destination}]
+		if ok && !slices.ContainsFunc(bound[k], func(q Queue) bool { return q.Name == queue.Name }) {
+			bound[k] = append(bound[k], queue)
+		}
+	}
+
+	result := []Notifications{}
+	for _, exchange := range exchanges {
+		if exchange.Name == "" || exchange.Internal || strings.HasPrefix(exchange.Name, "amq.") {
+			continue
+		}
+		k := key{vhost: exchange.VHost, name: exchange.Name}
+		if found, ok := topics[k]; ok {
+			slices.Sort(found)
+			slices.SortFunc(bound[k], func(a, b Queue) int {
+				return cmp.Compare(a.Name, b.Name)
+			})
+			result = append(result, Notifications{
+				Exchange: exchange,
+				Topics:   found,
+				Queues:   bound[k],
+			})
+		} else if slices.Contains(KnownServices, exchange.Name) {
+			result = append(result, Notifications{
+				Exchange:  exchange,
+				Topics:    slices.Clone(DefaultTopics),
+				Defaulted: true,
+			})
+		}
+	}
+	slices.SortFunc(result, func(a, b Notifications) int {
+		return cmp.Or(
+			cmp.Compare(a.Exchange.VHost, b.Exchange.VHost),
+			cmp.Compare(a.Exchange.Name, b.Exchange.Name),
+		)
+	})
+	return result
+}
@@ -0,0 +1,194 @@
+package management
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	// DefaultPort is the port on which the RabbitMQ management plugin
+	// listens by default.
+	DefaultPort = 15672
+	// DefaultTimeout is the default timeout for requests to the
+	// management API.
+	DefaultTimeout = 30 * time.Second
+)
+
+// Option is a functional option type that allows us to configure the Client.
+type Option func(*Client)
+
+// WithCredentials allows to specify the username and password used to
+// authenticate against the management API.
+func WithCredentials(username string, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithHTTPClient allows to provide a custom HTTP client, e.g. one with a
+// specific transport or timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		if client != nil {
+			c.client = client
+		}
+	}
+}
+
+// WithTLS allows to configure the TLS settings used when the management
+// API is exposed over HTTPS.
+func WithTLS(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tls = config
+	}
+}
+
+// Client is a minimal client for the RabbitMQ management HTTP API, which
+// only supports the read-only calls needed to discover the broker topology.
+type Client struct {
+	url      string
+	username string
+	password string
+	tls      *tls.Config
+	client   *http.Client
+}
+
+// New creates a new Client for the management API at the given base URL
+// (e.g. "http://rmq-1.example.com:15672"), applying the given options.
+func New(baseURL string, options ...Option) (*Client, error) {
+	if _, err := url.Parse(baseURL); err != nil || baseURL == "" {
+		slog.Error("invalid management API URL", "url", baseURL, "error", err)
+		return nil, fmt.Errorf("invalid management API URL: %q", baseURL)
+	}
+	c := &Client{
+		url: strings.TrimSuffix(baseURL, "/"),
+	}
+	// apply functional options
+	for _, option := range options {
+		option(c)
+	}
+	if c.client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if c.tls != nil {
+			transport.TLSClientConfig = c.tls
+		}
+		c.client = &http.Client{
+			Transport: transport,
+			Timeout:   DefaultTimeout,
+		}
+	}
+	return c, nil
+}
+
+// Exchange is an exchange as reported by the management API.
+type Exchange struct {
+	Name       string `json:"name" yaml:"name"`
+	VHost      string `json:"vhost" yaml:"vhost"`
+	Type       string `json:"type" yaml:"type"`
+	Durable    bool   `json:"durable" yaml:"durable"`
+	AutoDelete bool   `json:"auto_delete" yaml:"auto_delete"`
+	Internal   bool   `json:"internal" yaml:"internal"`
+}
+
+// Binding is a binding between a source exchange and a destination queue
+// (or exchange) as reported by the management API.
+type Binding struct {
+	Source          string `json:"source" yaml:"source"`
+	VHost           string `json:"vhost" yaml:"vhost"`
+	Destination     string `json:"destination" yaml:"destination"`
+	DestinationType string `json:"destination_type" yaml:"destination_type"`
+	RoutingKey      string `json:"routing_key" yaml:"routing_key"`
+}
+
+// Queue is a queue as reported by the management API.
+type Queue struct {
+	Name       string `json:"name" yaml:"name"`
+	VHost      string `json:"vhost" yaml:"vhost"`
+	Durable    bool   `json:"durable" yaml:"durable"`
+	AutoDelete bool   `json:"auto_delete" yaml:"auto_delete"`
+	Exclusive  bool   `json:"exclusive" yaml:"exclusive"`
+	Messages   int64  `json:"messages" yaml:"messages"`
+	Consumers  int64  `json:"consumers" yaml:"consumers"`
+}
+
+// Exchanges returns the exchanges in the given virtual host; if the virtual
+// host is empty, the exchanges in all virtual hosts are returned.
+func (c *Client) Exchanges(ctx context.Context, vhost string) ([]Exchange, error) {
+	exchanges := []Exchange{}
+	if err := c.get(ctx, "exchanges", vhost, &exchanges); err != nil {
+		return nil, err
+	}
+	return exchanges, nil
+}
+
+// Bindings returns the bindings in the given virtual host; if the virtual
+// host is empty, the bindings in all virtual hosts are returned.
+func (c *Client) Bindings(ctx context.Context, vhost string) ([]Binding, error) {
+	bindings := []Binding{}
+	if err := c.get(ctx, "bindings", vhost, &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// Queues returns the queues in the given virtual host; if the virtual host
+// is empty, the queues in all virtual hosts are returned.
+func (c *Client) Queues(ctx context.Context, vhost string) ([]Queue, error) {
+	queues := []Queue{}
+	if err := c.get(ctx, "queues", vhost, &queues); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+// get retrieves the given resource, optionally scoped to a virtual host, and
+// unmarshals the JSON response into the given value.
+func (c *Client) get(ctx context.Context, resource string, vhost string, v any) error {
+	endpoint := c.url + "/api/" + resource
+	if vhost != "" {
+		endpoint = endpoint + "/" + url.PathEscape(vhost)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		slog.Error("error creating request", "url", endpoint, "error", err)
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+	slog.Debug("querying management API", "url", endpoint)
+	response, err := c.client.Do(request)
+	if err != nil {
+		slog.Error("error querying management API", "url", endpoint, "error", err)
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Error("error reading management API response", "url", endpoint, "error", err)
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		slog.Error("management API returned an error", "url", endpoint, "status", response.StatusCode, "body", string(body))
+		return fmt.Errorf("management API returned %s for %s", response.Status, resource)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		slog.Error("error parsing management API response", "url", endpoint, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package management
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/test"
+)
+
+const exchanges = `[
+	{"name": "", "vhost": "/", "type": "direct", "durable": true},
+	{"name": "amq.topic", "vhost": "/", "type": "topic", "durable": true},
+	{"name": "nova", "vhost": "/", "type": "topic", "durable": false},
+	{"name": "neutron", "vhost": "/", "type": "topic", "durable": false},
+	{"name": "keystone", "vhost": "/", "type": "topic", "durable": false},
+	{"name": "cinder", "vhost": "/", "type": "topic", "durable": false},
+	{"name": "q-agent-notifier-port-update_fanout", "vhost": "/", "type": "fanout", "auto_delete": true}
+]`
+
+const bindings = `[
+	{"source": "", "vhost": "/", "destination": "notifications.info", "destination_type": "queue", "routing_key": "notifications.info"},
+	{"source": "nova", "vhost": "/", "destination": "notifications.info", "destination_type": "queue", "routing_key": "notifications.info"},
+	{"source": "nova", "vhost": "/", "destination": "notifications.error", "destination_type": "queue", "routing_key": "notifications.error"},
+	{"source": "nova", "vhost": "/", "destination": "versioned_notifications.info", "destination_type": "queue", "routing_key": "versioned_notifications.info"},
+	{"source": "nova", "vhost": "/", "destination": "compute", "destination_type": "queue", "routing_key": "compute"},
+	{"source": "neutron", "vhost": "/", "destination": "notifications.info", "destination_type": "queue", "routing_key": "notifications.info"},
+	{"source": "keystone", "vhost": "/", "destination": "keystone.info", "destination_type": "queue", "routing_key": "keystone.notifications.info"},
+	{"source": "q-agent-notifier-port-update_fanout", "vhost": "/", "destination": "q-agent", "destination_type": "queue", "routing_key": ""}
+]`
+
+const queues = `[
+	{"name": "notifications.info", "vhost": "/", "durable": false, "messages": 42, "consumers": 1},
+	{"name": "notifications.error", "vhost": "/", "durable": false, "messages": 0, "consumers": 0}
+]`
+
+func newServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "guest" || password != "guest" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		slog.Debug("request received", "path", r.URL.EscapedPath())
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.EscapedPath() {
+		case "/api/exchanges", "/api/exchanges/%2F":
+			w.Write([]byte(exchanges))
+		case "/api/bindings", "/api/bindings/%2F":
+			w.Write([]byte(bindings))
+		case "/api/queues", "/api/queues/%2F":
+			w.Write([]byte(queues))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	test.Setup(t)
+	server := newServer(t)
+	defer server.Close()
+
+	client, err := New(server.URL, WithCredentials("guest", "guest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	e, err := client.Exchanges(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e) != 7 {
+		t.Fatalf("expected 7 exchanges, got %d", len(e))
+	}
+	b, err := client.Bindings(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 8 {
+		t.Fatalf("expected 8 bindings, got %d", len(b))
+	}
+	q, err := client.Queues(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q) != 2 || q[0].Messages != 42 || q[0].Consumers != 1 {
+		t.Fatalf("unexpected queues: %s", format.ToJSON(q))
+	}
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	test.Setup(t)
+	server := newServer(t)
+	defer server.Close()
+
+	client, err := New(server.URL, WithCredentials("guest", "wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Exchanges(context.Background(), "/"); err == nil {
+		t.Fatal("expected error with invalid credentials")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	test.Setup(t)
+	server := newServer(t)
+	defer server.Close()
+
+	client, err := New(server.URL, WithCredentials("guest", "guest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := client.Exchanges(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := client.Bindings(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := client.Queues(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovered := Discover(e, b, q, false)
+	slog.Debug("discovered notification exchanges", "value", format.ToJSON(discovered))
+	expected := map[string][]string{
+		"cinder":   DefaultTopics,
+		"keystone": {"keystone.notifications.info"},
+		"neutron":  {"notifications.info"},
+		"nova":     {"notifications.error", "notifications.info", "versioned_notifications.info"},
+	}
+	if len(discovered) != len(expected) {
+		t.Fatalf("expected %d exchanges, got %d", len(expected), len(discovered))
+	}
+	for _, d := range discovered {
+		topics, ok := expected[d.Exchange.Name]
+		if !ok {
+			t.Fatalf("unexpected exchange %q", d.Exchange.Name)
+		}
+		if !slices.Equal(topics, d.Topics) {
+			t.Fatalf("unexpected topics for %q: %v", d.Exchange.Name, d.Topics)
+		}
+		// only cinder has no notification queue bound
+		if d.Defaulted != (d.Exchange.Name == "cinder") {
+			t.Fatalf("unexpected defaulted flag for %q: %t", d.Exchange.Name, d.Defaulted)
+		}
+		// the queues bound with the topics are reported
+		names := []string{}
+		for _, queue := range d.Queues {
+			names = append(names, queue.Name)
+		}
+		queues := map[string][]string{
+			"nova":    {"notifications.error", "notifications.info"},
+			"neutron": {"notifications.info"},
+		}[d.Exchange.Name]
+		if !slices.Equal(names, queues) {
+			t.Fatalf("unexpected queues for %q: %v", d.Exchange.Name, names)
+		}
+	}
+
+	// all mode includes fanout exchanges, bound with an empty routing key
+	all := Discover(e, b, q, true)
+	if len(all) != 5 {
+		t.Fatalf("expected 5 exchanges in all mode, got %d", len(all))
+	}
+	if fanout := all[4]; fanout.Exchange.Name != "q-agent-notifier-port-update_fanout" || !slices.Equal(fanout.Topics, []string{""}) {
+		t.Fatalf("unexpected fanout exchange: %s", format.ToJSON(fanout))
+	}
+}
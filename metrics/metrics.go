@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Namespace is the prefix of all snoop metrics.
+const Namespace = "snoop"
+
+// Metrics holds the Prometheus metrics exposed by the long-running commands,
+// so that a sniffer that stops receiving or processing messages can be
+// detected and alerted on.
+type Metrics struct {
+	registry *prometheus.Registry
+	// Received counts the messages received, by exchange and routing key.
+	Received *prometheus.CounterVec
+	// Failures counts the messages that failed to be processed, by stage of
+	// the processing chain.
+	Failures *prometheus.CounterVec
+	// Notifications counts the OpenStack notifications, by event type.
+	Notifications *prometheus.CounterVec
+	// Latency measures the time taken to process a message through the chain.
+	Latency prometheus.Histogram
+	// SyslogErrors counts the failures to send events to syslog.
+	SyslogErrors prometheus.Counter
+}
+
+// New creates a new set of metrics, registered on their own registry along
+// with the Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		Received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "messages_received_total",
+			Help:      "The number of messages received, by exchange and routing key.",
+		}, []string{"exchange", "routing_key"}),
+		Failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "failures_total",
+			Help:      "The number of messages that failed to be processed, by stage.",
+		}, []string{"stage"}),
+		Notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "notifications_total",
+			Help:      "The number of OpenStack notifications, by event type.",
+		}, []string{"event_type"}),
+		Latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "chain_latency_seconds",
+			Help:      "The time taken to process a message through the chain.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+		SyslogErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "syslog_errors_total",
+			Help:      "The number of failures to send events to syslog.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.Received,
+		m.Failures,
+		m.Notifications,
+		m.Latency,
+		m.SyslogErrors,
+	)
+	return m
+}
+
+// CounterFunc registers a counter whose value is read from the given
+// function at scrape time, e.g. from counters kept by other packages.
+func (m *Metrics) CounterFunc(name string, help string, value func() int64) {
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      name,
+		Help:      help,
+	}, func() float64 {
+		return float64(value())
+	}))
+}
+
+// CounterVecFunc registers a counter with a single label, whose values are
+// read from the given function at scrape time.
+func (m *Metrics) CounterVecFunc(name string, help string, label string, values func() map[string]int64) {
+	m.registry.MustRegister(&counterVecFunc{
+		desc:   prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", name), help, []string{label}, nil),
+		values: values,
+	})
+}
+
+// counterVecFunc is a collector for a set of counters read from a function.
+type counterVecFunc struct {
+	desc   *prometheus.Desc
+	values func() map[string]int64
+}
+
+// Describe implements prometheus.Collector.
+func (c *counterVecFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *counterVecFunc) Collect(ch chan<- prometheus.Metric) {
+	for label, value := range c.values() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(value), label)
+	}
+}
+
+// Handler returns the HTTP handler that exposes the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Serve exposes the metrics on the /metrics path of the given address (e.g.
+// ":9110") until the context is done; it returns once the server is
+// listening, and the server runs in the background.
+func (m *Metrics) Serve(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		slog.Error("error listening for metrics scrapes", "address", address, "error", err)
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	go func() {
+		slog.Info("serving metrics", "address", listener.Addr().String())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("error serving metrics", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Instrument wraps a stage of the processing chain so that its failures
+// are counted under the given stage name; Drop and Quit are not failures.
+// The returned stage does not change the values or errors.
+func Instrument[S any, T any](m *Metrics, stage string, xform chain.X[S, T]) chain.X[S, T] {
+	failures := m.Failures.WithLabelValues(stage)
+	return func(s S) (T, error) {
+		t, err := xform(s)
+		if err != nil && !errors.Is(err, chain.Drop) && !errors.Is(err, chain.Quit) {
+			failures.Inc()
+		}
+		return t, err
+	}
+}
+
+// Timed wraps the processing chain so that the time taken by each value
+// to flow through it is observed in the latency histogram. The returned
+// chain does not change the values or errors.
+func Timed[S any, T any](m *Metrics, xform chain.X[S, T]) chain.X[S, T] {
+	return func(s S) (T, error) {
+		start := time.Now()
+		t, err := xform(s)
+		m.Latency.Observe(time.Since(start).Seconds())
+		return t, err
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dihedron/snoop/test"
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+func TestScrape(t *testing.T) {
+	test.Setup(t)
+
+	m := New()
+	m.Received.WithLabelValues("nova", "notifications.info").Add(3)
+	m.Notifications.WithLabelValues("compute.instance.create.end").Inc()
+	m.SyslogErrors.Inc()
+	reconnects := int64(2)
+	m.CounterFunc("reconnects_total", "Reconnects.", func() int64 { return reconnects })
+	m.CounterVecFunc("messages_settled_total", "Settled messages.", "outcome", func() map[string]int64 {
+		return map[string]int64{"acked": 5, "rejected": 1}
+	})
+
+	xform := Timed(m, chain.Of2(
+		Instrument(m, "parse", func(i int) (int, error) {
+			if i < 0 {
+				return i, errors.New("negative")
+			}
+			return i, nil
+		}),
+		Instrument(m, "filter", func(i int) (int, error) {
+			if i%2 == 0 {
+				return i, chain.Drop
+			}
+			return i, nil
+		}),
+	))
+	for _, i := range []int{1, 2, -1, 3, -5} {
+		xform(i)
+	}
+
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+	for _, expected := range []string{
+		`snoop_messages_received_total{exchange="nova",routing_key="notifications.info"} 3`,
+		`snoop_notifications_total{event_type="compute.instance.create.end"} 1`,
+		`snoop_failures_total{stage="parse"} 2`,
+		`snoop_chain_latency_seconds_count 5`,
+		`snoop_syslog_errors_total 1`,
+		`snoop_reconnects_total 2`,
+		`snoop_messages_settled_total{outcome="acked"} 5`,
+		`snoop_messages_settled_total{outcome="rejected"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metric %q not found in scrape", expected)
+		}
+	}
+	// dropped values are not failures
+	if !strings.Contains(body, `snoop_failures_total{stage="filter"} 0`) {
+		t.Error("dropped values counted as failures")
+	}
+}
@@ -8,6 +8,15 @@ import (
 	"github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	// HeaderCluster is the header used to tag a delivery with the name of
+	// the RabbitMQ cluster it was consumed from.
+	HeaderCluster = "x-snoop-cluster"
+	// HeaderRegion is the header used to tag a delivery with the OpenStack
+	// region served by the RabbitMQ cluster it was consumed from.
+	HeaderRegion = "x-snoop-region"
+)
+
 // Message is an almost exact replica of amqp.Delivery; it is necessary to
 // be able to introspect the message, be able to print out its contents
 // in multiple formats (YAML, JSON), perform field-by-field comparisons etc.
@@ -54,6 +63,12 @@ type Message struct {
 	RoutingKey string `json:"routingKey,omitempty" yaml:"routingKey,omitempty"` // basic.publish routing key
 	// Body is the actual message body.
 	Body []byte `json:"body,omitempty" yaml:"body,omitempty"`
+	// Cluster is the name of the RabbitMQ cluster the message was consumed
+	// from, when capturing from multiple clusters.
+	Cluster string `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	// Region is the OpenStack region served by the RabbitMQ cluster the
+	// message was consumed from, if known.
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
 	// backref is a reference to the underlying RabbitMQ Delivery
 	backref *amqp091.Delivery
 }
@@ -77,6 +92,25 @@ func (m *Message) Ack(multiple bool) error {
 	return nil
 }
 
+// Source returns the source cluster and region of the message in a format
+// suitable for use as syslog structured data; it returns nil if the message
+// is not tagged.
+func (m *Message) Source() map[string][]string {
+	if m.Cluster == "" && m.Region == "" {
+		return nil
+	}
+	params := []string{}
+	if m.Cluster != "" {
+		params = append(params, "cluster="+m.Cluster)
+	}
+	if m.Region != "" {
+		params = append(params, "region="+m.Region)
+	}
+	return map[string][]string{
+		"source": params,
+	}
+}
+
 // BackRef returns the reference to the original AMQP delivery.
 func (m *Message) BackRef() *amqp091.Delivery {
 	return m.backref
@@ -38,6 +38,12 @@ func DeliveryToMessage(includeBackRef bool) func(*amqp091.Delivery) (*Message, e
 			RoutingKey:      delivery.RoutingKey,
 			Body:            delivery.Body,
 		}
+		if cluster, ok := delivery.Headers[HeaderCluster].(string); ok {
+			message.Cluster = cluster
+		}
+		if region, ok := delivery.Headers[HeaderRegion].(string); ok {
+			message.Region = region
+		}
 		if includeBackRef {
 			slog.Debug("adding back-reference to original AMQP delivery", "reference", delivery.DeliveryTag)
 			message.backref = delivery
@@ -0,0 +1,345 @@
+package diff
+
+import (
+	"cmp"
+	"container/list"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Change is the difference in a single field between two values.
+type Change struct {
+	// Field is the path to the field (e.g. "image_meta.os_distro" or
+	// "fixed_ips[0].address").
+	Field string `json:"field" yaml:"field"`
+	// From is the value of the field before the change, empty if unset.
+	From string `json:"from" yaml:"from"`
+	// To is the value of the field after the change, empty if unset.
+	To string `json:"to" yaml:"to"`
+}
+
+// String returns the change as "field: from → to".
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s → %s", c.Field, quote(c.From), quote(c.To))
+}
+
+// quote makes empty values visible.
+func quote(value string) string {
+	if value == "" {
+		return `""`
+	}
+	return value
+}
+
+// Compare returns the field-level changes between two values, walking
+// into structs, maps, slices and pointers; fields are named after their
+// diff tag, or their JSON name, and those tagged `diff:"-"` are ignored.
+// Unset values and zero values are considered equal. Values of different
+// types are compared through their JSON representation.
+func Compare(from any, to any) []Change {
+	changes := []Change{}
+	compare("", reflect.ValueOf(from), reflect.ValueOf(to), &changes)
+	return changes
+}
+
+// timeType is compared as a single value, not as a struct.
+var timeType = reflect.TypeFor[time.Time]()
+
+// compare appends the changes between the two values, found at the given
+// path, to the list.
+func compare(path string, from reflect.Value, to reflect.Value, changes *[]Change) {
+	from, to = indirect(from), indirect(to)
+	switch {
+	case !from.IsValid() && !to.IsValid():
+		return
+	case !from.IsValid():
+		from = reflect.Zero(to.Type())
+	case !to.IsValid():
+		to = reflect.Zero(from.Type())
+	case from.Type() != to.Type():
+		if composite(from) && composite(to) {
+			if f, t := indirect(generic(from)), indirect(generic(to)); f.IsValid() && t.IsValid() && f.Type() == t.Type() {
+				compare(path, f, t, changes)
+				return
+			}
+		}
+	}
+	if from.Type() == to.Type() && from.Type() != timeType {
+		switch from.Kind() {
+		case reflect.Struct:
+			for i := range from.NumField() {
+				field := from.Type().Field(i)
+				if !field.IsExported() {
+					continue
+				}
+				name := fieldName(field)
+				switch {
+				case name == "-":
+					continue
+				case name == "" && field.Anonymous:
+					compare(path, from.Field(i), to.Field(i), changes)
+				case name == "":
+					compare(join(path, field.Name), from.Field(i), to.Field(i), changes)
+				default:
+					compare(join(path, name), from.Field(i), to.Field(i), changes)
+				}
+			}
+			return
+		case reflect.Map:
+			keys := map[string]reflect.Value{}
+			for _, key := range append(from.MapKeys(), to.MapKeys()...) {
+				keys[fmt.Sprint(key.Interface())] = key
+			}
+			for _, name := range slices.Sorted(maps.Keys(keys)) {
+				compare(join(path, name), from.MapIndex(keys[name]), to.MapIndex(keys[name]), changes)
+			}
+			return
+		case reflect.Slice, reflect.Array:
+			for i := range max(from.Len(), to.Len()) {
+				var f, t reflect.Value
+				if i < from.Len() {
+					f = from.Index(i)
+				}
+				if i < to.Len() {
+					t = to.Index(i)
+				}
+				compare(fmt.Sprintf("%s[%d]", path, i), f, t, changes)
+			}
+			return
+		}
+	}
+	if f, t := text(from), text(to); f != t {
+		*changes = append(*changes, Change{Field: path, From: f, To: t})
+	}
+}
+
+// indirect follows pointers and interfaces down to the actual value, which
+// is invalid if any of them is nil.
+func indirect(value reflect.Value) reflect.Value {
+	for value.IsValid() && (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	return value
+}
+
+// composite returns whether the value has fields or elements.
+func composite(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Struct:
+		return value.Type() != timeType
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// generic returns the value as decoded from its JSON representation, so
+// that values of different types can be compared field by field.
+func generic(value reflect.Value) reflect.Value {
+	data, err := json.Marshal(value.Interface())
+	if err != nil {
+		slog.Warn("error marshalling value for comparison", "error", err)
+		return value
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		slog.Warn("error unmarshalling value for comparison", "error", err)
+		return value
+	}
+	return reflect.ValueOf(v)
+}
+
+// fieldName returns the name of the field in the change list: its diff
+// tag, if any, or its JSON name; it is empty for inlined fields.
+func fieldName(field reflect.StructField) string {
+	if name, ok := field.Tag.Lookup("diff"); ok {
+		return name
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// join appends a field name to a path.
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// text returns the value as a string, empty if it is the zero value.
+func text(value reflect.Value) string {
+	if !value.IsValid() || value.IsZero() {
+		return ""
+	}
+	if value.Type() == timeType {
+		return value.Interface().(time.Time).Format(time.RFC3339Nano)
+	}
+	if composite(value) {
+		data, _ := json.Marshal(value.Interface())
+		return string(data)
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// Payload returns the payload of the notification, which is what changes
+// from one notification to the next about the same resource, or the
+// notification itself if it has none.
+func Payload(n notification.Notification) any {
+	value := indirect(reflect.ValueOf(n))
+	if value.Kind() == reflect.Struct {
+		if payload := value.FieldByName("Payload"); payload.IsValid() {
+			return payload.Interface()
+		}
+	}
+	return n
+}
+
+// Resource returns the kind and ID of the resource the notification is
+// about, for instances, ports and security groups; both are empty for any
+// other notification.
+func Resource(n notification.Notification) (kind string, id string) {
+	switch n := n.(type) {
+	case *notification.ComputeInstance:
+		return "instance", n.Payload.InstanceID
+	case *notification.Port:
+		return "port", cmp.Or(n.Payload.Port.ID, n.Payload.ID)
+	case *notification.SecurityGroup:
+		return "security_group", cmp.Or(n.Payload.SecurityGroup.ID, n.Payload.SecurityGroupID)
+	}
+	return "", ""
+}
+
+// Update is a notification along with the changes in its payload since the
+// previous notification about the same resource; it implements the
+// Notification interface, so it can flow through the same chains.
+type Update struct {
+	// Notification is the notification.
+	Notification notification.Notification `json:"notification" yaml:"notification"`
+	// Kind is the kind of resource the notification is about (e.g.
+	// "instance").
+	Kind string `json:"kind" yaml:"kind"`
+	// ID is the ID of the resource.
+	ID string `json:"id" yaml:"id"`
+	// Changes are the changes since the previous notification about the
+	// resource; they are empty for the first one.
+	Changes []Change `json:"changes" yaml:"changes"`
+	// First is whether there was no previous notification about the
+	// resource.
+	First bool `json:"first,omitempty" yaml:"first,omitempty"`
+}
+
+// Summary returns the summary of the underlying notification.
+func (u *Update) Summary() *notification.Summary {
+	return u.Notification.Summary()
+}
+
+// Tracker remembers the last notification about each resource, so that
+// each new one can be compared with it; resources are forgotten when they
+// are deleted and, if too many are being tracked, those notified least
+// recently are forgotten first, as in the Dedup transformer. It is safe for
+// concurrent use.
+type Tracker struct {
+	lock         sync.Mutex
+	maxResources int
+	recent       *list.List
+	last         map[string]*list.Element
+}
+
+// resource is the last payload notified about a resource.
+type resource struct {
+	key     string
+	payload any
+}
+
+// NewTracker returns a Tracker that remembers no resources yet, and at most
+// maxResources (if greater than 0) at once.
+func NewTracker(maxResources int) *Tracker {
+	return &Tracker{
+		maxResources: maxResources,
+		recent:       list.New(),
+		last:         map[string]*list.Element{},
+	}
+}
+
+// Track compares the notification with the previous one about the same
+// resource and remembers it; it returns nil if the notification is not
+// about a resource.
+func (t *Tracker) Track(n notification.Notification) *Update {
+	kind, id := Resource(n)
+	if id == "" {
+		return nil
+	}
+	payload := Payload(n)
+	key := kind + "/" + id
+	t.lock.Lock()
+	var previous any
+	e, ok := t.last[key]
+	if ok {
+		previous = e.Value.(*resource).payload
+	}
+	switch {
+	case strings.HasSuffix(n.Summary().EventType, ".delete.end"):
+		if ok {
+			delete(t.last, key)
+			t.recent.Remove(e)
+		}
+	case ok:
+		e.Value.(*resource).payload = payload
+		t.recent.MoveToFront(e)
+	default:
+		t.last[key] = t.recent.PushFront(&resource{key: key, payload: payload})
+		for t.maxResources > 0 && t.recent.Len() > t.maxResources {
+			oldest := t.recent.Remove(t.recent.Back()).(*resource)
+			delete(t.last, oldest.key)
+			slog.Debug("too many resources tracked, forgetting the least recent", "resource", oldest.key)
+		}
+	}
+	t.lock.Unlock()
+	update := &Update{
+		Notification: n,
+		Kind:         kind,
+		ID:           id,
+		Changes:      []Change{},
+		First:        !ok,
+	}
+	if ok {
+		update.Changes = Compare(previous, payload)
+	}
+	return update
+}
+
+// Len returns the number of resources being tracked.
+func (t *Tracker) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.last)
+}
+
+// Attach tracks all the notifications flowing into the transformer and
+// replaces those of the given event types (e.g. "compute.instance.update"),
+// or of any type if none is given, with an *Update carrying their changes;
+// the others flow through unchanged.
+func (t *Tracker) Attach(eventTypes ...string) chain.X[notification.Notification, notification.Notification] {
+	return func(n notification.Notification) (notification.Notification, error) {
+		update := t.Track(n)
+		if update == nil || (len(eventTypes) > 0 && !slices.Contains(eventTypes, n.Summary().EventType)) {
+			return n, nil
+		}
+		return update, nil
+	}
+}
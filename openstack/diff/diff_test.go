@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/goccy/go-json"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/test"
+	"github.com/dihedron/snoop/test/fixture"
+)
+
+func TestCompare(t *testing.T) {
+	test.Setup(t)
+
+	from := fixture.Instance("compute.instance.update", "vm-1").Host("cmp-1").State("building").Name("web").Build()
+	to := fixture.Instance("compute.instance.update", "vm-1").Host("cmp-7").State("active").Name("web").Build()
+	from.MessageID = "msg-1"
+	to.Payload.Hostname = "renamed"
+	to.Payload.Metadata = map[string]string{"role": "db"}
+	if err := json.Unmarshal([]byte(`[{"address": "10.0.0.5", "version": 4}]`), &to.Payload.FixedIPs); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := Compare(from.Payload, to.Payload)
+	expected := []string{
+		"hostname: web → renamed",
+		"host: cmp-1 → cmp-7",
+		"state: building → active",
+		"fixed_ips[0].address: \"\" → 10.0.0.5",
+		"fixed_ips[0].version: \"\" → 4",
+		"metadata.role: \"\" → db",
+	}
+	actual := []string{}
+	for _, change := range changes {
+		actual = append(actual, change.String())
+	}
+	if !slices.Equal(actual, expected) {
+		t.Fatalf("unexpected changes: %q", actual)
+	}
+	if changes := Compare(from.Payload, from.Payload); len(changes) != 0 {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+
+	// whole notifications of different types are compared field by field
+	port := &notification.Port{}
+	port.EventType = "port.update.end"
+	port.MessageID = from.MessageID
+	changes = Compare(from, port)
+	if !slices.Contains(changes, Change{Field: "event_type", From: "compute.instance.update", To: "port.update.end"}) || slices.ContainsFunc(changes, func(c Change) bool { return c.Field == "message_id" }) {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	test.Setup(t)
+
+	tracker := NewTracker(0)
+	attach := tracker.Attach("compute.instance.update")
+	notifications := []notification.Notification{
+		fixture.Instance("compute.instance.create.end", "vm-1").Host("cmp-1").State("building").Name("web").Build(),
+		fixture.Instance("compute.instance.update", "vm-1").Host("cmp-1").State("active").Name("web").Build(),
+		fixture.Instance("compute.instance.update", "vm-2").Host("cmp-2").State("active").Name("web").Build(),
+		&notification.Identity{},
+		fixture.Instance("compute.instance.update", "vm-1").Host("cmp-7").State("active").Name("web").Build(),
+		fixture.Instance("compute.instance.delete.end", "vm-1").Host("cmp-7").State("deleted").Name("web").Build(),
+	}
+	outputs := []notification.Notification{}
+	for _, n := range notifications {
+		output, err := attach(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, output)
+	}
+	if outputs[0] != notifications[0] || outputs[3] != notifications[3] || outputs[5] != notifications[5] {
+		t.Fatalf("unexpected updates: %v", outputs)
+	}
+	if update := outputs[1].(*Update); update.First || !slices.Equal(update.Changes, []Change{{Field: "state", From: "building", To: "active"}}) || update.Summary().EventType != "compute.instance.update" {
+		t.Fatalf("unexpected update: %+v", update)
+	}
+	if update := outputs[2].(*Update); !update.First || len(update.Changes) != 0 || update.ID != "vm-2" {
+		t.Fatalf("unexpected update: %+v", update)
+	}
+	if update := outputs[4].(*Update); !slices.Equal(update.Changes, []Change{{Field: "host", From: "cmp-1", To: "cmp-7"}}) {
+		t.Fatalf("unexpected update: %+v", update)
+	}
+	// deleted instances are forgotten
+	if tracker.Len() != 1 {
+		t.Fatalf("unexpected number of tracked resources: %d", tracker.Len())
+	}
+
+	// at most the given number of resources are tracked, forgetting those
+	// notified least recently first
+	tracker = NewTracker(2)
+	for _, id := range []string{"vm-1", "vm-2", "vm-1", "vm-3"} {
+		tracker.Track(fixture.Instance("compute.instance.update", id).Build())
+	}
+	if tracker.Len() != 2 {
+		t.Fatalf("unexpected number of tracked resources: %d", tracker.Len())
+	}
+	if update := tracker.Track(fixture.Instance("compute.instance.update", "vm-2").Build()); !update.First {
+		t.Fatalf("unexpected update: %+v", update)
+	}
+	if update := tracker.Track(fixture.Instance("compute.instance.update", "vm-3").Build()); update.First {
+		t.Fatalf("unexpected update: %+v", update)
+	}
+}
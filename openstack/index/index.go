@@ -0,0 +1,421 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/dihedron/snoop/generator/concat"
+	"github.com/dihedron/snoop/openstack/notification"
+)
+
+// Extension is the extension of the index files, which sit next to the
+// recordings they refer to (e.g. 20220818.amqp.messages.idx).
+const Extension = ".idx"
+
+// Version is the version of the index format; it must be bumped whenever
+// the information in the entries, or the way it is derived from the
+// messages, changes, so that indexes written before are rebuilt:
+//   - 1: entries with user and project;
+//   - 2: instance IDs of compute task and exception notifications.
+const Version = 2
+
+// ErrStale is returned when an index does not match its recording, e.g.
+// because the recording has been truncated or rewritten since, or when it
+// was written with a different version of the format.
+var ErrStale = errors.New("index does not match recording")
+
+// header is the first line of an index file.
+type header struct {
+	// Version is the version of the index format.
+	Version int `json:"version"`
+}
+
+// Entry is the position of a message in a recording, along with the most
+// relevant information about the notification it contains; messages that
+// cannot be decoded are indexed with no information.
+type Entry struct {
+	// Offset is the position of the message in the recording, in bytes.
+	Offset int64 `json:"offset" yaml:"offset"`
+	// Length is the length of the message, in bytes, with no line feed.
+	Length int `json:"length" yaml:"length"`
+	// Timestamp is the time the notification was emitted.
+	Timestamp time.Time `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
+	// EventType is the type of the notification.
+	EventType string `json:"event_type,omitempty" yaml:"event_type,omitempty"`
+	// RequestID is the ID of the request that caused the notification.
+	RequestID string `json:"request_id,omitempty" yaml:"request_id,omitempty"`
+	// InstanceID is the ID of the virtual machine the notification refers
+	// to, if any.
+	InstanceID string `json:"instance_id,omitempty" yaml:"instance_id,omitempty"`
+	// User is the name (or ID) of the user that caused the notification.
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// Project is the name (or ID) of the project the notification refers
+	// to.
+	Project string `json:"project,omitempty" yaml:"project,omitempty"`
+}
+
+// End returns the position right after the message (and its line feed).
+func (e Entry) End() int64 {
+	return e.Offset + int64(e.Length) + 1
+}
+
+// Index maps the messages in a recording to their position, so that they
+// can be selected and read without scanning the whole recording.
+type Index struct {
+	// Recording is the path of the recording.
+	Recording string
+	// Entries are the messages in the recording, in order.
+	Entries []Entry
+}
+
+// Path returns the path of the index of the given recording.
+func Path(recording string) string {
+	return recording + Extension
+}
+
+// Exists returns whether the given recording has an index.
+func Exists(recording string) bool {
+	_, err := os.Stat(Path(recording))
+	return err == nil
+}
+
+// Build scans the given recording and indexes all its messages.
+func Build(ctx context.Context, recording string) (*Index, error) {
+	index := &Index{
+		Recording: recording,
+	}
+	if err := index.Update(ctx); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Load reads the index of the given recording; if the recording has grown
+// since the index was written, the new messages are indexed too, whereas if
+// it no longer matches the index, or the index was written with a different
+// version of the format, ErrStale is returned.
+func Load(ctx context.Context, recording string) (*Index, error) {
+	file, err := os.Open(Path(recording))
+	if err != nil {
+		slog.Error("error opening index", "path", Path(recording), "error", err)
+		return nil, err
+	}
+	defer file.Close()
+	index := &Index{
+		Recording: recording,
+	}
+	decoder := json.NewDecoder(file)
+	// indexes written before the format was versioned start with an entry,
+	// and are read as version 0
+	var h header
+	if err := decoder.Decode(&h); err != nil && err != io.EOF {
+		slog.Error("error reading index header", "path", Path(recording), "error", err)
+		return nil, err
+	}
+	if h.Version != Version {
+		slog.Warn("index written with a different version of the format", "path", Path(recording), "version", h.Version, "expected", Version)
+		return nil, fmt.Errorf("%w: %s has version %d, expected %d", ErrStale, Path(recording), h.Version, Version)
+	}
+	for {
+		var entry Entry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			slog.Error("error reading index entry", "path", Path(recording), "error", err)
+			return nil, err
+		}
+		index.Entries = append(index.Entries, entry)
+	}
+	info, err := os.Stat(recording)
+	if err != nil {
+		slog.Error("error reading recording info", "path", recording, "error", err)
+		return nil, err
+	}
+	if index.End() > info.Size() {
+		slog.Error("recording is shorter than its index", "path", recording, "size", info.Size(), "indexed", index.End())
+		return nil, fmt.Errorf("%w: %s", ErrStale, recording)
+	}
+	if index.End() < info.Size() {
+		slog.Debug("indexing messages added to recording", "path", recording, "from", index.End())
+		if err := index.Update(ctx); err != nil {
+			return nil, err
+		}
+	}
+	slog.Debug("index loaded", "path", Path(recording), "entries", len(index.Entries))
+	return index, nil
+}
+
+// Open loads the index of the given recording, or builds it if it does not
+// exist or is stale.
+func Open(ctx context.Context, recording string) (*Index, error) {
+	if Exists(recording) {
+		index, err := Load(ctx, recording)
+		if err == nil {
+			return index, nil
+		}
+		slog.Warn("rebuilding index", "path", Path(recording), "error", err)
+	}
+	return Build(ctx, recording)
+}
+
+// End returns the position in the recording up to which it is indexed.
+func (i *Index) End() int64 {
+	if len(i.Entries) == 0 {
+		return 0
+	}
+	return i.Entries[len(i.Entries)-1].End()
+}
+
+// Update indexes the messages in the recording after the last one in the
+// index, e.g. those appended since it was built.
+func (i *Index) Update(ctx context.Context) error {
+	file, err := os.Open(i.Recording)
+	if err != nil {
+		slog.Error("error opening recording", "path", i.Recording, "error", err)
+		return err
+	}
+	defer file.Close()
+	offset := i.End()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		slog.Error("error seeking in recording", "path", i.Recording, "offset", offset, "error", err)
+		return err
+	}
+	reader := bufio.NewReaderSize(file, 1024*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a partial line is still being written
+			break
+		} else if err != nil {
+			slog.Error("error reading recording", "path", i.Recording, "offset", offset, "error", err)
+			return err
+		}
+		entry := Describe(bytes.TrimSuffix(line, []byte{'\n'}))
+		entry.Offset = offset
+		i.Entries = append(i.Entries, entry)
+		offset += int64(len(line))
+	}
+	slog.Debug("recording indexed", "path", i.Recording, "entries", len(i.Entries))
+	return nil
+}
+
+// Save writes the index next to its recording; the file is written to a
+// temporary file and renamed, so that it is never left half-written.
+func (i *Index) Save() error {
+	path := Path(i.Recording)
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		slog.Error("error creating temporary index file", "path", path, "error", err)
+		return err
+	}
+	defer os.Remove(temp.Name())
+	writer := bufio.NewWriter(temp)
+	encoder := json.NewEncoder(writer)
+	if err := encoder.Encode(header{Version: Version}); err != nil {
+		temp.Close()
+		slog.Error("error writing index header", "path", path, "error", err)
+		return err
+	}
+	for _, entry := range i.Entries {
+		if err := encoder.Encode(entry); err != nil {
+			temp.Close()
+			slog.Error("error writing index entry", "path", path, "error", err)
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		slog.Error("error writing index", "path", path, "error", err)
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		slog.Error("error closing temporary index file", "path", temp.Name(), "error", err)
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		slog.Error("error renaming temporary index file", "from", temp.Name(), "to", path, "error", err)
+		return err
+	}
+	slog.Debug("index saved", "path", path, "entries", len(i.Entries))
+	return nil
+}
+
+// Select returns the entries for which the given condition is true, in the
+// order they appear in the recording.
+func (i *Index) Select(condition func(entry Entry) bool) []Entry {
+	entries := []Entry{}
+	for _, entry := range i.Entries {
+		if condition(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Lines returns the messages at the given entries, read straight from the
+// recording; it stops when the context is done, and logs and skips the
+// messages that cannot be read.
+func (i *Index) Lines(ctx context.Context, entries []Entry) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		file, err := os.Open(i.Recording)
+		if err != nil {
+			slog.Error("error opening recording", "path", i.Recording, "error", err)
+			return
+		}
+		defer file.Close()
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+			buffer := make([]byte, entry.Length)
+			if _, err := file.ReadAt(buffer, entry.Offset); err != nil {
+				slog.Error("error reading message from recording", "path", i.Recording, "offset", entry.Offset, "error", err)
+				continue
+			}
+			if !yield(string(buffer)) {
+				return
+			}
+		}
+	}
+}
+
+// Matching returns the messages in the given recordings whose entries
+// satisfy the condition, read through the recordings' indexes, which are
+// rebuilt and saved if stale; it returns false if any of the recordings is
+// not indexed or its index cannot be loaded, in which case the recordings
+// have to be scanned.
+func Matching(ctx context.Context, recordings []string, condition func(entry Entry) bool) (iter.Seq[string], bool) {
+	selected := []iter.Seq[string]{}
+	for _, recording := range recordings {
+		if !Exists(recording) {
+			slog.Debug("recording not indexed", "path", recording)
+			return nil, false
+		}
+		i, err := Load(ctx, recording)
+		if errors.Is(err, ErrStale) {
+			slog.Warn("rebuilding index", "path", Path(recording), "error", err)
+			if i, err = Build(ctx, recording); err == nil {
+				err = i.Save()
+			}
+		}
+		if err != nil {
+			slog.Warn("error loading index", "path", recording, "error", err)
+			return nil, false
+		}
+		entries := i.Select(condition)
+		slog.Debug("messages selected through index", "path", recording, "selected", len(entries), "total", len(i.Entries))
+		selected = append(selected, i.Lines(ctx, entries))
+	}
+	return concat.Concat(selected...), true
+}
+
+// Describe returns an entry with the information about the notification in
+// the given recorded message, and its length; the offset is not set.
+func Describe(line []byte) Entry {
+	entry := Entry{
+		Length: len(line),
+	}
+	n, err := notification.Decode()(string(line))
+	if err != nil {
+		slog.Debug("message cannot be decoded, indexing position only", "error", err)
+		return entry
+	}
+	summary := n.Summary()
+	entry.Timestamp = summary.Timestamp
+	entry.EventType = summary.EventType
+	entry.RequestID = summary.RequestID
+	entry.InstanceID = notification.InstanceID(n)
+	entry.User = cmp.Or(summary.UserName, summary.UserID)
+	entry.Project = cmp.Or(summary.ProjectName, summary.ProjectID)
+	return entry
+}
+
+// Writer keeps the index of a recording up to date while messages are
+// appended to it. The offsets of the messages are derived by adding up
+// their lengths, so Add must be called right after each message is
+// appended, in the same order and with no other writes to the recording in
+// between: in practice, both must happen on the same goroutine (e.g. the
+// chain.Run sink) or under the same lock.
+type Writer struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	offset int64
+}
+
+// NewWriter returns a Writer for the index of the given recording, which
+// must be fully written; the messages already in the recording are indexed
+// first, if they are not yet.
+func NewWriter(ctx context.Context, recording string) (*Writer, error) {
+	index, err := Open(ctx, recording)
+	if err != nil {
+		return nil, err
+	}
+	if err := index.Save(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(Path(recording), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("error opening index for writing", "path", Path(recording), "error", err)
+		return nil, err
+	}
+	return &Writer{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		offset: index.End(),
+	}, nil
+}
+
+// Add indexes the given message, which has just been appended (with a line
+// feed) to the recording; see Writer for the ordering requirements.
+func (w *Writer) Add(line []byte) error {
+	return w.Append(Describe(line))
+}
+
+// Append indexes the message described by the given entry, as returned by
+// Describe, when the message has already been described for other purposes;
+// see Writer for the ordering requirements.
+func (w *Writer) Append(entry Entry) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	entry.Offset = w.offset
+	w.offset = entry.End()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("error marshalling index entry", "error", err)
+		return err
+	}
+	if _, err := w.writer.Write(append(data, '\n')); err != nil {
+		slog.Error("error writing index entry", "error", err)
+		return err
+	}
+	return nil
+}
+
+// Close flushes the index and closes it.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.writer.Flush(); err != nil {
+		slog.Error("error flushing index", "error", err)
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,155 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/dihedron/snoop/openstack/amqp"
+	"github.com/dihedron/snoop/test"
+)
+
+// message returns a recorded message containing a compute instance
+// notification.
+func message(t *testing.T, eventType string, timestamp string, requestID string, instanceID string) string {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"message_id":          fmt.Sprintf("%s-%s", eventType, timestamp),
+		"event_type":          eventType,
+		"publisher_id":        "compute.compute-01",
+		"timestamp":           timestamp,
+		"_context_request_id": requestID,
+		"_context_user_name":  "admin",
+		"_context_project_id": "p-123",
+		"payload": map[string]any{
+			"instance_id": instanceID,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]string{
+		"oslo.version": "2.0",
+		"oslo.message": string(payload),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&amqp.Message{Exchange: "nova", Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestIndex(t *testing.T) {
+	test.Setup(t)
+	ctx := context.Background()
+
+	recording := filepath.Join(t.TempDir(), "test.amqp.messages")
+	lines := []string{
+		message(t, "compute.instance.create.start", "2024-05-21 14:00:00.000000", "req-abc", "vm-1"),
+		"not a message",
+		message(t, "compute.instance.create.end", "2024-05-21 14:03:00.000000", "req-abc", "vm-1"),
+		message(t, "compute.instance.delete.start", "2024-05-21 14:06:00.000000", "req-def", "vm-2"),
+	}
+	if err := os.WriteFile(recording, []byte(lines[0]+"\n"+lines[1]+"\n"+lines[2]+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := Open(ctx, recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Entries) != 3 || index.Entries[1].EventType != "" || index.Entries[2].InstanceID != "vm-1" || index.Entries[2].User != "admin" || index.Entries[2].Project != "p-123" {
+		t.Fatalf("unexpected entries: %+v", index.Entries)
+	}
+	if err := index.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// messages appended through the writer are indexed as they are written
+	writer, err := NewWriter(ctx, recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(recording, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(lines[3] + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if err := writer.Add([]byte(lines[3])); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err = Load(ctx, recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Date(2024, 5, 21, 14, 2, 0, 0, time.UTC)
+	entries := index.Select(func(e Entry) bool {
+		return e.RequestID == "req-abc" || e.Timestamp.After(after)
+	})
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if selected := slices.Collect(index.Lines(ctx, entries)); !slices.Equal(selected, []string{lines[0], lines[2], lines[3]}) {
+		t.Fatalf("unexpected lines: %v", selected)
+	}
+
+	// messages appended behind the index's back are indexed on load, while
+	// a rewritten recording makes the index stale
+	file, err = os.OpenFile(recording, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(lines[0] + "\n")
+	file.Close()
+	if index, err = Load(ctx, recording); err != nil || len(index.Entries) != 5 || index.Entries[4].EventType != "compute.instance.create.start" {
+		t.Fatalf("unexpected index: %v (error %v)", index, err)
+	}
+	if err := os.WriteFile(recording, []byte(lines[0]+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(ctx, recording); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected stale index, got %v", err)
+	}
+	if index, err = Open(ctx, recording); err != nil || len(index.Entries) != 1 {
+		t.Fatalf("unexpected index: %v (error %v)", index, err)
+	}
+
+	// indexes written with another version of the format are stale, and
+	// they are rebuilt when messages are selected through them
+	data, err := json.Marshal(Entry{Length: len(lines[0])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(Path(recording), append(data, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(ctx, recording); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected stale index, got %v", err)
+	}
+	matching, ok := Matching(ctx, []string{recording}, func(e Entry) bool {
+		return e.InstanceID == "vm-1"
+	})
+	if !ok || !slices.Equal(slices.Collect(matching), []string{lines[0]}) {
+		t.Fatal("unexpected messages selected through rebuilt index")
+	}
+	if _, err := Load(ctx, recording); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,361 @@
+package latency
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/openstack/stats"
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Operation is an operation reported through a pair of notifications, from
+// its .start notification to its .end (or .error) one.
+type Operation struct {
+	// Name is the event type of the operation, without the stage (e.g.
+	// "compute.instance.create").
+	Name string `json:"name" yaml:"name"`
+	// RequestID is the ID of the request that caused the operation.
+	RequestID string `json:"request_id,omitempty" yaml:"request_id,omitempty"`
+	// InstanceID is the ID of the virtual machine the operation was
+	// performed on, if any.
+	InstanceID string `json:"instance_id,omitempty" yaml:"instance_id,omitempty"`
+	// Host is the host the operation was performed on, if known.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Zone is the availability zone the operation was performed in, if
+	// known.
+	Zone string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	// Start is the time the operation started.
+	Start time.Time `json:"start" yaml:"start"`
+	// End is the time the operation ended, zero if it has not yet.
+	End time.Time `json:"end,omitempty" yaml:"end,omitempty"`
+	// Duration is the time the operation took.
+	Duration time.Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// Failed is whether the operation ended with an error.
+	Failed bool `json:"failed,omitempty" yaml:"failed,omitempty"`
+}
+
+// key identifies the pair of notifications of an operation.
+type key struct {
+	name       string
+	requestID  string
+	instanceID string
+}
+
+// Pairer matches the .start and .end (or .error) notifications of the
+// operations flowing through the chain, by operation, request ID and
+// instance ID; the notifications may flow in any order (e.g. from several
+// recordings), as those that cannot be matched yet are kept until their
+// counterpart shows up. It is safe for concurrent use.
+type Pairer struct {
+	lock sync.Mutex
+	// starts are the operations that started and have not been matched to
+	// an end yet.
+	starts map[key][]*Operation
+	// ends are the operations that ended and have not been matched to a
+	// start yet; only their end is known.
+	ends map[key][]*Operation
+}
+
+// NewPairer returns a Pairer with no pending operations.
+func NewPairer() *Pairer {
+	return &Pairer{
+		starts: map[key][]*Operation{},
+		ends:   map[key][]*Operation{},
+	}
+}
+
+// Pair turns each .end (and .error) notification flowing into the
+// transformer, together with the .start one of the same operation, into the
+// operation they delimit, as soon as both have flowed through: each end is
+// matched to the earliest pending start before it, and each start to the
+// earliest pending end after it. All other notifications, and those not
+// matched yet, are dropped.
+func (p *Pairer) Pair() chain.X[notification.Notification, *Operation] {
+	return func(n notification.Notification) (*Operation, error) {
+		summary := n.Summary()
+		name, stage, ok := Stage(summary.EventType)
+		if !ok {
+			return nil, chain.Drop
+		}
+		k := key{name: name, requestID: summary.RequestID, instanceID: notification.InstanceID(n)}
+		if k.requestID == "" && k.instanceID == "" {
+			return nil, chain.Drop
+		}
+		host, zone := stats.Host(n), ""
+		if i, ok := n.(*notification.ComputeInstance); ok {
+			zone = i.Payload.AvailabilityZone
+		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if stage == "start" {
+			start := &Operation{
+				Name:       name,
+				RequestID:  k.requestID,
+				InstanceID: k.instanceID,
+				Host:       host,
+				Zone:       zone,
+				Start:      summary.Timestamp,
+			}
+			i := slices.IndexFunc(p.ends[k], func(end *Operation) bool {
+				return !end.End.Before(start.Start)
+			})
+			if i < 0 {
+				p.starts[k] = insert(p.starts[k], start, func(o *Operation) time.Time { return o.Start })
+				return nil, chain.Drop
+			}
+			end := p.ends[k][i]
+			remove(p.ends, k, i)
+			return complete(start, end), nil
+		}
+		end := &Operation{
+			Host:   host,
+			Zone:   zone,
+			End:    summary.Timestamp,
+			Failed: stage == "error",
+		}
+		starts := p.starts[k]
+		if len(starts) == 0 || starts[0].Start.After(end.End) {
+			p.ends[k] = insert(p.ends[k], end, func(o *Operation) time.Time { return o.End })
+			return nil, chain.Drop
+		}
+		start := starts[0]
+		remove(p.starts, k, 0)
+		return complete(start, end), nil
+	}
+}
+
+// insert adds the operation to those sorted by the given time, after those
+// with the same time.
+func insert(operations []*Operation, operation *Operation, at func(*Operation) time.Time) []*Operation {
+	i, _ := slices.BinarySearchFunc(operations, at(operation), func(o *Operation, t time.Time) int {
+		if at(o).After(t) {
+			return 1
+		}
+		return -1
+	})
+	return slices.Insert(operations, i, operation)
+}
+
+// remove removes the i-th pending operation under the given key.
+func remove(pending map[key][]*Operation, k key, i int) {
+	if operations := slices.Delete(pending[k], i, i+1); len(operations) > 0 {
+		pending[k] = operations
+	} else {
+		delete(pending, k)
+	}
+}
+
+// complete fills in the start with its end.
+func complete(start *Operation, end *Operation) *Operation {
+	start.End = end.End
+	start.Duration = start.End.Sub(start.Start)
+	start.Failed = end.Failed
+	// ends know where the operation ended up (e.g. the host an instance was
+	// spawned on)
+	start.Host = cmp.Or(end.Host, start.Host)
+	start.Zone = cmp.Or(end.Zone, start.Zone)
+	return start
+}
+
+// Orphans returns the operations that started but never ended, ordered by
+// start time.
+func (p *Pairer) Orphans() []Operation {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	orphans := []Operation{}
+	for _, operations := range p.starts {
+		for _, operation := range operations {
+			orphans = append(orphans, *operation)
+		}
+	}
+	slices.SortFunc(orphans, func(a, b Operation) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(a.Name, b.Name), cmp.Compare(a.RequestID, b.RequestID))
+	})
+	return orphans
+}
+
+// Unmatched returns the number of .end and .error notifications that had no
+// matching .start notification.
+func (p *Pairer) Unmatched() int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var unmatched int64
+	for _, operations := range p.ends {
+		unmatched += int64(len(operations))
+	}
+	return unmatched
+}
+
+// Stage splits an event type into the operation and its stage ("start",
+// "end" or "error"); it returns false if the event type has no stage.
+func Stage(eventType string) (name string, stage string, ok bool) {
+	for _, stage := range []string{"start", "end", "error"} {
+		if name, ok := strings.CutSuffix(eventType, "."+stage); ok && name != "" {
+			return name, stage, true
+		}
+	}
+	return "", "", false
+}
+
+// Latency is the distribution of the durations of an operation.
+type Latency struct {
+	// Operation is the operation (e.g. "compute.instance.create").
+	Operation string `json:"operation" yaml:"operation"`
+	// Key is the host or availability zone, empty for the overall
+	// distribution.
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Count is the number of operations.
+	Count int64 `json:"count" yaml:"count"`
+	// Failed is the number of operations that ended with an error.
+	Failed int64 `json:"failed" yaml:"failed"`
+	// P50 is the median duration.
+	P50 time.Duration `json:"p50" yaml:"p50"`
+	// P95 is the 95th percentile of the durations.
+	P95 time.Duration `json:"p95" yaml:"p95"`
+	// P99 is the 99th percentile of the durations.
+	P99 time.Duration `json:"p99" yaml:"p99"`
+	// Max is the longest duration.
+	Max time.Duration `json:"max" yaml:"max"`
+}
+
+// Report is the summary of the durations of a set of operations.
+type Report struct {
+	// Operations are the distributions by operation.
+	Operations []Latency `json:"operations" yaml:"operations"`
+	// Hosts are the distributions by operation and host, slowest first.
+	Hosts []Latency `json:"hosts" yaml:"hosts"`
+	// Zones are the distributions by operation and availability zone,
+	// slowest first.
+	Zones []Latency `json:"zones" yaml:"zones"`
+	// Orphans are the operations that started but never ended.
+	Orphans []Operation `json:"orphans" yaml:"orphans"`
+	// Unmatched is the number of operations that ended with no start.
+	Unmatched int64 `json:"unmatched" yaml:"unmatched"`
+}
+
+// samples are the durations of a set of operations.
+type samples struct {
+	durations []time.Duration
+	failed    int64
+}
+
+// dimension is the key of a set of samples.
+type dimension struct {
+	operation string
+	key       string
+}
+
+// Collector gathers the durations of the operations flowing through a
+// chain, by operation, host and availability zone. It is safe for
+// concurrent use.
+type Collector struct {
+	lock       sync.Mutex
+	operations map[dimension]*samples
+	hosts      map[dimension]*samples
+	zones      map[dimension]*samples
+}
+
+// New returns a new, empty Collector.
+func New() *Collector {
+	return &Collector{
+		operations: map[dimension]*samples{},
+		hosts:      map[dimension]*samples{},
+		zones:      map[dimension]*samples{},
+	}
+}
+
+// Add adds the operation flowing into the transformer to the statistics.
+// This filter does not affect the value flowing through.
+func (c *Collector) Add() chain.F[*Operation] {
+	return func(operation *Operation) (*Operation, error) {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		add(c.operations, dimension{operation: operation.Name}, operation)
+		add(c.hosts, dimension{operation: operation.Name, key: cmp.Or(operation.Host, "unknown")}, operation)
+		add(c.zones, dimension{operation: operation.Name, key: cmp.Or(operation.Zone, "unknown")}, operation)
+		return operation, nil
+	}
+}
+
+// add adds the operation to the samples of the given dimension.
+func add(m map[dimension]*samples, d dimension, operation *Operation) {
+	s, ok := m[d]
+	if !ok {
+		s = &samples{}
+		m[d] = s
+	}
+	s.durations = append(s.durations, operation.Duration)
+	if operation.Failed {
+		s.failed++
+	}
+}
+
+// Report returns the distributions of the durations gathered so far, along
+// with the given pairer's orphans and unmatched ends, if any; the hosts and
+// zones of each operation are sorted by decreasing 95th percentile and, if
+// top is greater than 0, truncated to the top (i.e. slowest) entries.
+func (c *Collector) Report(pairer *Pairer, top int) *Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	report := &Report{
+		Operations: latencies(c.operations, 0),
+		Hosts:      latencies(c.hosts, top),
+		Zones:      latencies(c.zones, top),
+		Orphans:    []Operation{},
+	}
+	if pairer != nil {
+		report.Orphans = pairer.Orphans()
+		report.Unmatched = pairer.Unmatched()
+	}
+	return report
+}
+
+// latencies returns the distributions of the given samples, by operation
+// and then by decreasing 95th percentile; if top is greater than 0, only
+// the first top of each operation are returned.
+func latencies(m map[dimension]*samples, top int) []Latency {
+	result := make([]Latency, 0, len(m))
+	for _, d := range slices.SortedFunc(maps.Keys(m), func(a, b dimension) int {
+		return cmp.Or(cmp.Compare(a.operation, b.operation), cmp.Compare(a.key, b.key))
+	}) {
+		s := m[d]
+		durations := slices.Sorted(slices.Values(s.durations))
+		result = append(result, Latency{
+			Operation: d.operation,
+			Key:       d.key,
+			Count:     int64(len(durations)),
+			Failed:    s.failed,
+			P50:       Percentile(durations, 50),
+			P95:       Percentile(durations, 95),
+			P99:       Percentile(durations, 99),
+			Max:       durations[len(durations)-1],
+		})
+	}
+	slices.SortStableFunc(result, func(a, b Latency) int {
+		return cmp.Or(cmp.Compare(a.Operation, b.Operation), cmp.Compare(b.P95, a.P95))
+	})
+	if top > 0 {
+		counts := map[string]int{}
+		result = slices.DeleteFunc(result, func(latency Latency) bool {
+			counts[latency.Operation]++
+			return counts[latency.Operation] > top
+		})
+	}
+	return result
+}
+
+// Percentile returns the given percentile of the sorted durations, using
+// the nearest-rank method; it returns 0 if there are none.
+func Percentile(sorted []time.Duration, percentile float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
@@ -0,0 +1,78 @@
+package latency
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/test"
+	"github.com/dihedron/snoop/test/fixture"
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+func TestLatency(t *testing.T) {
+	test.Setup(t)
+
+	pairer := NewPairer()
+	collector := New()
+	xform := chain.Of2(pairer.Pair(), collector.Add())
+	notifications := []notification.Notification{
+		fixture.Instance("compute.instance.create.start", "vm-1").At(0).Request("req-1").Host("").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.create.start", "vm-2").At(1).Request("req-2").Host("").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.create.start", "vm-3").At(2).Request("req-3").Host("").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.exists", "vm-1").At(3).Request("").Host("cmp-1").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.create.end", "vm-1").At(10).Request("req-1").Host("cmp-1").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.create.end", "vm-2").At(31).Request("req-2").Host("cmp-2").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.create.error", "vm-3").At(40).Request("req-3").Host("cmp-2").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.reboot.start", "vm-1").At(50).Request("req-4").Host("cmp-1").Zone("az-1").Build(),
+		fixture.Instance("compute.instance.delete.end", "vm-2").At(55).Request("req-5").Host("cmp-2").Zone("az-1").Build(),
+	}
+	operations := []*Operation{}
+	for _, n := range notifications {
+		operation, err := xform(n)
+		if errors.Is(err, chain.Drop) {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		operations = append(operations, operation)
+	}
+	if len(operations) != 3 || operations[0].Duration != 10*time.Second || operations[0].Host != "cmp-1" || !operations[2].Failed {
+		t.Fatalf("unexpected operations: %+v", operations)
+	}
+
+	report := collector.Report(pairer, 1)
+	if !slices.Equal(report.Operations, []Latency{{Operation: "compute.instance.create", Count: 3, Failed: 1, P50: 30 * time.Second, P95: 38 * time.Second, P99: 38 * time.Second, Max: 38 * time.Second}}) {
+		t.Fatalf("unexpected operations: %+v", report.Operations)
+	}
+	// only the slowest host is reported
+	if len(report.Hosts) != 1 || report.Hosts[0].Key != "cmp-2" || report.Hosts[0].Count != 2 || report.Zones[0].Key != "az-1" {
+		t.Fatalf("unexpected hosts and zones: %+v %+v", report.Hosts, report.Zones)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Name != "compute.instance.reboot" || report.Orphans[0].RequestID != "req-4" || report.Unmatched != 1 {
+		t.Fatalf("unexpected orphans: %+v (unmatched: %d)", report.Orphans, report.Unmatched)
+	}
+
+	// notifications from several recordings are not in chronological order,
+	// so ends may flow before their starts
+	pairer, collector = NewPairer(), New()
+	xform = chain.Of2(pairer.Pair(), collector.Add())
+	for _, n := range slices.Backward(notifications) {
+		if _, err := xform(n); err != nil && !errors.Is(err, chain.Drop) {
+			t.Fatal(err)
+		}
+	}
+	if reversed := collector.Report(pairer, 1); !slices.Equal(reversed.Operations, report.Operations) || len(reversed.Orphans) != 1 || reversed.Unmatched != 1 {
+		t.Fatalf("unexpected report of reversed notifications: %+v", reversed)
+	}
+
+	durations := []time.Duration{}
+	for i := range 100 {
+		durations = append(durations, time.Duration(i+1)*time.Second)
+	}
+	if Percentile(durations, 50) != 50*time.Second || Percentile(durations, 99) != 99*time.Second || Percentile(nil, 50) != 0 {
+		t.Fatal("unexpected percentiles")
+	}
+}
@@ -2,6 +2,7 @@ package notification
 
 import (
 	"log/slog"
+	"time"
 
 	"github.com/goccy/go-json"
 
@@ -45,12 +46,15 @@ func ToJSON[N Notification](notification N, pretty bool) string {
 // in an OpenStack Notification.
 type Summary struct {
 	EventType       string
+	PublisherID     string
 	UserID          string
 	UserName        string
 	ProjectID       string
 	ProjectName     string
 	RequestID       string
 	GlobalRequestID string
+	ResourceID      string
+	Timestamp       time.Time
 }
 
 // Commons is the base set of information contained in all
@@ -91,6 +95,8 @@ type Base struct {
 	ContextProjectName     string           `json:"_context_project_name,omitempty" yaml:"_context_project_name,omitempty"`
 	ContextUserName        string           `json:"_context_user_name,omitempty" yaml:"_context_user_name,omitempty"`
 	ContextServiceCatalog  []ServiceCatalog `json:"_context_service_catalog,omitempty" yaml:"_context_service_catalog,omitempty"`
+	// source is where the notification comes from (see amqp.Message.Source).
+	source map[string][]string
 	// backref is a reference to the underlying RabbitMQ delivery
 	backref *amqp091.Delivery
 }
@@ -113,15 +119,91 @@ type Endpoint struct {
 func (b *Base) Summary() *Summary {
 	return &Summary{
 		EventType:       b.EventType,
+		PublisherID:     b.PublisherID,
 		UserID:          b.ContextUserID,
 		UserName:        b.ContextUserName,
 		ProjectID:       b.ContextProjectID,
 		ProjectName:     b.ContextProjectName,
 		RequestID:       b.ContextRequestID,
 		GlobalRequestID: b.ContextGlobalRequestID,
+		ResourceID:      b.ContextResourceUUID,
+		Timestamp:       ParseTimestamp(b.Timestamp),
 	}
 }
 
+// timestampLayouts are the formats in which OpenStack services write
+// notification timestamps; oslo.messaging uses the first one, in UTC.
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02T15:04:05.999999",
+	time.RFC3339Nano,
+}
+
+// ParseTimestamp parses a notification timestamp, which is assumed to be
+// in UTC unless it specifies a time zone; it returns the zero time if the
+// timestamp is empty or cannot be parsed.
+func ParseTimestamp(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t
+		}
+	}
+	slog.Debug("unsupported timestamp format", "timestamp", value)
+	return time.Time{}
+}
+
+// ID returns the identifier of the notification, which is the same across
+// redeliveries and across multiple bindings: the oslo message ID, or the
+// unique ID if the former is not available.
+func (b *Base) ID() string {
+	if b.MessageID != "" {
+		return b.MessageID
+	}
+	return b.UniqueID
+}
+
+// InstanceID returns the ID of the virtual machine a notification refers to:
+// that in the payload for compute instance, compute task and exception
+// notifications, otherwise the resource UUID in the context, which Nova sets
+// to the instance. Scheduler notifications carry the legacy request spec,
+// which only has the UUID of the first instance when several are created
+// at once. The index stores the result, so changes here require bumping
+// index.Version.
+func InstanceID(n Notification) string {
+	switch n := n.(type) {
+	case *ComputeInstance:
+		if n.Payload.InstanceID != "" {
+			return n.Payload.InstanceID
+		}
+	case *ComputeTask:
+		for _, id := range []string{n.Payload.InstanceID, n.Payload.InstanceProperties.UUID, n.Payload.RequestSpec.InstanceProperties.UUID} {
+			if id != "" {
+				return id
+			}
+		}
+	case *Exception:
+		if n.Payload.Args.Instance.UUID != "" {
+			return n.Payload.Args.Instance.UUID
+		}
+	}
+	return n.Summary().ResourceID
+}
+
+// Source returns the source cluster and region of the AMQP message the
+// notification was extracted from, as syslog structured data; it returns nil
+// if the message is not tagged.
+func (b *Base) Source() map[string][]string {
+	return b.source
+}
+
+// SetSource sets the source of the notification.
+func (b *Base) SetSource(source map[string][]string) {
+	b.source = source
+}
+
 func (b *Base) SetBackRef(delivery *amqp091.Delivery) {
 	b.backref = delivery
 }
@@ -0,0 +1,76 @@
+package notification
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	"github.com/dihedron/snoop/test"
+	"github.com/dihedron/snoop/transform/transformers"
+)
+
+func TestInstanceID(t *testing.T) {
+	test.Setup(t)
+
+	// the payloads are shaped as Nova emits them: the scheduler sends the
+	// legacy request spec (RequestSpec.to_legacy_request_spec_dict), whose
+	// instance properties carry the instance UUID, while exceptions carry
+	// the arguments of the failed call, including the instance
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "scheduler",
+			input: `{"event_type": "scheduler.select_destinations.start", "publisher_id": "scheduler.sched-01", "timestamp": "2024-05-21 14:00:01.000000",
+				"_context_request_id": "req-1", "_context_resource_uuid": null,
+				"payload": {"request_spec": {"num_instances": 1,
+					"image": {"id": "img-1", "name": "cirros", "status": "active", "min_ram": 0, "min_disk": 0, "properties": {}},
+					"instance_properties": {"numa_topology": null, "pci_requests": {"requests": []}, "project_id": "p-1", "user_id": "u-1",
+						"availability_zone": null, "uuid": "vm-1", "root_gb": 1, "ephemeral_gb": 0, "memory_mb": 512, "vcpus": 1},
+					"instance_type": {"id": 1, "name": "m1.tiny", "memory_mb": 512, "vcpus": 1, "root_gb": 1, "ephemeral_gb": 0, "flavorid": "1", "swap": 0, "rxtx_factor": 1.0, "vcpu_weight": 0, "disabled": false, "is_public": true, "extra_specs": {}}}}}`,
+			expected: "vm-1",
+		},
+		{
+			name: "exception",
+			input: `{"event_type": "resize_instance", "publisher_id": "compute.cmp-1", "timestamp": "2024-05-21 14:00:55.000000",
+				"payload": {"exception": "disk too small", "args": {"instance": {"uuid": "vm-2", "hostname": "vm", "vm_state": "active"}}}}`,
+			expected: "vm-2",
+		},
+		{
+			name: "context",
+			input: `{"event_type": "port.update.end", "publisher_id": "network.neutron-01", "timestamp": "2024-05-21 14:00:00.000000",
+				"_context_resource_uuid": "vm-3", "payload": {}}`,
+			expected: "vm-3",
+		},
+	} {
+		n, err := JSONToNotification()(tc.input)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if id := InstanceID(n); id != tc.expected {
+			t.Fatalf("%s: unexpected instance ID: %q", tc.name, id)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	test.Setup(t)
+
+	// the cluster and region the message was consumed from survive the
+	// unwrapping, so they can be sent to syslog as structured data
+	line := `{"exchange": "nova", "cluster": "rmq-east", "region": "RegionOne", "body": "` +
+		base64.StdEncoding.EncodeToString([]byte(`{"oslo.version": "2.0", "oslo.message": "{\"event_type\": \"compute.instance.create.end\", \"payload\": {\"instance_id\": \"vm-1\"}}"}`)) + `"}`
+	n, err := Decode()(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := n.(transformers.Sourced)
+	if !ok {
+		t.Fatalf("%T does not implement Sourced", n)
+	}
+	if source := s.Source()["source"]; !slices.Equal(source, []string{"cluster=rmq-east", "region=RegionOne"}) {
+		t.Fatalf("unexpected source: %v", source)
+	}
+}
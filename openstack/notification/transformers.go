@@ -9,9 +9,41 @@ import (
 	"github.com/goccy/go-json"
 
 	"github.com/dihedron/snoop/format"
+	"github.com/dihedron/snoop/openstack/amqp"
 	"github.com/dihedron/snoop/openstack/oslo"
+	"github.com/dihedron/snoop/transform/chain"
+	"github.com/dihedron/snoop/transform/transformers"
+	amqp091 "github.com/rabbitmq/amqp091-go"
 )
 
+// eventTypePattern extracts the event type from an Oslo message payload; it
+// is compiled once as it is used on every message.
+var eventTypePattern = regexp.MustCompile(`\"event_type\":\s*\"([a-zA-Z0-9\._-]+)\"`)
+
+// ErrUnsupported is returned when a notification is of an event type that is
+// not supported.
+var ErrUnsupported = errors.New("unsupported event type")
+
+// EventType returns the event type of an Oslo message payload, without
+// parsing it; it returns an empty string if there is none.
+func EventType(payload string) string {
+	if tokens := eventTypePattern.FindStringSubmatch(payload); len(tokens) > 1 {
+		return tokens[1]
+	}
+	return ""
+}
+
+// Decode unwraps a recorded message, i.e. a line of a recording, down to
+// the OpenStack notification it carries (AMQP->Oslo->OpenStack).
+func Decode() chain.X[string, Notification] {
+	return chain.Of4(
+		transformers.StringToByteArray(),
+		amqp.JSONToMessage(),
+		oslo.MessageToOslo(false),
+		OsloToNotification(false),
+	)
+}
+
 // NewNotificationFromOslo parses an Oslo message and extracts an
 // OpenStack notification if it is one of the supported event types;
 // it may include the original amqp091.Delivery if available in the Oslo
@@ -20,14 +52,23 @@ import (
 func OsloToNotification(includeBackRef bool) func(*oslo.Oslo) (Notification, error) {
 	return func(oslo *oslo.Oslo) (Notification, error) {
 		notification, err := JSONToNotification()(oslo.Payload)
-		if err == nil && oslo != nil && includeBackRef && oslo.BackRef() != nil {
+		if err != nil {
+			return notification, err
+		}
+		// all notifications embed Base, whose methods they inherit
+		base, ok := notification.(interface {
+			SetSource(map[string][]string)
+			SetBackRef(*amqp091.Delivery)
+		})
+		if !ok {
+			return notification, nil
+		}
+		base.SetSource(oslo.Source())
+		if includeBackRef && oslo.BackRef() != nil {
 			slog.Debug("adding back-reference to original AMQP delivery", "reference", oslo.BackRef().DeliveryTag)
-			if base, ok := notification.(*Base); ok {
-				slog.Debug("setting back reference")
-				base.backref = oslo.BackRef()
-			}
+			base.SetBackRef(oslo.BackRef())
 		}
-		return notification, err
+		return notification, nil
 	}
 }
 
@@ -42,9 +83,7 @@ func JSONToNotification() func(string) (Notification, error) {
 		// we can switch on the string and use the proper Notification
 		// concrtete type; the pattees extracts the event type from an
 		// Oslo message BEFORE unescaping quotes.
-		pattern := regexp.MustCompile(`\"event_type\":\s*\"([a-zA-Z0-9\._-]+)\"`)
-
-		tokens := pattern.FindStringSubmatch(input)
+		tokens := eventTypePattern.FindStringSubmatch(input)
 		//slog.Debug("regular expression applied, value: %q, tokens: %v", input, tokens)
 		slog.Debug("regular expression applied", "tokens", tokens)
 
@@ -230,7 +269,7 @@ func JSONToNotification() func(string) (Notification, error) {
 		default:
 			slog.Debug("unsupported event type", "event type", tokens[1])
 			format.WriteToFileAsJSON(".", tokens[1]+"-*.json", input)
-			return nil, fmt.Errorf("unsupported event type: %T", tokens[1])
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, tokens[1])
 		}
 
 		// unescape the payload to make it a valid JSON
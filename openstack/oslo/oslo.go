@@ -18,6 +18,8 @@ type Oslo struct {
 	// in order for it to be parsed as valid JSON, it requires unescaping the
 	// quotes.
 	Payload string `json:"payload,omitempty" yaml:"payload,omitempty"`
+	// source is where the message comes from (see amqp.Message.Source).
+	source map[string][]string
 	// backref is a reference to the underlying RabbitMQ Delivery
 	backref *amqp091.Delivery
 }
@@ -27,6 +29,13 @@ func (o *Oslo) String() string {
 	return format.ToJSON(o)
 }
 
+// Source returns the source cluster and region of the AMQP message the Oslo
+// message was extracted from, as syslog structured data; it returns nil if
+// the message is not tagged.
+func (o *Oslo) Source() map[string][]string {
+	return o.source
+}
+
 // Ack allows to acknowledge the Oslo's underlying amqp091.Delivery, if set.
 func (o *Oslo) Ack(multiple bool) error {
 	slog.Debug("acknowledging Oslo message...", "type", format.TypeAsString(o))
@@ -17,6 +17,9 @@ func MessageToOslo(includeBackRef bool) func(*amqp.Message) (*Oslo, error) {
 			return nil, errors.New("invalid input") //ErrInvalidInput
 		}
 		oslo, err := JSONToOslo()(message.Body)
+		if err == nil && oslo != nil {
+			oslo.source = message.Source()
+		}
 		if err == nil && oslo != nil && includeBackRef && message.BackRef() != nil {
 			slog.Debug("adding back-reference to original AMQP delivery", "reference", message.BackRef().DeliveryTag)
 			oslo.backref = message.BackRef()
@@ -0,0 +1,216 @@
+package stats
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/openstack/oslo"
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Count is the number of occurrences of a key.
+type Count struct {
+	// Key is the counted value (e.g. an event type).
+	Key string `json:"key" yaml:"key"`
+	// Count is the number of occurrences.
+	Count int64 `json:"count" yaml:"count"`
+}
+
+// Report is the summary of a set of notifications.
+type Report struct {
+	// Total is the number of notifications.
+	Total int64 `json:"total" yaml:"total"`
+	// Failed is the number of messages that could not be decoded.
+	Failed int64 `json:"failed" yaml:"failed"`
+	// Failures is the number of decoding failures, by stage.
+	Failures []Count `json:"failures,omitempty" yaml:"failures,omitempty"`
+	// Unsupported is the number of notifications of unsupported event
+	// types, which are not counted in the other statistics.
+	Unsupported int64 `json:"unsupported" yaml:"unsupported"`
+	// UnsupportedTypes is the number of notifications of unsupported event
+	// types, by event type.
+	UnsupportedTypes []Count `json:"unsupportedtypes,omitempty" yaml:"unsupportedtypes,omitempty"`
+	// First is the timestamp of the earliest notification.
+	First time.Time `json:"first" yaml:"first"`
+	// Last is the timestamp of the latest notification.
+	Last time.Time `json:"last" yaml:"last"`
+	// Span is the time between the earliest and the latest notification.
+	Span time.Duration `json:"span" yaml:"span"`
+	// Rate is the average number of notifications per minute.
+	Rate float64 `json:"rate" yaml:"rate"`
+	// PeakRate is the highest number of notifications in a single minute.
+	PeakRate int64 `json:"peakrate" yaml:"peakrate"`
+	// EventTypes is the number of notifications by event type.
+	EventTypes []Count `json:"eventtypes" yaml:"eventtypes"`
+	// Publishers is the number of notifications by publisher.
+	Publishers []Count `json:"publishers" yaml:"publishers"`
+	// Projects is the number of notifications by project.
+	Projects []Count `json:"projects" yaml:"projects"`
+	// Users is the number of notifications by user.
+	Users []Count `json:"users" yaml:"users"`
+	// Hosts is the number of notifications by host.
+	Hosts []Count `json:"hosts" yaml:"hosts"`
+}
+
+// Collector gathers statistics about the notifications flowing through a
+// chain. It is safe for concurrent use.
+type Collector struct {
+	lock        sync.Mutex
+	total       int64
+	failures    map[string]int64
+	unsupported map[string]int64
+	first       time.Time
+	last        time.Time
+	minutes     map[int64]int64
+	eventTypes  map[string]int64
+	publishers  map[string]int64
+	projects    map[string]int64
+	users       map[string]int64
+	hosts       map[string]int64
+}
+
+// New returns a new, empty Collector.
+func New() *Collector {
+	return &Collector{
+		failures:    map[string]int64{},
+		unsupported: map[string]int64{},
+		minutes:     map[int64]int64{},
+		eventTypes:  map[string]int64{},
+		publishers:  map[string]int64{},
+		projects:    map[string]int64{},
+		users:       map[string]int64{},
+		hosts:       map[string]int64{},
+	}
+}
+
+// Add adds the notification flowing into the transformer to the statistics.
+// This filter does not affect the value flowing through.
+func (c *Collector) Add() chain.F[notification.Notification] {
+	return func(n notification.Notification) (notification.Notification, error) {
+		c.add(n)
+		return n, nil
+	}
+}
+
+// Track wraps a decoding stage so that its failures are counted under the
+// given stage name; Drop and Quit are not failures. The returned stage does
+// not change the values or errors.
+func Track[S any, T any](c *Collector, stage string, xform chain.X[S, T]) chain.X[S, T] {
+	return func(s S) (T, error) {
+		t, err := xform(s)
+		if err != nil && !errors.Is(err, chain.Drop) && !errors.Is(err, chain.Quit) {
+			c.lock.Lock()
+			c.failures[stage]++
+			c.lock.Unlock()
+		}
+		return t, err
+	}
+}
+
+// Unsupported wraps the stage that extracts the notifications from the Oslo
+// messages so that the notifications of unsupported event types are counted
+// by event type and dropped, instead of failing the stage.
+func Unsupported(c *Collector, xform chain.X[*oslo.Oslo, notification.Notification]) chain.X[*oslo.Oslo, notification.Notification] {
+	return func(o *oslo.Oslo) (notification.Notification, error) {
+		n, err := xform(o)
+		if errors.Is(err, notification.ErrUnsupported) {
+			c.lock.Lock()
+			c.unsupported[cmp.Or(notification.EventType(o.Payload), "unknown")]++
+			c.lock.Unlock()
+			return nil, chain.Drop
+		}
+		return n, err
+	}
+}
+
+// Report returns the statistics gathered so far; the lists are sorted by
+// decreasing count and, if top is greater than 0, truncated to the top
+// entries.
+func (c *Collector) Report(top int) *Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	report := &Report{
+		Total:            c.total,
+		Failures:         sorted(c.failures, 0),
+		UnsupportedTypes: sorted(c.unsupported, top),
+		First:            c.first,
+		Last:             c.last,
+		Span:             c.last.Sub(c.first),
+		EventTypes:       sorted(c.eventTypes, top),
+		Publishers:       sorted(c.publishers, top),
+		Projects:         sorted(c.projects, top),
+		Users:            sorted(c.users, top),
+		Hosts:            sorted(c.hosts, top),
+	}
+	for _, failures := range c.failures {
+		report.Failed += failures
+	}
+	for _, count := range c.unsupported {
+		report.Unsupported += count
+	}
+	for _, count := range c.minutes {
+		report.PeakRate = max(report.PeakRate, count)
+	}
+	if minutes := report.Span.Minutes(); minutes >= 1 {
+		report.Rate = float64(c.total) / minutes
+	} else {
+		report.Rate = float64(c.total)
+	}
+	return report
+}
+
+func (c *Collector) add(n notification.Notification) {
+	summary := n.Summary()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.total++
+	if t := summary.Timestamp; !t.IsZero() {
+		if c.first.IsZero() || t.Before(c.first) {
+			c.first = t
+		}
+		if t.After(c.last) {
+			c.last = t
+		}
+		c.minutes[t.Unix()/60]++
+	}
+	c.eventTypes[cmp.Or(summary.EventType, "unknown")]++
+	c.publishers[cmp.Or(summary.PublisherID, "unknown")]++
+	c.projects[cmp.Or(summary.ProjectName, summary.ProjectID, "unknown")]++
+	c.users[cmp.Or(summary.UserName, summary.UserID, "unknown")]++
+	c.hosts[cmp.Or(Host(n), "unknown")]++
+}
+
+// Host returns the host a notification refers to: that of the instance for
+// compute instance notifications, otherwise the host part of the publisher
+// ID (e.g. "compute-01" in "compute.compute-01").
+func Host(n notification.Notification) string {
+	if i, ok := n.(*notification.ComputeInstance); ok && i.Payload.Host != "" {
+		return i.Payload.Host
+	}
+	if _, host, ok := strings.Cut(n.Summary().PublisherID, "."); ok {
+		return host
+	}
+	return ""
+}
+
+// sorted returns the counts sorted by decreasing count, and then by key;
+// if top is greater than 0, only the first top are returned.
+func sorted(counts map[string]int64, top int) []Count {
+	result := make([]Count, 0, len(counts))
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		result = append(result, Count{Key: key, Count: counts[key]})
+	}
+	slices.SortStableFunc(result, func(a, b Count) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	if top > 0 && len(result) > top {
+		result = result[:top]
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package stats
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/openstack/oslo"
+	"github.com/dihedron/snoop/test"
+	"github.com/dihedron/snoop/test/fixture"
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+func TestReport(t *testing.T) {
+	test.Setup(t)
+
+	collector := New()
+	add := collector.Add()
+	for _, n := range []notification.Notification{
+		fixture.Instance("compute.instance.exists", "").At(10).Host("compute-01").Project("alpha").User("admin").Build(),
+		fixture.Instance("compute.instance.exists", "").At(20).Host("compute-02").Project("alpha").User("admin").Build(),
+		fixture.Instance("compute.instance.exists", "").At(30).Host("compute-01").Project("beta").User("admin").Build(),
+		fixture.Instance("compute.instance.create.error", "").At(70).Host("compute-01").Project("beta").User("admin").Build(),
+		fixture.Instance("compute.instance.create.end", "").At(610).Host("compute-03").Project("gamma").User("admin").Build(),
+	} {
+		if _, err := add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	identity := &notification.Identity{}
+	identity.EventType = "identity.authenticate"
+	identity.PublisherID = "identity.keystone-01"
+	add(identity)
+
+	failing := Track(collector, "oslo", func(s string) (string, error) {
+		switch s {
+		case "bad":
+			return s, errors.New("invalid payload")
+		case "skip":
+			return s, chain.Drop
+		}
+		return s, nil
+	})
+	for _, s := range []string{"good", "bad", "skip", "bad"} {
+		failing(s)
+	}
+
+	// notifications of unsupported event types are not decoding failures;
+	// their payloads are dumped to the current directory
+	t.Chdir(t.TempDir())
+	decode := Track(collector, "notification", Unsupported(collector, notification.OsloToNotification(false)))
+	for _, payload := range []string{
+		`{"event_type": "volume.create.start", "payload": {}}`,
+		`{"event_type": "volume.create.start", "payload": {}}`,
+		`{"payload": {}}`,
+	} {
+		decode(&oslo.Oslo{Version: "2.0", Payload: payload})
+	}
+
+	report := collector.Report(2)
+	if report.Total != 6 || report.Failed != 3 || !slices.Equal(report.Failures, []Count{{"oslo", 2}, {"notification", 1}}) {
+		t.Fatalf("unexpected totals: %+v", report)
+	}
+	if report.Unsupported != 2 || !slices.Equal(report.UnsupportedTypes, []Count{{"volume.create.start", 2}}) {
+		t.Fatalf("unexpected unsupported event types: %d %v", report.Unsupported, report.UnsupportedTypes)
+	}
+	if !slices.Equal(report.EventTypes, []Count{{"compute.instance.exists", 3}, {"compute.instance.create.end", 1}}) {
+		t.Fatalf("unexpected event types: %v", report.EventTypes)
+	}
+	if !slices.Equal(report.Hosts, []Count{{"compute-01", 3}, {"compute-02", 1}}) {
+		t.Fatalf("unexpected hosts: %v", report.Hosts)
+	}
+	if !slices.Equal(report.Projects, []Count{{"alpha", 2}, {"beta", 2}}) {
+		t.Fatalf("unexpected projects: %v", report.Projects)
+	}
+	if report.Span != 10*time.Minute || report.Rate != 0.6 || report.PeakRate != 3 {
+		t.Fatalf("unexpected time statistics: span %s, rate %f, peak %d", report.Span, report.Rate, report.PeakRate)
+	}
+	if all := collector.Report(0); len(all.Hosts) != 4 || all.Hosts[3] != (Count{"keystone-01", 1}) {
+		t.Fatalf("unexpected hosts: %v", all.Hosts)
+	}
+}
+
+func TestHost(t *testing.T) {
+	test.Setup(t)
+
+	n := &notification.Port{}
+	n.PublisherID = "network.neutron-01"
+	if host := Host(n); host != "neutron-01" {
+		t.Fatalf("unexpected host: %q", host)
+	}
+	n.PublisherID = "neutron"
+	if host := Host(n); host != "" {
+		t.Fatalf("unexpected host: %q", host)
+	}
+}
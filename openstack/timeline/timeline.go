@@ -0,0 +1,220 @@
+package timeline
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Event is a step in the lifecycle of an instance.
+type Event struct {
+	// Timestamp is the time the notification was emitted.
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	// Phase is the operation the event is part of (e.g. "create", "resize",
+	// "scheduling"), or "update" for state transitions.
+	Phase string `json:"phase" yaml:"phase"`
+	// EventType is the type of the notification.
+	EventType string `json:"event_type" yaml:"event_type"`
+	// Host is the host the instance is on, if known.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Details describe what happened (e.g. "state building → active").
+	Details string `json:"details,omitempty" yaml:"details,omitempty"`
+	// Duration is the time since the start of the phase, for the events
+	// that end it.
+	Duration time.Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// Error is whether the event reports a failure.
+	Error bool `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Phase is an operation on the instance, from its .start notification to
+// its .end (or .error) notification.
+type Phase struct {
+	// Name is the name of the operation (e.g. "create").
+	Name string `json:"name" yaml:"name"`
+	// Start is the time the operation started.
+	Start time.Time `json:"start" yaml:"start"`
+	// End is the time the operation ended, zero if it never did.
+	End time.Time `json:"end,omitempty" yaml:"end,omitempty"`
+	// Duration is the time the operation took.
+	Duration time.Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// Failed is whether the operation ended with an error.
+	Failed bool `json:"failed,omitempty" yaml:"failed,omitempty"`
+}
+
+// Timeline is the ordered sequence of events in the lifecycle of an
+// instance, along with the operations performed on it.
+type Timeline struct {
+	// InstanceID is the ID of the instance.
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	// Name is the display name of the instance, if known.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Events are the events, in chronological order.
+	Events []Event `json:"events" yaml:"events"`
+	// Phases are the operations, in the order they started.
+	Phases []Phase `json:"phases" yaml:"phases"`
+}
+
+// Builder collects the notifications about an instance and builds its
+// timeline; since the notifications may come from several recordings, in
+// any order, they are sorted by time before being turned into events. It
+// is safe for concurrent use.
+type Builder struct {
+	instanceID    string
+	lock          sync.Mutex
+	notifications []notification.Notification
+}
+
+// New returns a Builder for the timeline of the given instance.
+func New(instanceID string) *Builder {
+	return &Builder{
+		instanceID: instanceID,
+	}
+}
+
+// Add collects the notification flowing into the transformer if it is
+// about the instance, that is if it is a compute instance, compute task or
+// exception notification referring to it; the notification flows through
+// unchanged.
+func (b *Builder) Add() chain.X[notification.Notification, notification.Notification] {
+	return func(n notification.Notification) (notification.Notification, error) {
+		switch n.(type) {
+		case *notification.ComputeInstance, *notification.ComputeTask, *notification.Exception:
+			if notification.InstanceID(n) == b.instanceID {
+				b.lock.Lock()
+				b.notifications = append(b.notifications, n)
+				b.lock.Unlock()
+			}
+		}
+		return n, nil
+	}
+}
+
+// Len returns the number of notifications collected.
+func (b *Builder) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.notifications)
+}
+
+// Timeline returns the timeline of the instance built from the
+// notifications collected so far.
+func (b *Builder) Timeline() *Timeline {
+	b.lock.Lock()
+	notifications := slices.Clone(b.notifications)
+	b.lock.Unlock()
+	slices.SortStableFunc(notifications, func(a, b notification.Notification) int {
+		return a.Summary().Timestamp.Compare(b.Summary().Timestamp)
+	})
+
+	timeline := &Timeline{
+		InstanceID: b.instanceID,
+		Events:     []Event{},
+		Phases:     []Phase{},
+	}
+	// the phases that have started but not ended yet, by name
+	open := map[string]int{}
+	host, state := "", ""
+	for _, n := range notifications {
+		summary := n.Summary()
+		event := Event{
+			Timestamp: summary.Timestamp,
+			EventType: summary.EventType,
+		}
+		details := []string{}
+		switch n := n.(type) {
+		case *notification.ComputeInstance:
+			if timeline.Name == "" {
+				timeline.Name = n.Payload.DisplayName
+			}
+			if n.Payload.Host != "" && n.Payload.Host != host {
+				if host == "" {
+					details = append(details, "on host "+n.Payload.Host)
+				} else {
+					details = append(details, fmt.Sprintf("host %s → %s", host, n.Payload.Host))
+				}
+				host = n.Payload.Host
+			}
+			// updates carry the previous state, the others only the
+			// current one, which is compared with the last one seen
+			previous := cmp.Or(n.Payload.OldState, state)
+			if n.Payload.State != "" && n.Payload.State != previous {
+				if previous == "" {
+					details = append(details, "state "+n.Payload.State)
+				} else {
+					details = append(details, fmt.Sprintf("state %s → %s", previous, n.Payload.State))
+				}
+			}
+			state = cmp.Or(n.Payload.State, state)
+			if n.Payload.OldTaskState != n.Payload.NewTaskState {
+				details = append(details, fmt.Sprintf("task %s → %s", cmp.Or(n.Payload.OldTaskState, "none"), cmp.Or(n.Payload.NewTaskState, "none")))
+			}
+			if strings.HasSuffix(summary.EventType, ".error") {
+				event.Error = true
+				details = append(details, cmp.Or(n.Payload.Exception, n.Payload.Message))
+			}
+		case *notification.ComputeTask:
+			if n.Payload.State != "" {
+				details = append(details, "state "+n.Payload.State)
+			}
+			if n.Payload.Reason != "" {
+				event.Error = true
+				details = append(details, n.Payload.Reason)
+			}
+		case *notification.Exception:
+			event.Error = true
+			details = append(details, n.Payload.Exception)
+		}
+		event.Host = host
+
+		// phases go from .start to .end (or .error) notifications
+		name, stage := phase(summary.EventType)
+		event.Phase = name
+		switch stage {
+		case "start":
+			open[name] = len(timeline.Phases)
+			timeline.Phases = append(timeline.Phases, Phase{Name: name, Start: event.Timestamp})
+		case "end", "error":
+			if i, ok := open[name]; ok {
+				delete(open, name)
+				p := &timeline.Phases[i]
+				p.End = event.Timestamp
+				p.Duration = p.End.Sub(p.Start)
+				p.Failed = stage == "error"
+				event.Duration = p.Duration
+			}
+		}
+		event.Details = strings.Join(slices.DeleteFunc(details, func(s string) bool { return s == "" }), ", ")
+		timeline.Events = append(timeline.Events, event)
+	}
+	return timeline
+}
+
+// phase returns the operation an event type is part of and the stage of
+// the operation ("start", "end" or "error"), if any: e.g. "resize" and
+// "start" for compute.instance.resize.start, "update" for
+// compute.instance.update, "scheduling" for scheduler.select_destinations.*,
+// "build_instances" for compute_task.build_instances and the event type
+// itself for exceptions.
+func phase(eventType string) (name string, stage string) {
+	switch {
+	case strings.HasPrefix(eventType, "scheduler.select_destinations."):
+		return "scheduling", strings.TrimPrefix(eventType, "scheduler.select_destinations.")
+	case strings.HasPrefix(eventType, "compute.instance."):
+		name = strings.TrimPrefix(eventType, "compute.instance.")
+		for _, stage := range []string{"start", "end", "error"} {
+			if before, ok := strings.CutSuffix(name, "."+stage); ok {
+				return before, stage
+			}
+		}
+		return name, ""
+	case strings.HasPrefix(eventType, "compute_task."):
+		return strings.TrimPrefix(eventType, "compute_task."), ""
+	}
+	return eventType, ""
+}
@@ -0,0 +1,85 @@
+package timeline
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dihedron/snoop/openstack/notification"
+	"github.com/dihedron/snoop/test"
+	"github.com/dihedron/snoop/test/fixture"
+)
+
+func TestTimeline(t *testing.T) {
+	test.Setup(t)
+
+	scheduling := &notification.ComputeTask{}
+	scheduling.Timestamp = fixture.Timestamp(1)
+	scheduling.EventType = "scheduler.select_destinations.start"
+	scheduling.Payload.RequestSpec.InstanceProperties.UUID = "vm-1"
+	scheduled := &notification.ComputeTask{}
+	scheduled.Timestamp = fixture.Timestamp(2)
+	scheduled.EventType = "scheduler.select_destinations.end"
+	scheduled.Payload.RequestSpec.InstanceProperties.UUID = "vm-1"
+	update := fixture.Instance("compute.instance.update", "vm-1").At(25).Host("cmp-1").State("active").Name("vm").Build()
+	update.Payload.OldState = "building"
+	failure := &notification.Exception{}
+	failure.Timestamp = fixture.Timestamp(55)
+	failure.EventType = "resize_instance"
+	failure.Payload.Exception = "disk too small"
+	failure.Payload.Args.Instance.UUID = "vm-1"
+
+	// notifications arrive out of order, with some about other instances
+	builder := New("vm-1")
+	add := builder.Add()
+	for _, n := range []notification.Notification{
+		fixture.Instance("compute.instance.create.start", "vm-1").At(10).Host("cmp-1").State("building").Name("vm").Build(),
+		scheduled,
+		scheduling,
+		fixture.Instance("compute.instance.create.start", "vm-2").At(12).Host("cmp-2").State("building").Name("vm").Build(),
+		fixture.Instance("compute.instance.create.end", "vm-1").At(30).Host("cmp-1").State("active").Name("vm").Build(),
+		update,
+		fixture.Instance("compute.instance.resize.start", "vm-1").At(50).Host("cmp-1").State("active").Name("vm").Build(),
+		failure,
+		fixture.Instance("compute.instance.resize.end", "vm-1").At(58).Host("cmp-7").State("active").Name("vm").Build(),
+		fixture.Instance("compute.instance.delete.start", "vm-1").At(59).Host("cmp-7").State("active").Name("vm").Build(),
+		&notification.Identity{},
+	} {
+		if _, err := add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if builder.Len() != 9 {
+		t.Fatalf("unexpected number of notifications: %d", builder.Len())
+	}
+
+	timeline := builder.Timeline()
+	details := []string{}
+	for _, event := range timeline.Events {
+		details = append(details, event.Phase+": "+event.Details)
+	}
+	expected := []string{
+		"scheduling: ",
+		"scheduling: ",
+		"create: on host cmp-1, state building",
+		"update: state building → active",
+		"create: ",
+		"resize: ",
+		"resize_instance: disk too small",
+		"resize: host cmp-1 → cmp-7",
+		"delete: ",
+	}
+	if timeline.Name != "vm" || !slices.Equal(details, expected) {
+		t.Fatalf("unexpected events: %q", details)
+	}
+	if !timeline.Events[6].Error || timeline.Events[6].Host != "cmp-1" || timeline.Events[4].Duration != 20*time.Second {
+		t.Fatalf("unexpected events: %+v", timeline.Events)
+	}
+	phases := []string{}
+	for _, phase := range timeline.Phases {
+		phases = append(phases, phase.Name+" "+phase.Duration.String())
+	}
+	if !slices.Equal(phases, []string{"scheduling 1s", "create 20s", "resize 8s", "delete 0s"}) || !timeline.Phases[3].End.IsZero() {
+		t.Fatalf("unexpected phases: %v", phases)
+	}
+}
@@ -1,6 +1,7 @@
 package syslog
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -119,6 +120,8 @@ func (s *Syslog) Send(message *Message) error {
 	}
 	if s, ok := (message.Content).(string); ok {
 		msg.Msg = s
+	} else if s, ok := (message.Content).(fmt.Stringer); ok {
+		msg.Msg = s.String()
 	} else if message.Content != nil {
 		if s, err := json.Marshal(message.Content); err != nil {
 			return err
@@ -134,6 +137,25 @@ func (s *Syslog) Send(message *Message) error {
 	return s.client.Send(msg)
 }
 
+// SendContext is like Send, but it does not send the message if the context
+// is done, and it stops waiting for the message to be sent when the context
+// is cancelled, returning the context's error.
+func (s *Syslog) SendContext(ctx context.Context, message *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Send(message)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Message contains the set of information that is specific
 // to a given message: its priority (in terms of facility and
 // severity), the message type identifier (to group similar
@@ -143,7 +165,7 @@ type Message struct {
 	Facility rfc5424.Facility
 	Severity rfc5424.Severity
 	ID       string
-	Content  any // either a string or an object that will be marshalled to JSON
+	Content  any // either a string, a fmt.Stringer or an object that will be marshalled to JSON
 	Data     map[string][]string
 }
 
@@ -0,0 +1,86 @@
+package fixture
+
+import (
+	"time"
+
+	"github.com/dihedron/snoop/openstack/notification"
+)
+
+// Epoch is the time the fixture timestamps are relative to.
+var Epoch = time.Date(2024, 5, 21, 14, 0, 0, 0, time.UTC)
+
+// Timestamp returns the time the given number of seconds after Epoch, in
+// the OpenStack format.
+func Timestamp(seconds int) string {
+	return Epoch.Add(time.Duration(seconds) * time.Second).Format("2006-01-02 15:04:05.000000")
+}
+
+// InstanceBuilder builds compute instance notifications for tests.
+type InstanceBuilder struct {
+	n *notification.ComputeInstance
+}
+
+// Instance returns a builder of a compute instance notification of the
+// given event type about the given instance, emitted at Epoch.
+func Instance(eventType string, id string) *InstanceBuilder {
+	n := &notification.ComputeInstance{}
+	n.EventType = eventType
+	n.Timestamp = Timestamp(0)
+	n.Payload.InstanceID = id
+	return &InstanceBuilder{n: n}
+}
+
+// At sets the time the notification is emitted, in seconds after Epoch.
+func (b *InstanceBuilder) At(seconds int) *InstanceBuilder {
+	b.n.Timestamp = Timestamp(seconds)
+	return b
+}
+
+// Host sets the host the instance is on, which is also the publisher.
+func (b *InstanceBuilder) Host(host string) *InstanceBuilder {
+	b.n.PublisherID = "compute." + host
+	b.n.Payload.Host = host
+	return b
+}
+
+// State sets the state of the instance.
+func (b *InstanceBuilder) State(state string) *InstanceBuilder {
+	b.n.Payload.State = state
+	return b
+}
+
+// Name sets the display name and host name of the instance.
+func (b *InstanceBuilder) Name(name string) *InstanceBuilder {
+	b.n.Payload.DisplayName = name
+	b.n.Payload.Hostname = name
+	return b
+}
+
+// Zone sets the availability zone of the instance.
+func (b *InstanceBuilder) Zone(zone string) *InstanceBuilder {
+	b.n.Payload.AvailabilityZone = zone
+	return b
+}
+
+// Request sets the ID of the request that caused the notification.
+func (b *InstanceBuilder) Request(id string) *InstanceBuilder {
+	b.n.ContextRequestID = id
+	return b
+}
+
+// Project sets the name of the project of the request.
+func (b *InstanceBuilder) Project(name string) *InstanceBuilder {
+	b.n.ContextProjectName = name
+	return b
+}
+
+// User sets the name of the user of the request.
+func (b *InstanceBuilder) User(name string) *InstanceBuilder {
+	b.n.ContextUserName = name
+	return b
+}
+
+// Build returns the notification.
+func (b *InstanceBuilder) Build() *notification.ComputeInstance {
+	return b.n
+}
@@ -0,0 +1,74 @@
+package chain
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// If applies the "then" transformation if the condition is true, the
+// "otherwise" transformation if it is false; if "otherwise" is nil, the
+// value is dropped.
+func If[S any, T any](condition func(S) bool, then X[S, T], otherwise X[S, T]) X[S, T] {
+	return func(s S) (T, error) {
+		if condition(s) {
+			return then(s)
+		}
+		if otherwise != nil {
+			return otherwise(s)
+		}
+		var t T
+		return t, Drop
+	}
+}
+
+// Switch routes the value to the transformation associated with the key
+// extracted from the value by the keyer, e.g. the event type of an
+// OpenStack notification; if there is no transformation for the key, the
+// value is dropped.
+func Switch[S any, T any, K comparable](keyer func(S) K, cases map[K]X[S, T]) X[S, T] {
+	return func(s S) (T, error) {
+		key := keyer(s)
+		if xform, ok := cases[key]; ok && xform != nil {
+			return xform(s)
+		}
+		slog.Debug("no transformation for key, dropping value", "key", key)
+		var t T
+		return t, Drop
+	}
+}
+
+// Tee runs all the given sub-chains on the same value, one after the other,
+// then lets the original value flow through unchanged; it is meant for
+// side-effecting sub-chains (e.g. writing to different outputs). A sub-chain
+// dropping the value does not affect the others; if any of them returns Quit,
+// Quit is returned, otherwise the errors of all failed sub-chains are joined.
+func Tee[T any](xforms ...F[T]) F[T] {
+	return func(value T) (T, error) {
+		var result error
+		quit := false
+		for _, xform := range xforms {
+			_, err := xform(value)
+			switch {
+			case err == nil, errors.Is(err, Drop):
+			case errors.Is(err, Quit):
+				quit = true
+			default:
+				result = errors.Join(result, err)
+			}
+		}
+		if quit {
+			return value, Quit
+		}
+		return value, result
+	}
+}
+
+// Effect turns a transformation into a filter that runs it for its side
+// effects and lets the original value flow through, so that sub-chains with
+// any output type can be used in a Tee.
+func Effect[S any, T any](xform X[S, T]) F[S] {
+	return func(s S) (S, error) {
+		_, err := xform(s)
+		return s, err
+	}
+}
@@ -0,0 +1,93 @@
+package chain
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/dihedron/snoop/test"
+)
+
+func TestIf(t *testing.T) {
+	test.Setup(t)
+
+	even := func(i int) bool { return i%2 == 0 }
+	half := func(i int) (int, error) { return i / 2, nil }
+	triple := func(i int) (int, error) { return i * 3, nil }
+
+	xform := If(even, half, triple)
+	if v, err := xform(10); err != nil || v != 5 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	if v, err := xform(5); err != nil || v != 15 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	if _, err := If(even, half, nil)(5); !errors.Is(err, Drop) {
+		t.Fatalf("expected Drop, got %v", err)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	test.Setup(t)
+
+	xform := Switch(
+		func(s string) string { return s[:1] },
+		map[string]X[string, int]{
+			"a": func(s string) (int, error) { return len(s), nil },
+			"1": func(s string) (int, error) { return strconv.Atoi(s) },
+		},
+	)
+	if v, err := xform("abc"); err != nil || v != 3 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	if v, err := xform("123"); err != nil || v != 123 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	if _, err := xform("xyz"); !errors.Is(err, Drop) {
+		t.Fatalf("expected Drop, got %v", err)
+	}
+}
+
+func TestTee(t *testing.T) {
+	test.Setup(t)
+
+	outputs := []string{}
+	failure := errors.New("failure")
+	xform := Tee(
+		Effect(func(i int) (string, error) {
+			outputs = append(outputs, "first:"+strconv.Itoa(i))
+			return "", nil
+		}),
+		func(i int) (int, error) {
+			if i > 1 {
+				return i, Drop
+			}
+			outputs = append(outputs, "second:"+strconv.Itoa(i))
+			return i * 100, nil
+		},
+		func(i int) (int, error) {
+			if i == 3 {
+				return i, failure
+			}
+			if i == 4 {
+				return i, Quit
+			}
+			return i, nil
+		},
+	)
+	if v, err := xform(1); err != nil || v != 1 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	if v, err := xform(2); err != nil || v != 2 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	if _, err := xform(3); !errors.Is(err, failure) {
+		t.Fatalf("expected failure, got %v", err)
+	}
+	if _, err := xform(4); !errors.Is(err, Quit) {
+		t.Fatalf("expected Quit, got %v", err)
+	}
+	if len(outputs) != 5 || outputs[1] != "second:1" || outputs[2] != "first:2" {
+		t.Fatalf("unexpected outputs: %v", outputs)
+	}
+}
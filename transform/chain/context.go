@@ -0,0 +1,54 @@
+package chain
+
+import (
+	"context"
+)
+
+// XC is the context-aware variant of X: the transformation receives a
+// context, so it can honour cancellation and deadlines, and access
+// request-scoped values.
+type XC[S any, T any] func(context.Context, S) (T, error)
+
+// FC is the context-aware variant of F.
+type FC[T any] = XC[T, T]
+
+// Lift turns a transformation into a context-aware one; the resulting
+// transformation does not run if the context is already done, in which
+// case it returns the context's error.
+func Lift[S any, T any](xform X[S, T]) XC[S, T] {
+	return func(ctx context.Context, s S) (T, error) {
+		if err := ctx.Err(); err != nil {
+			var t T
+			return t, err
+		}
+		return xform(s)
+	}
+}
+
+// Bind turns a context-aware transformation into a plain one, by binding it
+// to the given context, so that it can be used with Of..., Pipeline, Run etc.
+func Bind[S any, T any](ctx context.Context, xform XC[S, T]) X[S, T] {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return func(s S) (T, error) {
+		return xform(ctx, s)
+	}
+}
+
+// OfC2 returns a context-aware transformation that chains the two given
+// context-aware transformations, executing one after the other and bailing
+// out as soon as an error is encountered or the context is done.
+func OfC2[A any, B any, C any](first XC[A, B], second XC[B, C]) XC[A, C] {
+	return func(ctx context.Context, a A) (C, error) {
+		b, err := first(ctx, a)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			var c C
+			return c, err
+		}
+		return second(ctx, b)
+	}
+}
@@ -0,0 +1,159 @@
+package chain
+
+import (
+	"context"
+	"iter"
+	"sync"
+)
+
+// Result is the outcome of applying a transformation to an input value.
+type Result[S any, T any] struct {
+	// Input is the input value.
+	Input S
+	// Output is the output value, if the transformation succeeded.
+	Output T
+	// Err is the error returned by the transformation, if any.
+	Err error
+}
+
+// Stream is a stage that applies a transformation to a sequence of values,
+// returning the sequence of results.
+type Stream[S any, T any] func(ctx context.Context, values iter.Seq[S]) iter.Seq[Result[S, T]]
+
+// Sequential returns a Stream that applies the transformation to the values
+// one at a time, in the caller's goroutine.
+func Sequential[S any, T any](xform X[S, T]) Stream[S, T] {
+	return func(_ context.Context, values iter.Seq[S]) iter.Seq[Result[S, T]] {
+		return func(yield func(Result[S, T]) bool) {
+			for s := range values {
+				t, err := xform(s)
+				if !yield(Result[S, T]{Input: s, Output: t, Err: err}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Parallel returns a Stream that applies the transformation to the values
+// on n goroutines; if ordered is true, the results are returned in the same
+// order as the input values, otherwise they are returned as soon as they are
+// available. At most 2*n values are read ahead of the consumer, so a slow
+// consumer (or, when ordered, a slow value) slows down the reading of the
+// input sequence. The transformation must be safe for concurrent use. When
+// the context is cancelled or the consumer stops, the workers exit; the input
+// sequence should be context-aware, so that it stops as well.
+func Parallel[S any, T any](n int, xform X[S, T], ordered bool) Stream[S, T] {
+	if n <= 1 {
+		return Sequential(xform)
+	}
+	type job struct {
+		index int64
+		value S
+	}
+	type result struct {
+		index int64
+		Result[S, T]
+	}
+	return func(ctx context.Context, values iter.Seq[S]) iter.Seq[Result[S, T]] {
+		return func(yield func(Result[S, T]) bool) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			// tokens limits the number of values in flight, from the moment
+			// they are read to the moment they are yielded
+			tokens := make(chan struct{}, 2*n)
+			jobs := make(chan job)
+			results := make(chan result, n)
+
+			// producer
+			go func() {
+				defer close(jobs)
+				index := int64(0)
+				for s := range values {
+					select {
+					case tokens <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+					select {
+					case jobs <- job{index: index, value: s}:
+						index++
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+
+			// workers
+			var wg sync.WaitGroup
+			for range n {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						var (
+							j  job
+							ok bool
+						)
+						select {
+						case j, ok = <-jobs:
+							if !ok {
+								return
+							}
+						case <-ctx.Done():
+							return
+						}
+						t, err := xform(j.value)
+						select {
+						case results <- result{index: j.index, Result: Result[S, T]{Input: j.value, Output: t, Err: err}}:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}()
+			}
+			go func() {
+				wg.Wait()
+				close(results)
+			}()
+
+			// consumer, in the caller's goroutine
+			emit := func(r result) bool {
+				<-tokens
+				return yield(r.Result)
+			}
+			pending := map[int64]result{}
+			next := int64(0)
+			for r := range results {
+				if !ordered {
+					if !emit(r) {
+						break
+					}
+					continue
+				}
+				pending[r.index] = r
+				stop := false
+				for {
+					r, ok := pending[next]
+					if !ok {
+						break
+					}
+					delete(pending, next)
+					next++
+					if !emit(r) {
+						stop = true
+						break
+					}
+				}
+				if stop {
+					break
+				}
+			}
+			// stop the workers and wait for them to exit
+			cancel()
+			for range results {
+			}
+		}
+	}
+}
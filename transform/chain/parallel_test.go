@@ -0,0 +1,161 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dihedron/snoop/test"
+)
+
+// jitter is a transformation that takes a random amount of time, so that
+// the results of parallel workers complete out of order.
+func jitter(i int) (int, error) {
+	time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+	if i%10 == 9 {
+		return i, Drop
+	}
+	return i * 2, nil
+}
+
+func TestParallelOrdered(t *testing.T) {
+	test.Setup(t)
+
+	input := make([]int, 1000)
+	for i := range input {
+		input[i] = i
+	}
+	inputs := []int{}
+	outputs := []int{}
+	for result := range Parallel(8, jitter, true)(context.Background(), slices.Values(input)) {
+		inputs = append(inputs, result.Input)
+		if result.Err == nil {
+			outputs = append(outputs, result.Output)
+		}
+	}
+	if !slices.Equal(inputs, input) {
+		t.Fatal("results are not in input order")
+	}
+	if len(outputs) != 900 || outputs[0] != 0 || outputs[899] != 1996 {
+		t.Fatalf("unexpected outputs: %d values", len(outputs))
+	}
+}
+
+func TestParallelUnordered(t *testing.T) {
+	test.Setup(t)
+
+	input := make([]int, 1000)
+	for i := range input {
+		input[i] = i
+	}
+	inputs := []int{}
+	for result := range Parallel(8, jitter, false)(context.Background(), slices.Values(input)) {
+		inputs = append(inputs, result.Input)
+	}
+	slices.Sort(inputs)
+	if !slices.Equal(inputs, input) {
+		t.Fatal("some results are missing or duplicated")
+	}
+}
+
+func TestParallelBackPressure(t *testing.T) {
+	test.Setup(t)
+
+	var read atomic.Int64
+	values := func(yield func(int) bool) {
+		for i := range 1000 {
+			read.Add(1)
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	consumed := int64(0)
+	for range Parallel(4, jitter, true)(context.Background(), values) {
+		consumed++
+		// the producer can only be a few values ahead of the consumer
+		if ahead := read.Load() - consumed; ahead > 2*4+1 {
+			t.Fatalf("producer is %d values ahead of the consumer", ahead)
+		}
+		time.Sleep(100 * time.Microsecond)
+		if consumed == 100 {
+			break
+		}
+	}
+}
+
+func TestParallelCancel(t *testing.T) {
+	test.Setup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// an infinite, context-aware sequence
+	values := func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	count := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range Parallel(4, jitter, false)(ctx, values) {
+			count++
+			if count == 50 {
+				cancel()
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("parallel stage did not stop on context cancellation")
+	}
+	if count < 50 {
+		t.Fatalf("expected at least 50 results, got %d", count)
+	}
+}
+
+func TestRunWithWorkers(t *testing.T) {
+	test.Setup(t)
+
+	input := make([]int, 500)
+	for i := range input {
+		input[i] = i
+	}
+	outputs := []int{}
+	stats, err := Run(context.Background(), slices.Values(input), func(i int) (int, error) {
+		if i == 300 {
+			return i, Quit
+		}
+		return jitter(i)
+	}, func(i int) error {
+		outputs = append(outputs, i)
+		return nil
+	}, WithWorkers[int](8, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Quit || stats.Read != 301 || stats.Written != 270 || stats.Dropped != 30 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if !slices.IsSorted(outputs) {
+		t.Fatal("outputs are not in order")
+	}
+	if _, err := Run(context.Background(), slices.Values(input), jitter, nil, WithWorkers[int](8, false), WithErrorHandler(func(int, error) error {
+		return errors.New("unexpected error")
+	})); err != nil {
+		t.Fatal(err)
+	}
+}
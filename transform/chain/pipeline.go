@@ -0,0 +1,147 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+var (
+	// ErrTypeMismatch is returned when the stages in a Pipeline cannot be
+	// connected because the output type of a stage is not compatible with
+	// the input type of the next one.
+	ErrTypeMismatch = errors.New("type mismatch between pipeline stages")
+	// ErrEmptyPipeline is returned when trying to build a Pipeline with no
+	// stages.
+	ErrEmptyPipeline = errors.New("pipeline has no stages")
+)
+
+// Stage is a type-erased transformation that can be added to a Pipeline; it
+// is created from a typed transformation through the Adapt function, and it
+// keeps track of the input and output types so the Pipeline can check that
+// the stages fit together before running.
+type Stage struct {
+	name  string
+	in    reflect.Type
+	out   reflect.Type
+	apply func(any) (any, error)
+}
+
+// String returns a description of the Stage, in terms of its input and
+// output types.
+func (s Stage) String() string {
+	return fmt.Sprintf("%s(%v → %v)", s.name, s.in, s.out)
+}
+
+// Adapt wraps a typed transformation into a Stage that can be added to a
+// Pipeline; the transformation can change the type of the value (X[S, T]),
+// or it can be a filter (F[T]).
+func Adapt[S any, T any](xform X[S, T]) Stage {
+	return Named("stage", xform)
+}
+
+// Named is like Adapt, but it assigns a name to the Stage, which is used in
+// log and error messages.
+func Named[S any, T any](name string, xform X[S, T]) Stage {
+	return Stage{
+		name: name,
+		in:   reflect.TypeFor[S](),
+		out:  reflect.TypeFor[T](),
+		apply: func(value any) (any, error) {
+			s, ok := value.(S)
+			if !ok && value != nil {
+				return nil, fmt.Errorf("%w: stage %s expects %v, got %T", ErrTypeMismatch, name, reflect.TypeFor[S](), value)
+			}
+			// a nil value is the zero value of an interface type
+			return xform(s)
+		},
+	}
+}
+
+// Filters wraps a set of filters on the same type into a single Stage, which
+// applies them one after the other.
+func Filters[T any](filters ...F[T]) Stage {
+	return Named("filters", func(value T) (T, error) {
+		var err error
+		for _, filter := range filters {
+			if value, err = filter(value); err != nil {
+				return value, err
+			}
+		}
+		return value, nil
+	})
+}
+
+// Pipeline is a builder for chains of transformations that are assembled at
+// runtime (e.g. from command line flags or configuration), as opposed to
+// the fixed-arity Of... functions which require the chain to be fully known
+// at compile time; stages are added with Add and the resulting chain is
+// obtained, with its types checked, with Build.
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline creates a new Pipeline with the given (optional) stages.
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{
+		stages: stages,
+	}
+}
+
+// Add appends the given stages to the Pipeline; it returns the Pipeline
+// itself so calls can be chained.
+func (p *Pipeline) Add(stages ...Stage) *Pipeline {
+	p.stages = append(p.stages, stages...)
+	return p
+}
+
+// Len returns the number of stages in the Pipeline.
+func (p *Pipeline) Len() int {
+	return len(p.stages)
+}
+
+// Stages returns the stages in the Pipeline.
+func (p *Pipeline) Stages() []Stage {
+	return p.stages
+}
+
+// Build checks that the stages in the Pipeline fit together, accepting an S
+// and returning a T, and returns the composite transformation; the stages
+// are executed one after the other, bailing out as soon as an error is
+// encountered, so Drop and Quit are returned to the caller as they are.
+func Build[S any, T any](p *Pipeline) (X[S, T], error) {
+	if p == nil || len(p.stages) == 0 {
+		slog.Error("no stages in pipeline")
+		return nil, ErrEmptyPipeline
+	}
+	previous := reflect.TypeFor[S]()
+	for i, stage := range p.stages {
+		if !previous.AssignableTo(stage.in) {
+			slog.Error("incompatible pipeline stage", "index", i, "stage", stage.String(), "input", previous)
+			return nil, fmt.Errorf("%w: stage %d (%s) cannot accept %v", ErrTypeMismatch, i, stage.String(), previous)
+		}
+		previous = stage.out
+	}
+	if !previous.AssignableTo(reflect.TypeFor[T]()) {
+		slog.Error("incompatible pipeline output", "output", previous, "expected", reflect.TypeFor[T]())
+		return nil, fmt.Errorf("%w: pipeline returns %v, not %v", ErrTypeMismatch, previous, reflect.TypeFor[T]())
+	}
+
+	stages := make([]Stage, len(p.stages))
+	copy(stages, p.stages)
+	return func(s S) (T, error) {
+		var (
+			value any = s
+			err   error
+		)
+		for _, stage := range stages {
+			if value, err = stage.apply(value); err != nil {
+				var t T
+				return t, err
+			}
+		}
+		t, _ := value.(T)
+		return t, nil
+	}, nil
+}
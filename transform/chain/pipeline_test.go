@@ -0,0 +1,84 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dihedron/snoop/test"
+)
+
+func TestPipeline(t *testing.T) {
+	test.Setup(t)
+
+	// assemble the pipeline dynamically, as if from configuration
+	p := NewPipeline(Adapt(strconv.Atoi))
+	for range 15 {
+		p.Add(Adapt(func(i int) (int, error) { return i + 1, nil }))
+	}
+	p.Add(
+		Filters(
+			func(i int) (int, error) {
+				if i%2 != 0 {
+					return i, Drop
+				}
+				return i, nil
+			},
+			func(i int) (int, error) {
+				if i > 100 {
+					return i, Quit
+				}
+				return i, nil
+			},
+		),
+		Named("format", func(i int) (fmt.Stringer, error) { return value(i), nil }),
+		Adapt(func(s fmt.Stringer) (string, error) { return s.String(), nil }),
+	)
+	if p.Len() != 19 {
+		t.Fatalf("expected 19 stages, got %d", p.Len())
+	}
+
+	xform, err := Build[string, string](p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := xform("1"); err != nil || v != "value: 16" {
+		t.Fatalf("unexpected result: %q, %v", v, err)
+	}
+	if _, err := xform("2"); !errors.Is(err, Drop) {
+		t.Fatalf("expected Drop, got %v", err)
+	}
+	if _, err := xform("101"); !errors.Is(err, Quit) {
+		t.Fatalf("expected Quit, got %v", err)
+	}
+	if _, err := xform("abc"); err == nil {
+		t.Fatal("expected error on invalid input")
+	}
+}
+
+func TestPipelineTypeMismatch(t *testing.T) {
+	test.Setup(t)
+
+	if _, err := Build[string, string](NewPipeline()); !errors.Is(err, ErrEmptyPipeline) {
+		t.Fatalf("expected ErrEmptyPipeline, got %v", err)
+	}
+	p := NewPipeline(Adapt(strconv.Atoi), Adapt(func(s string) (string, error) { return strings.ToUpper(s), nil }))
+	if _, err := Build[string, string](p); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected ErrTypeMismatch between stages, got %v", err)
+	}
+	p = NewPipeline(Adapt(strconv.Atoi))
+	if _, err := Build[string, string](p); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected ErrTypeMismatch on output, got %v", err)
+	}
+	if _, err := Build[int, int](p); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected ErrTypeMismatch on input, got %v", err)
+	}
+}
+
+type value int
+
+func (v value) String() string {
+	return fmt.Sprintf("value: %d", int(v))
+}
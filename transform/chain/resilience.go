@@ -0,0 +1,321 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned by a TimeoutContext wrapper when the transformation
+	// does not complete in time.
+	ErrTimeout = errors.New("transformation timed out")
+	// ErrOpen is returned by a Breaker wrapper when the circuit is open and
+	// the transformation is not attempted.
+	ErrOpen = errors.New("circuit breaker is open")
+)
+
+// Metrics collects the counters of the Retry, TimeoutContext and Breaker wrappers;
+// it is safe for concurrent use, and the same Metrics can be shared among
+// several wrappers.
+type Metrics struct {
+	retries       atomic.Int64
+	exhausted     atomic.Int64
+	timeouts      atomic.Int64
+	trips         atomic.Int64
+	shortCircuits atomic.Int64
+}
+
+// MetricsSnapshot is a point-in-time copy of the Metrics counters.
+type MetricsSnapshot struct {
+	// Retries is the number of times a transformation was retried.
+	Retries int64 `json:"retries" yaml:"retries"`
+	// Exhausted is the number of values for which all attempts failed.
+	Exhausted int64 `json:"exhausted" yaml:"exhausted"`
+	// Timeouts is the number of attempts that timed out.
+	Timeouts int64 `json:"timeouts" yaml:"timeouts"`
+	// Trips is the number of times a circuit breaker opened.
+	Trips int64 `json:"trips" yaml:"trips"`
+	// ShortCircuits is the number of values rejected by an open breaker.
+	ShortCircuits int64 `json:"shortcircuits" yaml:"shortcircuits"`
+}
+
+// Snapshot returns the current values of the counters.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	if m == nil {
+		return MetricsSnapshot{}
+	}
+	return MetricsSnapshot{
+		Retries:       m.retries.Load(),
+		Exhausted:     m.exhausted.Load(),
+		Timeouts:      m.timeouts.Load(),
+		Trips:         m.trips.Load(),
+		ShortCircuits: m.shortCircuits.Load(),
+	}
+}
+
+// transient returns whether the error is a real failure, as opposed to one
+// of the sentinel errors used to control the flow of the chain, or the
+// cancellation of the context.
+func transient(err error) bool {
+	return err != nil &&
+		!errors.Is(err, Drop) &&
+		!errors.Is(err, Quit) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// RetryPolicy describes how a failed transformation is retried.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one;
+	// if not set, the transformation is attempted 3 times.
+	Attempts int
+	// Initial is the delay before the first retry; if not set, it is 100ms.
+	Initial time.Duration
+	// Max is the maximum delay between retries; if not set, there is no
+	// maximum.
+	Max time.Duration
+	// Multiplier is the factor by which the delay grows after each retry; if
+	// not set, it is 2 (exponential backoff).
+	Multiplier float64
+	// Jitter is the fraction of the delay that is randomised (between 0 and
+	// 1), so that retries from multiple clients do not synchronise.
+	Jitter float64
+	// Retryable decides whether an error is worth retrying; if not set, all
+	// errors except Drop, Quit and context cancellation are retried.
+	Retryable func(error) bool
+	// Metrics, if set, collects the number of retries and exhausted attempts.
+	Metrics *Metrics
+}
+
+// delay returns the delay before the given retry (starting from 1).
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	delay := float64(p.Initial)
+	for range retry - 1 {
+		delay *= p.Multiplier
+		if p.Max > 0 && delay > float64(p.Max) {
+			delay = float64(p.Max)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		delay = delay * (1 - p.Jitter + 2*p.Jitter*rand.Float64())
+	}
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+	return time.Duration(delay)
+}
+
+// Retry wraps a transformation so that it is retried, with exponential
+// backoff and jitter, when it fails.
+func Retry[S any, T any](xform X[S, T], policy RetryPolicy) X[S, T] {
+	return Bind(context.Background(), RetryContext(Lift(xform), policy))
+}
+
+// RetryContext is the context-aware version of Retry; waiting between
+// attempts is interrupted when the context is done.
+func RetryContext[S any, T any](xform XC[S, T], policy RetryPolicy) XC[S, T] {
+	if policy.Attempts <= 0 {
+		policy.Attempts = 3
+	}
+	if policy.Initial <= 0 {
+		policy.Initial = 100 * time.Millisecond
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = 2
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = transient
+	}
+	return func(ctx context.Context, s S) (T, error) {
+		var (
+			t   T
+			err error
+		)
+		for attempt := 1; ; attempt++ {
+			t, err = xform(ctx, s)
+			if err == nil || !policy.Retryable(err) {
+				return t, err
+			}
+			if attempt >= policy.Attempts {
+				slog.Warn("all attempts failed", "attempts", attempt, "error", err)
+				if m := policy.Metrics; m != nil {
+					m.exhausted.Add(1)
+				}
+				return t, err
+			}
+			delay := policy.delay(attempt)
+			slog.Debug("transformation failed, retrying", "attempt", attempt, "delay", delay, "error", err)
+			if m := policy.Metrics; m != nil {
+				m.retries.Add(1)
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return t, ctx.Err()
+			}
+		}
+	}
+}
+
+// Timeout wraps a transformation so that it fails with ErrTimeout if it does
+// not complete within the given duration. A plain transformation cannot be
+// stopped, so it is left running in the background when it times out, and
+// its result is discarded: inside Retry, it may overlap with the next
+// attempt. Use TimeoutContext with transformations that can be interrupted.
+func Timeout[S any, T any](xform X[S, T], timeout time.Duration, metrics ...*Metrics) X[S, T] {
+	return Bind(context.Background(), TimeoutContext(abandon(xform), timeout, metrics...))
+}
+
+// abandon lifts a plain transformation into a context-aware one that stops
+// waiting for it, and returns the context's error, when the context is done.
+func abandon[S any, T any](xform X[S, T]) XC[S, T] {
+	type result struct {
+		t   T
+		err error
+	}
+	return func(ctx context.Context, s S) (T, error) {
+		done := make(chan result, 1)
+		go func() {
+			t, err := xform(s)
+			done <- result{t, err}
+		}()
+		select {
+		case r := <-done:
+			return r.t, r.err
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// TimeoutContext wraps a transformation so that it fails with ErrTimeout if
+// it does not complete within the given duration: the transformation receives
+// a context with the given deadline, and it must return as soon as the
+// context is done, as it is waited for. Even so, a side effect that was under
+// way when the deadline expired (e.g. a write on a socket) may still take
+// place, so a retried side effect may happen more than once.
+func TimeoutContext[S any, T any](xform XC[S, T], timeout time.Duration, metrics ...*Metrics) XC[S, T] {
+	return func(ctx context.Context, s S) (T, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		t, err := xform(ctx, s)
+		if errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			slog.Warn("transformation timed out", "timeout", timeout)
+			for _, m := range metrics {
+				if m != nil {
+					m.timeouts.Add(1)
+				}
+			}
+			err = ErrTimeout
+		}
+		return t, err
+	}
+}
+
+// BreakerConfig describes when a circuit breaker opens and closes.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures after which the circuit
+	// opens; if not set, it is 5.
+	Failures int
+	// Cooldown is the time the circuit stays open before a single value is
+	// let through to probe whether the transformation has recovered; if
+	// not set, it is 30s.
+	Cooldown time.Duration
+	// IsFailure decides whether an error counts as a failure; if not set,
+	// all errors except Drop, Quit and context cancellation do.
+	IsFailure func(error) bool
+	// Metrics, if set, collects the number of trips and of values rejected
+	// while the circuit is open.
+	Metrics *Metrics
+}
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	halfOpen
+)
+
+// Breaker wraps a transformation with a circuit breaker: after a number of
+// consecutive failures the circuit opens and values fail immediately with
+// ErrOpen, so that a failing side effect does not stall the chain; after a
+// cooldown, one value is let through, and if it succeeds the circuit closes.
+func Breaker[S any, T any](xform X[S, T], config BreakerConfig) X[S, T] {
+	return Bind(context.Background(), BreakerContext(Lift(xform), config))
+}
+
+// BreakerContext is the context-aware version of Breaker.
+func BreakerContext[S any, T any](xform XC[S, T], config BreakerConfig) XC[S, T] {
+	if config.Failures <= 0 {
+		config.Failures = 5
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 30 * time.Second
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = transient
+	}
+	var (
+		lock     sync.Mutex
+		state    = closed
+		failures = 0
+		opened   time.Time
+	)
+	return func(ctx context.Context, s S) (T, error) {
+		lock.Lock()
+		switch state {
+		case open:
+			if time.Since(opened) < config.Cooldown {
+				lock.Unlock()
+				if m := config.Metrics; m != nil {
+					m.shortCircuits.Add(1)
+				}
+				var t T
+				return t, ErrOpen
+			}
+			slog.Info("circuit breaker half-open, probing")
+			state = halfOpen
+		case halfOpen:
+			// a probe is already in flight
+			lock.Unlock()
+			if m := config.Metrics; m != nil {
+				m.shortCircuits.Add(1)
+			}
+			var t T
+			return t, ErrOpen
+		}
+		lock.Unlock()
+
+		t, err := xform(ctx, s)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if config.IsFailure(err) {
+			failures++
+			if state == halfOpen || failures >= config.Failures {
+				slog.Warn("circuit breaker open", "failures", failures, "cooldown", config.Cooldown, "error", err)
+				state = open
+				opened = time.Now()
+				if m := config.Metrics; m != nil {
+					m.trips.Add(1)
+				}
+			}
+		} else {
+			if state == halfOpen {
+				slog.Info("circuit breaker closed")
+			}
+			state = closed
+			failures = 0
+		}
+		return t, err
+	}
+}
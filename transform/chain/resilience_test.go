@@ -0,0 +1,176 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dihedron/snoop/test"
+)
+
+// flaky fails the first n calls, then succeeds.
+func flaky(n int64) (X[int, int], *atomic.Int64) {
+	calls := &atomic.Int64{}
+	return func(i int) (int, error) {
+		if calls.Add(1) <= n {
+			return i, errors.New("transient failure")
+		}
+		return i * 2, nil
+	}, calls
+}
+
+func TestRetry(t *testing.T) {
+	test.Setup(t)
+
+	metrics := &Metrics{}
+	xform, calls := flaky(2)
+	retry := Retry(xform, RetryPolicy{Attempts: 3, Initial: time.Millisecond, Jitter: 0.5, Metrics: metrics})
+	if v, err := retry(21); err != nil || v != 42 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	if calls.Load() != 3 || metrics.Snapshot().Retries != 2 {
+		t.Fatalf("unexpected calls (%d) or metrics (%+v)", calls.Load(), metrics.Snapshot())
+	}
+
+	xform, _ = flaky(10)
+	retry = Retry(xform, RetryPolicy{Attempts: 3, Initial: time.Millisecond, Metrics: metrics})
+	if _, err := retry(21); err == nil {
+		t.Fatal("expected error when attempts are exhausted")
+	}
+	if snapshot := metrics.Snapshot(); snapshot.Exhausted != 1 || snapshot.Retries != 4 {
+		t.Fatalf("unexpected metrics: %+v", snapshot)
+	}
+
+	// Drop is not retried
+	dropped := 0
+	retry = Retry(func(i int) (int, error) { dropped++; return i, Drop }, RetryPolicy{Initial: time.Millisecond})
+	if _, err := retry(1); !errors.Is(err, Drop) || dropped != 1 {
+		t.Fatalf("expected a single attempt returning Drop, got %d attempts, %v", dropped, err)
+	}
+
+	policy := RetryPolicy{Initial: 10 * time.Millisecond, Multiplier: 2, Max: 50 * time.Millisecond}
+	for retry, expected := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 4: 50 * time.Millisecond, 10: 50 * time.Millisecond} {
+		if delay := policy.delay(retry); delay != expected {
+			t.Fatalf("unexpected delay for retry %d: %s", retry, delay)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	test.Setup(t)
+
+	metrics := &Metrics{}
+	stopped := make(chan struct{}, 1)
+	slow := func(ctx context.Context, i int) (int, error) {
+		select {
+		case <-time.After(time.Duration(i) * time.Millisecond):
+			return i, nil
+		case <-ctx.Done():
+			stopped <- struct{}{}
+			return i, ctx.Err()
+		}
+	}
+	xform := Bind(context.Background(), TimeoutContext(slow, 50*time.Millisecond, metrics))
+	if v, err := xform(1); err != nil || v != 1 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	start := time.Now()
+	if _, err := xform(5000); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("timeout did not stop waiting")
+	}
+	// the transformation has returned by the time the timeout is reported,
+	// so a retry cannot overlap with it
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("transformation still running")
+	}
+	if metrics.Snapshot().Timeouts != 1 {
+		t.Fatalf("unexpected metrics: %+v", metrics.Snapshot())
+	}
+
+	// plain transformations are not waited for once they time out
+	release := make(chan struct{})
+	defer close(release)
+	blocking := Timeout(func(i int) (int, error) {
+		if i > 1 {
+			<-release
+		}
+		return i, nil
+	}, 50*time.Millisecond, metrics)
+	if v, err := blocking(1); err != nil || v != 1 {
+		t.Fatalf("unexpected result: %d, %v", v, err)
+	}
+	start = time.Now()
+	if _, err := blocking(2); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("timeout did not stop waiting")
+	}
+	if metrics.Snapshot().Timeouts != 2 {
+		t.Fatalf("unexpected metrics: %+v", metrics.Snapshot())
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	test.Setup(t)
+
+	metrics := &Metrics{}
+	var (
+		lock    sync.Mutex
+		failing = true
+		calls   = 0
+	)
+	endpoint := func(i int) (int, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		if failing {
+			return i, errors.New("endpoint down")
+		}
+		return i, nil
+	}
+	xform := Breaker(endpoint, BreakerConfig{Failures: 3, Cooldown: 50 * time.Millisecond, Metrics: metrics})
+	for range 3 {
+		if _, err := xform(1); err == nil || errors.Is(err, ErrOpen) {
+			t.Fatalf("expected endpoint failure, got %v", err)
+		}
+	}
+	// the circuit is now open: values fail fast without calling the endpoint
+	for range 10 {
+		if _, err := xform(1); !errors.Is(err, ErrOpen) {
+			t.Fatalf("expected ErrOpen, got %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls to the endpoint, got %d", calls)
+	}
+	// after the cooldown, a failed probe re-opens the circuit
+	time.Sleep(60 * time.Millisecond)
+	if _, err := xform(1); err == nil || errors.Is(err, ErrOpen) {
+		t.Fatalf("expected probe failure, got %v", err)
+	}
+	if _, err := xform(1); !errors.Is(err, ErrOpen) {
+		t.Fatalf("expected ErrOpen after failed probe, got %v", err)
+	}
+	// after the cooldown, a successful probe closes the circuit
+	lock.Lock()
+	failing = false
+	lock.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	for range 5 {
+		if _, err := xform(1); err != nil {
+			t.Fatalf("expected success, got %v", err)
+		}
+	}
+	if snapshot := metrics.Snapshot(); snapshot.Trips != 2 || snapshot.ShortCircuits != 11 {
+		t.Fatalf("unexpected metrics: %+v", snapshot)
+	}
+}
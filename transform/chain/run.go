@@ -0,0 +1,193 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"time"
+)
+
+// Sink is the final consumer of the values produced by a transformation
+// chain in a Run; it can return Drop and Quit just like transformations.
+type Sink[T any] func(T) error
+
+// Discard is a Sink that ignores all values.
+func Discard[T any]() Sink[T] {
+	return func(T) error {
+		return nil
+	}
+}
+
+// Stats contains the statistics of a Run.
+type Stats struct {
+	// Read is the number of values read from the input sequence.
+	Read int64 `json:"read" yaml:"read"`
+	// Written is the number of values successfully delivered to the sink.
+	Written int64 `json:"written" yaml:"written"`
+	// Dropped is the number of values dropped by the chain or the sink.
+	Dropped int64 `json:"dropped" yaml:"dropped"`
+	// Failed is the number of values whose processing failed.
+	Failed int64 `json:"failed" yaml:"failed"`
+	// Quit is true if the run was stopped by a Quit.
+	Quit bool `json:"quit" yaml:"quit"`
+	// Cancelled is true if the run was stopped by the context.
+	Cancelled bool `json:"cancelled" yaml:"cancelled"`
+	// Elapsed is the duration of the run.
+	Elapsed time.Duration `json:"elapsed" yaml:"elapsed"`
+}
+
+// RunOption is a functional option type that allows us to configure a Run.
+type RunOption[S any] func(*runner[S])
+
+type runner[S any] struct {
+	onError func(S, error) error
+	onDone  func(S, error)
+	limit   int64
+	workers int
+	ordered bool
+}
+
+// WithErrorHandler sets the function that is called with the input value and
+// the error whenever processing fails with an error other than Drop or Quit;
+// if the handler returns an error, the run stops and returns that error. By
+// default errors are logged and the run continues.
+func WithErrorHandler[S any](handler func(S, error) error) RunOption[S] {
+	return func(r *runner[S]) {
+		if handler != nil {
+			r.onError = handler
+		}
+	}
+}
+
+// WithDone sets a function that is called for every input value once it has
+// been processed, with the outcome of the processing: nil if it reached the
+// sink, Drop, Quit or the error; it can be used e.g. to acknowledge messages.
+func WithDone[S any](done func(S, error)) RunOption[S] {
+	return func(r *runner[S]) {
+		r.onDone = done
+	}
+}
+
+// WithLimit stops the run after the given number of input values has been
+// read; a value of 0 means no limit.
+func WithLimit[S any](limit int) RunOption[S] {
+	return func(r *runner[S]) {
+		if limit > 0 {
+			r.limit = int64(limit)
+		}
+	}
+}
+
+// WithWorkers applies the transformation on the given number of goroutines
+// (see Parallel); if ordered is true, the values reach the sink in the same
+// order as they are read, otherwise as soon as they are ready. The sink, the
+// error handler and the completion function are always called sequentially.
+func WithWorkers[S any](workers int, ordered bool) RunOption[S] {
+	return func(r *runner[S]) {
+		r.workers = workers
+		r.ordered = ordered
+	}
+}
+
+// Run drives the values in the input sequence through the transformation and
+// into the sink: values for which the transformation (or the sink) returns
+// Drop are skipped silently, a Quit stops the run cleanly, other errors are
+// sent to the error handler. The run also stops when the context is done,
+// including when a context-aware transformation returns the context's error.
+// It returns the statistics of the run, and an error only if the error handler
+// requested to stop.
+func Run[S any, T any](ctx context.Context, values iter.Seq[S], xform X[S, T], sink Sink[T], options ...RunOption[S]) (Stats, error) {
+	r := &runner[S]{
+		onError: func(_ S, err error) error {
+			slog.Error("error processing value", "error", err)
+			return nil
+		},
+	}
+	for _, option := range options {
+		option(r)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if sink == nil {
+		sink = Discard[T]()
+	}
+
+	stats := Stats{}
+	start := time.Now()
+
+	if values == nil {
+		return stats, nil
+	}
+	if r.limit > 0 {
+		values = take(values, r.limit)
+	}
+	stream := Sequential(xform)
+	if r.workers > 1 {
+		stream = Parallel(r.workers, xform, r.ordered)
+	}
+	for result := range stream(ctx, values) {
+		// a value that has been read is always processed, so that it can
+		// be settled (e.g. acknowledged) even if the context is done
+		stats.Read++
+		s, t, err := result.Input, result.Output, result.Err
+		if err == nil {
+			err = sink(t)
+		}
+		if r.onDone != nil {
+			r.onDone(s, err)
+		}
+		switch {
+		case err == nil:
+			stats.Written++
+		case errors.Is(err, Drop):
+			stats.Dropped++
+		case ctx.Err() != nil && errors.Is(err, ctx.Err()):
+			// a context-aware transformation was interrupted
+			slog.Debug("transformation interrupted by context, stopping run")
+			stats.Cancelled = true
+			stats.Elapsed = time.Since(start)
+			return stats, nil
+		case errors.Is(err, Quit):
+			slog.Debug("chain requested to quit, stopping run")
+			stats.Quit = true
+			stats.Elapsed = time.Since(start)
+			return stats, nil
+		default:
+			stats.Failed++
+			if err := r.onError(s, err); err != nil {
+				slog.Error("error handler requested to stop run", "error", err)
+				stats.Elapsed = time.Since(start)
+				return stats, err
+			}
+		}
+		if ctx.Err() != nil {
+			slog.Debug("context done, stopping run")
+			stats.Cancelled = true
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		stats.Cancelled = true
+	}
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// take returns a sequence that stops after the given number of values.
+func take[S any](values iter.Seq[S], limit int64) iter.Seq[S] {
+	return func(yield func(S) bool) {
+		count := int64(0)
+		for s := range values {
+			if !yield(s) {
+				return
+			}
+			count++
+			if count >= limit {
+				slog.Debug("limit reached, stopping run", "limit", limit)
+				return
+			}
+		}
+	}
+}
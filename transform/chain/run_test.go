@@ -0,0 +1,93 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/dihedron/snoop/test"
+)
+
+func TestRun(t *testing.T) {
+	test.Setup(t)
+
+	failure := errors.New("failure")
+	xform := func(i int) (int, error) {
+		switch {
+		case i == 7:
+			return i, failure
+		case i%2 != 0:
+			return i, Drop
+		case i == 12:
+			return i, Quit
+		}
+		return i * 10, nil
+	}
+	output := []int{}
+	sink := func(i int) error {
+		output = append(output, i)
+		return nil
+	}
+	failed := []int{}
+	done := 0
+	stats, err := Run(context.Background(), slices.Values([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}), xform, sink,
+		WithErrorHandler(func(i int, err error) error {
+			if !errors.Is(err, failure) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			failed = append(failed, i)
+			return nil
+		}),
+		WithDone(func(int, error) { done++ }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(output, []int{0, 20, 40, 60, 80, 100}) {
+		t.Fatalf("unexpected output: %v", output)
+	}
+	if !slices.Equal(failed, []int{7}) {
+		t.Fatalf("unexpected failures: %v", failed)
+	}
+	if stats.Read != 13 || stats.Written != 6 || stats.Dropped != 5 || stats.Failed != 1 || !stats.Quit || stats.Cancelled {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if done != 13 {
+		t.Fatalf("expected 13 completions, got %d", done)
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	test.Setup(t)
+
+	failure := errors.New("failure")
+	xform := func(i int) (int, error) {
+		if i == 3 {
+			return i, failure
+		}
+		return i, nil
+	}
+	stats, err := Run(context.Background(), slices.Values([]int{0, 1, 2, 3, 4, 5}), xform, nil,
+		WithErrorHandler(func(_ int, err error) error { return err }),
+	)
+	if !errors.Is(err, failure) || stats.Read != 4 || stats.Written != 3 {
+		t.Fatalf("unexpected result: %+v, %v", stats, err)
+	}
+
+	stats, err = Run(context.Background(), slices.Values([]int{0, 1, 2, 3, 4, 5}), Of(func(i int) (int, error) { return i, nil }), nil, WithLimit[int](2))
+	if err != nil || stats.Read != 2 {
+		t.Fatalf("unexpected result with limit: %+v, %v", stats, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stats, err = Run(ctx, slices.Values([]int{0, 1, 2, 3, 4, 5}), func(i int) (int, error) {
+		if i == 1 {
+			cancel()
+		}
+		return i, nil
+	}, nil)
+	if err != nil || stats.Read != 2 || !stats.Cancelled {
+		t.Fatalf("unexpected result on cancellation: %+v, %v", stats, err)
+	}
+}
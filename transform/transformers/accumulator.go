@@ -1,10 +1,16 @@
 package transformers
 
 import (
+	"slices"
+	"sync"
+
 	"github.com/dihedron/snoop/transform/chain"
 )
 
+// Accumulator holds the values flowing through the chain. It is safe
+// for concurrent use.
 type Accumulator[T any] struct {
+	lock   sync.RWMutex
 	values []T
 }
 
@@ -12,12 +18,26 @@ type Accumulator[T any] struct {
 // buffer. This filter does not affect the value flowing through.
 func (a *Accumulator[T]) Add() chain.X[T, T] {
 	return func(value T) (T, error) {
+		a.lock.Lock()
+		defer a.lock.Unlock()
 		a.values = append(a.values, value)
 		return value, nil
 	}
 }
 
-// Values returns the accumulated values
+// Values returns a snapshot of the accumulated values.
 func (a *Accumulator[T]) Values() []T {
-	return a.values
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return slices.Clone(a.values)
+}
+
+// Reset returns the accumulated values and empties the buffer,
+// atomically, so that no values added in the meantime are lost.
+func (a *Accumulator[T]) Reset() []T {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	values := a.values
+	a.values = nil
+	return values
 }
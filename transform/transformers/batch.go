@@ -0,0 +1,163 @@
+package transformers
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"time"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Batcher groups the values flowing through the chain into slices of at
+// most a given size; a batch is also emitted when its oldest value has been
+// waiting for longer than a given time, so that a slow stream does not hold
+// values back indefinitely.
+type Batcher[T any] struct {
+	size    int
+	maxWait time.Duration
+	lock    sync.Mutex
+	values  []T
+	first   time.Time
+}
+
+// Batch returns a Batcher that emits batches of up to size values, or the
+// values collected so far when the oldest of them has been waiting for more
+// than maxWait; if maxWait is 0, batches are emitted only when full.
+func Batch[T any](size int, maxWait time.Duration) *Batcher[T] {
+	if size <= 0 {
+		size = 1
+	}
+	return &Batcher[T]{
+		size:    size,
+		maxWait: maxWait,
+	}
+}
+
+// Add adds the value flowing into the transformer to the current batch; if
+// the batch is full, or its oldest value has been waiting for too long, the
+// batch is returned, otherwise the value is dropped from the chain. Since
+// the wait is only checked when a value arrives, call Flush at the end of
+// the stream, or use Seq to emit batches on time even when no values arrive.
+func (b *Batcher[T]) Add() chain.X[T, []T] {
+	return func(value T) ([]T, error) {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		b.add(value)
+		if len(b.values) >= b.size || b.expired(time.Now()) {
+			return b.flush(), nil
+		}
+		return nil, chain.Drop
+	}
+}
+
+// Flush returns the values in the current batch, if any, and starts a new
+// one.
+func (b *Batcher[T]) Flush() []T {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.flush()
+}
+
+// Seq reads the values from the given sequence and returns a sequence of
+// batches; unlike Add, batches are emitted when their maximum wait expires
+// even if no new values arrive, and the last partial batch is emitted when
+// the input sequence ends. The input sequence is read on a separate
+// goroutine, which exits when the context is done or the input ends.
+func (b *Batcher[T]) Seq(ctx context.Context, values iter.Seq[T]) iter.Seq[[]T] {
+	return func(yield func([]T) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		input := make(chan T)
+		go func() {
+			defer close(input)
+			for value := range values {
+				select {
+				case input <- value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+		emit := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			b.lock.Lock()
+			batch := b.flush()
+			b.lock.Unlock()
+			if len(batch) == 0 {
+				return true
+			}
+			return yield(batch)
+		}
+		for {
+			select {
+			case value, ok := <-input:
+				if !ok {
+					emit()
+					return
+				}
+				b.lock.Lock()
+				b.add(value)
+				full := len(b.values) >= b.size
+				started := len(b.values) == 1
+				b.lock.Unlock()
+				if full {
+					if !emit() {
+						return
+					}
+				} else if started && b.maxWait > 0 {
+					timer = time.NewTimer(b.maxWait)
+					timeout = timer.C
+				}
+			case <-timeout:
+				if !emit() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// add appends the value to the current batch; it must be called with the
+// lock held.
+func (b *Batcher[T]) add(value T) {
+	if len(b.values) == 0 {
+		b.values = make([]T, 0, b.size)
+		b.first = time.Now()
+	}
+	b.values = append(b.values, value)
+}
+
+// expired returns whether the oldest value in the current batch has been
+// waiting for longer than the maximum wait; it must be called with the lock
+// held.
+func (b *Batcher[T]) expired(now time.Time) bool {
+	return b.maxWait > 0 && len(b.values) > 0 && now.Sub(b.first) >= b.maxWait
+}
+
+// flush returns the current batch and starts a new one; it must be called
+// with the lock held.
+func (b *Batcher[T]) flush() []T {
+	if len(b.values) == 0 {
+		return nil
+	}
+	values := b.values
+	b.values = nil
+	return values
+}
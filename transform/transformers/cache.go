@@ -1,10 +1,17 @@
 package transformers
 
-import "github.com/dihedron/snoop/transform/chain"
+import (
+	"maps"
+	"sync"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
 
 // Cache holds a map into which values can be accumulated under
-// keys dynamically computed through a user-provided function.
+// keys dynamically computed through a user-provided function. It
+// is safe for concurrent use.
 type Cache[K comparable, T any] struct {
+	lock  sync.RWMutex
 	cache map[K]T
 }
 
@@ -14,6 +21,8 @@ type Cache[K comparable, T any] struct {
 func (c *Cache[K, T]) Set(keyer func(value T) K) chain.X[T, T] {
 	return func(value T) (T, error) {
 		key := keyer(value)
+		c.lock.Lock()
+		defer c.lock.Unlock()
 		if c.cache == nil {
 			c.cache = map[K]T{}
 		}
@@ -22,7 +31,27 @@ func (c *Cache[K, T]) Set(keyer func(value T) K) chain.X[T, T] {
 	}
 }
 
-// Contents returns the contents of the cache.
+// Get returns the item cached under the given key, if any.
+func (c *Cache[K, T]) Get(key K) (T, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	value, ok := c.cache[key]
+	return value, ok
+}
+
+// Contents returns a snapshot of the contents of the cache.
 func (c *Cache[K, T]) Contents() map[K]T {
-	return c.cache
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return maps.Clone(c.cache)
+}
+
+// Reset returns the contents of the cache and empties it, atomically,
+// so that no items added in the meantime are lost.
+func (c *Cache[K, T]) Reset() map[K]T {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cache := c.cache
+	c.cache = nil
+	return cache
 }
@@ -2,16 +2,18 @@ package transformers
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/dihedron/snoop/transform/chain"
 )
 
 // Counter holds the state needed to count items across multiple
 // invocations of the chain. If Every is specified and more than
-// 0, it will print a dot to STDOUT every count%Every values
+// 0, it will print a dot to STDOUT every count%Every values. It
+// is safe for concurrent use.
 type Counter[T any] struct {
 	Every int64
-	count int64
+	count atomic.Int64
 }
 
 // Add adds 1 to the count of items flowing through the chain. This
@@ -26,10 +28,7 @@ func (c *Counter[T]) Add() chain.X[T, T] {
 func (c *Counter[T]) AddIf(condition func(value T) bool) chain.X[T, T] {
 	return func(value T) (T, error) {
 		if condition(value) {
-			c.count = c.count + 1
-			if c.Every > 0 && c.count%c.Every == 0 {
-				fmt.Printf(". ")
-			}
+			c.increment()
 		}
 		return value, nil
 	}
@@ -41,10 +40,7 @@ func (c *Counter[T]) AddIf(condition func(value T) bool) chain.X[T, T] {
 func (c *Counter[T]) AddUnless(condition func(value T) bool) chain.X[T, T] {
 	return func(value T) (T, error) {
 		if !condition(value) {
-			c.count = c.count + 1
-			if c.Every > 0 && c.count%c.Every == 0 {
-				fmt.Printf(". ")
-			}
+			c.increment()
 		}
 		return value, nil
 	}
@@ -52,5 +48,18 @@ func (c *Counter[T]) AddUnless(condition func(value T) bool) chain.X[T, T] {
 
 // Count returns the count of items.
 func (c *Counter[T]) Count() int64 {
-	return c.count
+	return c.count.Load()
+}
+
+// Reset returns the count of items and sets it back to 0, atomically, so
+// that no items counted in the meantime are lost.
+func (c *Counter[T]) Reset() int64 {
+	return c.count.Swap(0)
+}
+
+func (c *Counter[T]) increment() {
+	count := c.count.Add(1)
+	if c.Every > 0 && count%c.Every == 0 {
+		fmt.Printf(". ")
+	}
 }
@@ -0,0 +1,210 @@
+package transformers
+
+import (
+	"container/list"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Identified is implemented by values that have an identifier which is
+// the same across redeliveries and duplicates (e.g. OpenStack notifications,
+// whose ID is the oslo message ID).
+type Identified interface {
+	ID() string
+}
+
+// Deduplicator drops the values flowing through the chain that have already
+// been seen, as identified by a key; it remembers a bounded number of keys,
+// for a limited time, evicting the least recently seen ones first. It is
+// safe for concurrent use.
+type Deduplicator[T any] struct {
+	keyer      func(value T) string
+	ttl        time.Duration
+	maxEntries int
+	lock       sync.Mutex
+	entries    *list.List
+	index      map[string]*list.Element
+	duplicates int64
+}
+
+// entry is a key in the deduplicator, with the last time it was seen.
+type entry struct {
+	Key  string    `json:"key"`
+	Seen time.Time `json:"seen"`
+}
+
+// Dedup returns a Deduplicator that identifies values by the given keyer,
+// or by their ID if the keyer is nil and the values implement Identified;
+// keys are forgotten after ttl (if greater than 0) and at most maxEntries
+// keys (if greater than 0) are remembered.
+func Dedup[T any](keyer func(value T) string, ttl time.Duration, maxEntries int) *Deduplicator[T] {
+	if keyer == nil {
+		keyer = func(value T) string {
+			if v, ok := any(value).(Identified); ok {
+				return v.ID()
+			}
+			return ""
+		}
+	}
+	return &Deduplicator[T]{
+		keyer:      keyer,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    list.New(),
+		index:      map[string]*list.Element{},
+	}
+}
+
+// Filter drops the value flowing into the transformer if its key has been
+// seen before, otherwise it lets it through unchanged; values with an empty
+// key are always let through.
+func (d *Deduplicator[T]) Filter() chain.F[T] {
+	return func(value T) (T, error) {
+		key := d.keyer(value)
+		if key == "" {
+			return value, nil
+		}
+		if d.seen(key, time.Now()) {
+			slog.Debug("dropping duplicate value", "key", key)
+			return value, chain.Drop
+		}
+		return value, nil
+	}
+}
+
+// Duplicates returns the number of values dropped as duplicates.
+func (d *Deduplicator[T]) Duplicates() int64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.duplicates
+}
+
+// Len returns the number of keys currently remembered.
+func (d *Deduplicator[T]) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.entries.Len()
+}
+
+// Save writes the keys currently remembered to the given file, so that they
+// can be loaded after a restart.
+func (d *Deduplicator[T]) Save(path string) error {
+	d.lock.Lock()
+	entries := make([]entry, 0, d.entries.Len())
+	// oldest first, so that loading them in order rebuilds the same list
+	for e := d.entries.Back(); e != nil; e = e.Prev() {
+		entries = append(entries, *e.Value.(*entry))
+	}
+	d.lock.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		slog.Error("error marshalling deduplication state", "error", err)
+		return err
+	}
+	// write to a temporary file and rename it, so that the state is never
+	// left half-written
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		slog.Error("error creating deduplication state file", "path", path, "error", err)
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		slog.Error("error writing deduplication state file", "path", temp.Name(), "error", err)
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		slog.Error("error closing deduplication state file", "path", temp.Name(), "error", err)
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		slog.Error("error renaming deduplication state file", "from", temp.Name(), "to", path, "error", err)
+		return err
+	}
+	slog.Debug("deduplication state saved", "path", path, "entries", len(entries))
+	return nil
+}
+
+// Load reads the keys saved by Save from the given file, skipping those that
+// have expired in the meantime; a missing file is not an error.
+func (d *Deduplicator[T]) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			slog.Debug("no deduplication state to load", "path", path)
+			return nil
+		}
+		slog.Error("error reading deduplication state file", "path", path, "error", err)
+		return err
+	}
+	entries := []entry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		slog.Error("error unmarshalling deduplication state", "path", path, "error", err)
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	for _, e := range entries {
+		if d.ttl > 0 && now.Sub(e.Seen) >= d.ttl {
+			continue
+		}
+		d.add(e.Key, e.Seen)
+	}
+	d.evict(now)
+	slog.Debug("deduplication state loaded", "path", path, "entries", d.entries.Len())
+	return nil
+}
+
+// seen returns whether the key has been seen before and not yet forgotten,
+// and records it as seen now.
+func (d *Deduplicator[T]) seen(key string, now time.Time) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.evict(now)
+	if e, ok := d.index[key]; ok {
+		e.Value.(*entry).Seen = now
+		d.entries.MoveToFront(e)
+		d.duplicates++
+		return true
+	}
+	d.add(key, now)
+	d.evict(now)
+	return false
+}
+
+// add records the key as the most recently seen; it must be called with the
+// lock held.
+func (d *Deduplicator[T]) add(key string, seen time.Time) {
+	if e, ok := d.index[key]; ok {
+		e.Value.(*entry).Seen = seen
+		d.entries.MoveToFront(e)
+		return
+	}
+	d.index[key] = d.entries.PushFront(&entry{Key: key, Seen: seen})
+}
+
+// evict forgets the keys that have expired and, if there are too many, the
+// least recently seen ones; it must be called with the lock held.
+func (d *Deduplicator[T]) evict(now time.Time) {
+	for e := d.entries.Back(); e != nil; e = d.entries.Back() {
+		expired := d.ttl > 0 && now.Sub(e.Value.(*entry).Seen) >= d.ttl
+		full := d.maxEntries > 0 && d.entries.Len() > d.maxEntries
+		if !expired && !full {
+			return
+		}
+		delete(d.index, e.Value.(*entry).Key)
+		d.entries.Remove(e)
+	}
+}
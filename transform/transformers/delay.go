@@ -1,16 +1,144 @@
 package transformers
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/dihedron/snoop/transform/chain"
 )
 
-// Delay inserts a configurable delay inside the chain. This
-// filter does not affect the value flowing through.
-func Delay[T any](delay time.Duration) chain.X[T, T] {
-	return func(value T) (T, error) {
-		time.Sleep(delay)
-		return value, nil
+// Delay inserts a configurable delay inside the chain; the delay is
+// interrupted if the context is done, in which case the context's error is
+// returned. This filter does not affect the value flowing through.
+func Delay[T any](delay time.Duration) chain.FC[T] {
+	return func(ctx context.Context, value T) (T, error) {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return value, nil
+		case <-ctx.Done():
+			return value, ctx.Err()
+		}
 	}
 }
+
+// ReplayOption is the type for functional options to the Replayer.
+type ReplayOption func(*replayOptions)
+
+type replayOptions struct {
+	maxGap  time.Duration
+	startAt time.Time
+	offset  time.Duration
+}
+
+// WithMaxGap caps the time waited between two consecutive values, so that
+// quiet periods in the original stream do not stall the replay.
+func WithMaxGap(gap time.Duration) ReplayOption {
+	return func(o *replayOptions) {
+		if gap > 0 {
+			o.maxGap = gap
+		}
+	}
+}
+
+// WithStartAt skips the values older than the given time, so that the
+// replay starts from there.
+func WithStartAt(start time.Time) ReplayOption {
+	return func(o *replayOptions) {
+		o.startAt = start
+	}
+}
+
+// WithStartOffset skips the values within the given offset from the first
+// one, so that the replay starts from there.
+func WithStartOffset(offset time.Duration) ReplayOption {
+	return func(o *replayOptions) {
+		if offset > 0 {
+			o.offset = offset
+		}
+	}
+}
+
+// Replayer paces the values flowing through the chain so that they come out
+// with the same gaps as their timestamps, possibly sped up or slowed down;
+// the gaps are measured against the start of the replay, so delays do not
+// accumulate drift. Values with no timestamp, or older than the previous one,
+// are let through immediately. It is safe for concurrent use, but the values
+// must be paced in order, i.e. on a single goroutine.
+type Replayer[T any] struct {
+	timestamp func(value T) time.Time
+	speed     float64
+	maxGap    time.Duration
+	startAt   time.Time
+	offset    time.Duration
+	lock      sync.Mutex
+	start     time.Time
+	last      time.Time
+	due       time.Duration
+	skipped   int64
+}
+
+// Replay returns a Replayer that paces the values according to the
+// timestamps returned by the given function, at the given speed (e.g. 10
+// replays ten times faster than real time); a speed of 0 or less replays
+// as fast as possible, still honouring the starting point.
+func Replay[T any](timestamp func(value T) time.Time, speed float64, options ...ReplayOption) *Replayer[T] {
+	o := &replayOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return &Replayer[T]{
+		timestamp: timestamp,
+		speed:     speed,
+		maxGap:    o.maxGap,
+		startAt:   o.startAt,
+		offset:    o.offset,
+	}
+}
+
+// Pace waits until it is time for the value flowing into the transformer to
+// be let through, or the context is done; values before the starting point
+// are dropped. This filter does not affect the value flowing through.
+func (r *Replayer[T]) Pace() chain.FC[T] {
+	return func(ctx context.Context, value T) (T, error) {
+		timestamp := r.timestamp(value)
+		r.lock.Lock()
+		if !timestamp.IsZero() && r.startAt.IsZero() && r.offset > 0 {
+			r.startAt = timestamp.Add(r.offset)
+		}
+		if !timestamp.IsZero() && timestamp.Before(r.startAt) {
+			r.skipped++
+			r.lock.Unlock()
+			return value, chain.Drop
+		}
+		if r.start.IsZero() {
+			r.start = time.Now()
+		}
+		if r.speed > 0 && !timestamp.IsZero() && timestamp.After(r.last) {
+			if !r.last.IsZero() {
+				gap := time.Duration(float64(timestamp.Sub(r.last)) / r.speed)
+				if r.maxGap > 0 && gap > r.maxGap {
+					gap = r.maxGap
+				}
+				r.due += gap
+			}
+			r.last = timestamp
+		}
+		wait := time.Until(r.start.Add(r.due))
+		r.lock.Unlock()
+		if wait <= 0 {
+			return value, ctx.Err()
+		}
+		return Delay[T](wait)(ctx, value)
+	}
+}
+
+// Skipped returns the number of values dropped because they were before the
+// starting point.
+func (r *Replayer[T]) Skipped() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.skipped
+}
@@ -3,6 +3,7 @@ package transformers
 import (
 	"bytes"
 	"log/slog"
+	"strings"
 
 	"text/template"
 
@@ -12,29 +13,72 @@ import (
 	"github.com/dihedron/snoop/transform/chain"
 )
 
+// Format applies the given template to the value flowing into the
+// transformer and returns the result; if the template is invalid, it
+// returns nil (see Template).
 func Format[T any](format string) chain.X[T, string] {
-	var buffer bytes.Buffer
-
-	// populate the functions map
-	functions := template.FuncMap{}
-	for k, v := range templating.FuncMap() {
-		functions[k] = v
-	}
-	for k, v := range sprig.FuncMap() {
-		functions[k] = v
+	xform, err := Template[T](format)
+	if err != nil {
+		return nil
 	}
+	return xform
+}
 
-	template, err := template.New(format).Funcs(functions).Parse(format)
+// Template is like Format, but it returns an error if the template is
+// invalid.
+func Template[T any](text string) (chain.X[T, string], error) {
+	template, err := template.New("template").Funcs(functions()).Parse(text)
 	if err != nil {
 		slog.Error("invalid template", "error", err)
-		return nil
+		return nil, err
 	}
 	return func(value T) (string, error) {
-		err = template.Execute(&buffer, value)
-		if err != nil {
+		var buffer bytes.Buffer
+		if err := template.Execute(&buffer, value); err != nil {
 			slog.Error("error applying template", "object", value, "type", f.TypeAsString(value), "error", err)
 			return "", err
 		}
 		return buffer.String(), nil
+	}, nil
+}
+
+// Match lets the value flow if the given condition is true, and drops it
+// otherwise; the condition is a template pipeline evaluated against the
+// value, with the same functions as Format, e.g.
+//
+//	hasPrefix "compute.instance." .EventType
+//
+// A condition that cannot be evaluated on a value (e.g. because the field
+// does not exist) is false. This filter does not affect the value flowing
+// through.
+func Match[T any](condition string) (chain.F[T], error) {
+	template, err := template.New("condition").Funcs(functions()).Parse("{{ if " + condition + " }}true{{ end }}")
+	if err != nil {
+		slog.Error("invalid condition", "condition", condition, "error", err)
+		return nil, err
+	}
+	return func(value T) (T, error) {
+		var buffer strings.Builder
+		if err := template.Execute(&buffer, value); err != nil {
+			slog.Debug("error evaluating condition", "condition", condition, "type", f.TypeAsString(value), "error", err)
+			return value, chain.Drop
+		}
+		if buffer.String() != "true" {
+			return value, chain.Drop
+		}
+		return value, nil
+	}, nil
+}
+
+// functions returns the functions available to templates: the snoop ones
+// and those from sprig.
+func functions() template.FuncMap {
+	functions := template.FuncMap{}
+	for k, v := range templating.FuncMap() {
+		functions[k] = v
+	}
+	for k, v := range sprig.FuncMap() {
+		functions[k] = v
 	}
+	return functions
 }
@@ -1,12 +1,18 @@
 package transformers
 
-import "github.com/dihedron/snoop/transform/chain"
+import (
+	"slices"
+	"sync"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
 
 // MultiCache holds a map into which values can be accumulated under
 // keys dynamically computed through a user-provided function applied
 // to the value itself; if multiple values fall under the same key,
-// they are appended to a list.
+// they are appended to a list. It is safe for concurrent use.
 type MultiCache[K comparable, T any] struct {
+	lock  sync.RWMutex
 	cache map[K][]T
 }
 
@@ -16,6 +22,8 @@ type MultiCache[K comparable, T any] struct {
 func (c *MultiCache[K, T]) Set(keyer func(value T) K) chain.X[T, T] {
 	return func(value T) (T, error) {
 		key := keyer(value)
+		c.lock.Lock()
+		defer c.lock.Unlock()
 		if c.cache == nil {
 			c.cache = map[K][]T{}
 		}
@@ -24,7 +32,36 @@ func (c *MultiCache[K, T]) Set(keyer func(value T) K) chain.X[T, T] {
 	}
 }
 
-// Contents returns the contents of the cache.
+// Get returns a copy of the items cached under the given key, if any.
+func (c *MultiCache[K, T]) Get(key K) []T {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return slices.Clone(c.cache[key])
+}
+
+// Contents returns a snapshot of the contents of the cache.
 func (c *MultiCache[K, T]) Contents() map[K][]T {
-	return c.cache
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.cache == nil {
+		return nil
+	}
+	// the lists are copied, so that changing them in the snapshot does not
+	// affect the cache, and appending to the cache does not race with
+	// reading them
+	cache := make(map[K][]T, len(c.cache))
+	for k, v := range c.cache {
+		cache[k] = slices.Clone(v)
+	}
+	return cache
+}
+
+// Reset returns the contents of the cache and empties it, atomically,
+// so that no items added in the meantime are lost.
+func (c *MultiCache[K, T]) Reset() map[K][]T {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cache := c.cache
+	c.cache = nil
+	return cache
 }
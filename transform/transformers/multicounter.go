@@ -2,15 +2,20 @@ package transformers
 
 import (
 	"fmt"
+	"maps"
+	"sync"
 
 	"github.com/dihedron/snoop/transform/chain"
 )
 
 // MultiCounter holds the state needed to group items and count them
 // across multiple invocations of the chain. If Every is specified and
-// more than 0, it will print a dot to STDOUT every total%Every values
+// more than 0, it will print a dot to STDOUT every total%Every values.
+// It is safe for concurrent use, and multiple transformers created by
+// the same MultiCounter share the same counts.
 type MultiCounter[T any, K comparable] struct {
 	Every  int64
+	lock   sync.Mutex
 	counts map[K]int64
 	total  int64
 }
@@ -25,19 +30,9 @@ func (c *MultiCounter[T, K]) Add(keyer func(value T) K) chain.X[T, T] {
 // the given condition returns true. This filter does not affect the
 // value flowing through.
 func (c *MultiCounter[T, K]) AddIf(keyer func(value T) K, condition func(value T) bool) chain.X[T, T] {
-	c.counts = map[K]int64{}
 	return func(value T) (T, error) {
 		if condition(value) {
-			k := keyer(value)
-			if _, ok := c.counts[k]; !ok {
-				c.counts[k] = 1
-			} else {
-				c.counts[k] = c.counts[k] + 1
-			}
-			c.total = c.total + 1
-			if c.Every > 0 && c.total%c.Every == 0 {
-				fmt.Printf(". ")
-			}
+			c.increment(keyer(value))
 		}
 		return value, nil
 	}
@@ -47,25 +42,48 @@ func (c *MultiCounter[T, K]) AddIf(keyer func(value T) K, condition func(value T
 // the given condition returns true. This filter does not affect the
 // value flowing through.
 func (c *MultiCounter[T, K]) AddUnless(keyer func(value T) K, condition func(value T) bool) chain.X[T, T] {
-	c.counts = map[K]int64{}
 	return func(value T) (T, error) {
 		if !condition(value) {
-			k := keyer(value)
-			if _, ok := c.counts[k]; !ok {
-				c.counts[k] = 1
-			} else {
-				c.counts[k] = c.counts[k] + 1
-			}
-			c.total = c.total + 1
-			if c.Every > 0 && c.total%c.Every == 0 {
-				fmt.Printf(". ")
-			}
+			c.increment(keyer(value))
 		}
 		return value, nil
 	}
 }
 
-// Count returns the count of items.
+// Count returns a snapshot of the count of items by key, and the total.
 func (c *MultiCounter[T, K]) Count() (map[K]int64, int64) {
-	return c.counts, c.total
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	counts := maps.Clone(c.counts)
+	if counts == nil {
+		counts = map[K]int64{}
+	}
+	return counts, c.total
+}
+
+// Reset returns the count of items by key, and the total, and sets them
+// back to 0, atomically, so that no items counted in the meantime are lost.
+func (c *MultiCounter[T, K]) Reset() (map[K]int64, int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	counts, total := c.counts, c.total
+	if counts == nil {
+		counts = map[K]int64{}
+	}
+	c.counts, c.total = nil, 0
+	return counts, total
+}
+
+func (c *MultiCounter[T, K]) increment(key K) {
+	c.lock.Lock()
+	if c.counts == nil {
+		c.counts = map[K]int64{}
+	}
+	c.counts[key]++
+	c.total++
+	total := c.total
+	c.lock.Unlock()
+	if c.Every > 0 && total%c.Every == 0 {
+		fmt.Printf(". ")
+	}
 }
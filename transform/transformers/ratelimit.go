@@ -0,0 +1,198 @@
+package transformers
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// bucket is a token bucket: it holds up to burst tokens, which are refilled
+// at rate tokens per second; each value takes one token.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket if one is available; otherwise it
+// returns how long it takes until one is. A rate of 0 means no limit.
+func (b *bucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// full returns whether the bucket would be full at the given time, i.e.
+// it is indistinguishable from a new one.
+func (b *bucket) full(now time.Time, rate float64, burst int) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst)
+}
+
+// RateLimiter limits the rate of the values flowing through the chain, with
+// a token bucket that allows short bursts; excess values can either wait for
+// their turn or be dropped. It is safe for concurrent use.
+type RateLimiter[T any] struct {
+	rate    float64
+	burst   int
+	lock    sync.Mutex
+	bucket  bucket
+	dropped atomic.Int64
+}
+
+// RateLimit returns a RateLimiter that lets through rps values per second
+// on average, and up to burst values at once; if rps is 0, there is no
+// limit.
+func RateLimit[T any](rps float64, burst int) *RateLimiter[T] {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter[T]{
+		rate:  rps,
+		burst: burst,
+	}
+}
+
+// Block waits until the value flowing into the transformer is allowed
+// through, or the context is done. This filter does not affect the value
+// flowing through.
+func (r *RateLimiter[T]) Block() chain.FC[T] {
+	return func(ctx context.Context, value T) (T, error) {
+		for {
+			r.lock.Lock()
+			ok, wait := r.bucket.take(time.Now(), r.rate, r.burst)
+			r.lock.Unlock()
+			if ok {
+				return value, nil
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return value, ctx.Err()
+			}
+		}
+	}
+}
+
+// Drop drops the value flowing into the transformer if the rate has been
+// exceeded, otherwise it lets it through unchanged.
+func (r *RateLimiter[T]) Drop() chain.F[T] {
+	return func(value T) (T, error) {
+		r.lock.Lock()
+		ok, _ := r.bucket.take(time.Now(), r.rate, r.burst)
+		r.lock.Unlock()
+		if !ok {
+			r.dropped.Add(1)
+			return value, chain.Drop
+		}
+		return value, nil
+	}
+}
+
+// Dropped returns the number of values dropped because the rate was
+// exceeded.
+func (r *RateLimiter[T]) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Sample lets through a random fraction of the values flowing into the
+// transformer, given by ratio (between 0 and 1), and drops the others.
+func Sample[T any](ratio float64) chain.F[T] {
+	return func(value T) (T, error) {
+		if ratio >= 1 || rand.Float64() < ratio {
+			return value, nil
+		}
+		return value, chain.Drop
+	}
+}
+
+// KeyedSampler limits the rate of the values flowing through the chain
+// separately for each key, so that frequent values are thinned out while
+// rare ones are let through. It is safe for concurrent use.
+type KeyedSampler[T any, K comparable] struct {
+	keyer   func(value T) K
+	rate    float64
+	burst   int
+	lock    sync.Mutex
+	buckets map[K]*bucket
+	dropped map[K]int64
+}
+
+// maxIdleBuckets is the number of per-key buckets above which those that
+// have been idle long enough to be full again are discarded.
+const maxIdleBuckets = 10_000
+
+// SampleByKey returns a KeyedSampler that lets through, for each key
+// computed by keyer, up to perKeyRate values per second on average.
+func SampleByKey[T any, K comparable](keyer func(value T) K, perKeyRate float64) *KeyedSampler[T, K] {
+	return &KeyedSampler[T, K]{
+		keyer:   keyer,
+		rate:    perKeyRate,
+		burst:   max(1, int(math.Ceil(perKeyRate))),
+		buckets: map[K]*bucket{},
+		dropped: map[K]int64{},
+	}
+}
+
+// Filter drops the value flowing into the transformer if the rate for its
+// key has been exceeded, otherwise it lets it through unchanged.
+func (s *KeyedSampler[T, K]) Filter() chain.F[T] {
+	return func(value T) (T, error) {
+		key := s.keyer(value)
+		now := time.Now()
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		b, found := s.buckets[key]
+		if !found {
+			if len(s.buckets) >= maxIdleBuckets {
+				s.prune(now)
+			}
+			b = &bucket{}
+			s.buckets[key] = b
+		}
+		if ok, _ := b.take(now, s.rate, s.burst); !ok {
+			s.dropped[key]++
+			return value, chain.Drop
+		}
+		return value, nil
+	}
+}
+
+// Dropped returns the number of values dropped for each key.
+func (s *KeyedSampler[T, K]) Dropped() map[K]int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dropped := make(map[K]int64, len(s.dropped))
+	for k, v := range s.dropped {
+		dropped[k] = v
+	}
+	return dropped
+}
+
+// prune discards the buckets that are full again; it must be called with
+// the lock held.
+func (s *KeyedSampler[T, K]) prune(now time.Time) {
+	for k, b := range s.buckets {
+		if b.full(now, s.rate, s.burst) {
+			delete(s.buckets, k)
+		}
+	}
+	slog.Debug("pruned idle sampling buckets", "remaining", len(s.buckets))
+}
@@ -0,0 +1,200 @@
+package transformers
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// Splitter writes the values flowing through the chain to one file per key,
+// as they come, so that arbitrarily large inputs can be split with bounded
+// memory; at most a given number of files are kept open at once, closing
+// the least recently written ones first and appending to them when they are
+// written again. It is safe for concurrent use.
+type Splitter[T any] struct {
+	dir       string
+	extension string
+	keyer     func(value T) string
+	render    chain.X[T, string]
+	maxOpen   int
+	lock      sync.Mutex
+	files     map[string]*split
+	open      *list.List
+	names     map[string]string
+}
+
+// split is a file the values of a key are written to.
+type split struct {
+	path    string
+	count   int64
+	file    *os.File
+	element *list.Element
+}
+
+// Split returns a Splitter that writes the values, as rendered by the given
+// transformer, to the files in dir named after their key, as computed by
+// the given keyer, and the given extension (e.g. ".jsonl"); values with an
+// empty key are written to a file named "_". At most maxOpen files (if
+// greater than 0) are kept open at once. Existing files are overwritten.
+func Split[T any](dir string, extension string, keyer func(value T) string, render chain.X[T, string], maxOpen int) *Splitter[T] {
+	return &Splitter[T]{
+		dir:       dir,
+		extension: extension,
+		keyer:     keyer,
+		render:    render,
+		maxOpen:   maxOpen,
+		files:     map[string]*split{},
+		open:      list.New(),
+		names:     map[string]string{},
+	}
+}
+
+// Write writes the value flowing into the transformer to the file of its
+// key; the value flows through unchanged.
+func (s *Splitter[T]) Write() chain.X[T, T] {
+	return func(value T) (T, error) {
+		data, err := s.render(value)
+		if err != nil {
+			return value, err
+		}
+		key := s.keyer(value)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		file, err := s.file(key)
+		if err != nil {
+			return value, err
+		}
+		if _, err := io.WriteString(file.file, data); err != nil {
+			slog.Error("error writing value to split file", "path", file.path, "error", err)
+			return value, err
+		}
+		file.count++
+		return value, nil
+	}
+}
+
+// file returns the file of the given key, opening it if needed; it must be
+// called with the lock held.
+func (s *Splitter[T]) file(key string) (*split, error) {
+	f, ok := s.files[key]
+	if ok && f.file != nil {
+		s.open.MoveToFront(f.element)
+		return f, nil
+	}
+	if s.maxOpen > 0 && s.open.Len() >= s.maxOpen {
+		oldest := s.open.Remove(s.open.Back()).(*split)
+		// the file is no longer tracked as open, so it must not be used
+		// (or closed again) even if closing it failed
+		err := oldest.file.Close()
+		oldest.file = nil
+		if err != nil {
+			slog.Error("error closing split file", "path", oldest.path, "error", err)
+			return nil, err
+		}
+	}
+	// the file is truncated the first time it is opened, and appended to
+	// when it is reopened after being evicted
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !ok {
+		name, err := s.name(key)
+		if err != nil {
+			return nil, err
+		}
+		f = &split{path: filepath.Join(s.dir, name+s.extension)}
+		s.files[key] = f
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(f.path, flags, 0644)
+	if err != nil {
+		slog.Error("error opening split file", "path", f.path, "error", err)
+		return nil, err
+	}
+	slog.Debug("split file opened", "key", key, "path", f.path)
+	f.file = file
+	f.element = s.open.PushFront(f)
+	return f, nil
+}
+
+// name returns the name of the file of the given key, making sure that no
+// two keys share a file; it must be called with the lock held.
+func (s *Splitter[T]) name(key string) (string, error) {
+	name := SanitizeFilename(key)
+	if other, ok := s.names[name]; ok && other != key {
+		slog.Error("keys map to the same file", "key", key, "other", other, "name", name)
+		return "", fmt.Errorf("keys %q and %q map to the same file %q", key, other, name)
+	}
+	s.names[name] = key
+	return name, nil
+}
+
+// Counts returns the number of values written so far to the file of each
+// key, by path.
+func (s *Splitter[T]) Counts() map[string]int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := make(map[string]int64, len(s.files))
+	for _, f := range s.files {
+		counts[f.path] = f.count
+	}
+	return counts
+}
+
+// Close closes all the open files.
+func (s *Splitter[T]) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	errs := []error{}
+	for s.open.Len() > 0 {
+		f := s.open.Remove(s.open.Front()).(*split)
+		if err := f.file.Close(); err != nil {
+			slog.Error("error closing split file", "path", f.path, "error", err)
+			errs = append(errs, err)
+		}
+		f.file = nil
+	}
+	return errors.Join(errs...)
+}
+
+// maxFilenameLength is the maximum length of the sanitized file names,
+// which leaves room for an extension within the usual 255 byte limit.
+const maxFilenameLength = 200
+
+// SanitizeFilename turns an arbitrary key into a safe file name, with no
+// path separators, no leading dots and only letters, digits, dots, dashes
+// and underscores; keys that have to be altered are suffixed with a hash of
+// the original, so that different keys are unlikely to share a name. An
+// empty key becomes "_".
+func SanitizeFilename(key string) string {
+	if key == "" {
+		return "_"
+	}
+	var builder strings.Builder
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			builder.WriteRune(r)
+		default:
+			builder.WriteRune('_')
+		}
+	}
+	name := strings.TrimLeft(builder.String(), ".")
+	if name == key && len(name) <= maxFilenameLength {
+		return name
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	if len(name) > maxFilenameLength-len(suffix) {
+		name = name[:maxFilenameLength-len(suffix)]
+	}
+	return name + suffix
+}
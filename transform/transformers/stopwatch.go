@@ -2,16 +2,23 @@ package transformers
 
 import (
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/dihedron/snoop/format"
 	"github.com/dihedron/snoop/transform/chain"
 )
 
-// StopWatch allowa a way to measure the time elapsed
+// StopWatch allowa a way to measure the time elapsed. It is safe for
+// concurrent use, but when multiple values flow through the chain at
+// the same time, the elapsed time is measured from the most recent
+// Start.
 type StopWatch[S any, T any] struct {
+	lock    sync.Mutex
 	start   time.Time
 	elapsed time.Duration
+	total   time.Duration
+	count   int64
 }
 
 // Start sets the stopwatch start time, based on which the elapsed
@@ -19,7 +26,9 @@ type StopWatch[S any, T any] struct {
 // through.
 func (s *StopWatch[S, T]) Start() chain.X[S, S] {
 	return func(value S) (S, error) {
+		s.lock.Lock()
 		s.start = time.Now()
+		s.lock.Unlock()
 		slog.Debug("profile start", "value", value, "type", format.TypeAsString(value))
 		return value, nil
 	}
@@ -30,13 +39,38 @@ func (s *StopWatch[S, T]) Start() chain.X[S, S] {
 // flowing through.
 func (s *StopWatch[S, T]) Stop() chain.X[T, T] {
 	return func(value T) (T, error) {
-		s.elapsed = time.Since(s.start)
-		slog.Debug("profile stop", "elapsed", s.elapsed.String(), "value", value, "type", format.TypeAsString(value))
+		s.lock.Lock()
+		elapsed := time.Since(s.start)
+		s.elapsed = elapsed
+		s.total += elapsed
+		s.count++
+		s.lock.Unlock()
+		slog.Debug("profile stop", "elapsed", elapsed.String(), "value", value, "type", format.TypeAsString(value))
 		return value, nil
 	}
 }
 
 // Elapsed returns the time elapsed between the Start and Stop calls.
 func (s *StopWatch[S, T]) Elapsed() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.elapsed
 }
+
+// Total returns the sum of the times elapsed between the Start and Stop
+// calls, and the number of Stop calls.
+func (s *StopWatch[S, T]) Total() (time.Duration, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.total, s.count
+}
+
+// Reset returns the sum of the times elapsed between the Start and Stop
+// calls, and the number of Stop calls, and sets them back to 0.
+func (s *StopWatch[S, T]) Reset() (time.Duration, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	total, count := s.total, s.count
+	s.elapsed, s.total, s.count = 0, 0, 0
+	return total, count
+}
@@ -1,41 +1,49 @@
 package transformers
 
 import (
-	"errors"
+	"context"
+	"log/slog"
 
+	"github.com/dihedron/snoop/format"
 	"github.com/dihedron/snoop/syslog"
 	"github.com/dihedron/snoop/transform/chain"
+	"github.com/juju/rfc/v2/rfc5424"
 )
 
-// Accept accepts the value if the condition is true. This
-// filter does not affect the value flowing through.
-func WriteToSyslog[T any](syslog *syslog.Syslog, accept func(value T) bool) chain.F[T] {
-	return func(value T) (T, error) {
-		// if accept(value) {
-		// 	slog.Debug("logging message for inclusion into syslog...", "type", format.TypeAsString(value))
-		// 	if m, ok := any(value).(*notification.Identity); ok {
-		// 		slog.Info("sending message to syslog", "type", m.EventType)
-		// 		// we cannot stop the pipeline even if there's an error
-		// 		// writing to syslog, but we should at least log it
-		// 		json := format.ToJSON(m)
-		// 		if err := syslog.Send(&syslog.Message{
-		// 			Facility: rfc5424.FacilityAuthpriv,
-		// 			Severity: rfc5424.SeverityEmergency,
-		// 			ID:       "OpenStack",
-		// 			Content:  json,
-		// 			Data: map[string][]string{
-		// 				"user":   {"name=Andrea", "surname=Funtò", "id=a123456"},
-		// 				"tenant": {"region=regionOne", "name=event-broker", "id=1234567890"},
-		// 			},
-		// 		}); err != nil {
-		// 			slog.Warn("error sending OpenStack notification to syslog", "error", err)
-		// 		} else {
-		// 			slog.Debug("message sent to syslog", "type", m.EventType)
-		// 		}
-		// 	}
-		// }
-		// return value, nil
-		return value, errors.New("not implemented")
+// Sourced is implemented by values that know where they come from (e.g. the
+// cluster and region of an AMQP message), and can provide this information as
+// syslog structured data.
+type Sourced interface {
+	Source() map[string][]string
+}
+
+// WriteToSyslog sends the values for which accept returns true (all values
+// if accept is nil) to syslog: strings as they are, values implementing
+// fmt.Stringer as returned by their String method, and any other value as
+// JSON; if the value implements Sourced, its source is sent as structured
+// data. It does not send the value if the
+// context is done, and it stops waiting for syslog when the context is
+// cancelled. This filter does not affect the value flowing through.
+func WriteToSyslog[T any](sl *syslog.Syslog, accept func(value T) bool) chain.FC[T] {
+	return func(ctx context.Context, value T) (T, error) {
+		if accept != nil && !accept(value) {
+			return value, nil
+		}
+		message := &syslog.Message{
+			Facility: rfc5424.FacilityUser,
+			Severity: rfc5424.SeverityInformational,
+			ID:       "OpenStack",
+			Content:  value,
+		}
+		if s, ok := any(value).(Sourced); ok {
+			message.Data = s.Source()
+		}
+		if err := sl.SendContext(ctx, message); err != nil {
+			slog.Warn("error sending value to syslog", "type", format.TypeAsString(value), "error", err)
+			return value, err
+		}
+		slog.Debug("value sent to syslog", "type", format.TypeAsString(value))
+		return value, nil
 	}
 }
 
@@ -2,7 +2,14 @@ package transformers
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -35,8 +42,8 @@ func TestFibonacciChain(t *testing.T) {
 	transform := chain.Of7(
 		stopwatch.Start(),
 		Log[int64](t),
-		Delay[int64](50*time.Millisecond),
-		Writef[int64](&buffer, "%d\n", true),
+		chain.Bind(context.Background(), Delay[int64](50*time.Millisecond)),
+		chain.Bind(context.Background(), Writef[int64](&buffer, "%d\n", true)),
 		counter.Add(),
 		accumulator.Add(),
 		stopwatch.Stop(),
@@ -61,8 +68,8 @@ func TestRandomChain(t *testing.T) {
 	transform := chain.Of7(
 		stopwatch.Start(),
 		Log[int64](t),
-		Delay[int64](50*time.Millisecond),
-		Writef[int64](&buffer, "%d\n", true),
+		chain.Bind(context.Background(), Delay[int64](50*time.Millisecond)),
+		chain.Bind(context.Background(), Writef[int64](&buffer, "%d\n", true)),
 		counter.Add(),
 		accumulator.Add(),
 		stopwatch.Stop(),
@@ -88,8 +95,8 @@ func TestFileChain(t *testing.T) {
 	transform := chain.Of7(
 		stopwatch.Start(),
 		Log[string](t),
-		Delay[string](50*time.Millisecond),
-		Writef[string](&buffer, "%s\n", true),
+		chain.Bind(context.Background(), Delay[string](50*time.Millisecond)),
+		chain.Bind(context.Background(), Writef[string](&buffer, "%s\n", true)),
 		counter.Add(),
 		accumulator.Add(),
 		stopwatch.Stop(),
@@ -114,10 +121,10 @@ func TestSequenceWithSkipOddChain(t *testing.T) {
 	transform := chain.Of8(
 		stopwatch.Start(),
 		Log[int64](t),
-		Delay[int64](50*time.Millisecond),
+		chain.Bind(context.Background(), Delay[int64](50*time.Millisecond)),
 		counter.Add(),
 		AcceptIf(func(value int64) bool { return value%2 == 0 }),
-		Writef[int64](&buffer, "%d\n", true),
+		chain.Bind(context.Background(), Writef[int64](&buffer, "%d\n", true)),
 		accumulator.Add(),
 		stopwatch.Stop(),
 	)
@@ -141,8 +148,8 @@ func TestCatenate(t *testing.T) {
 	transform := chain.Of8(
 		stopwatch.Start(),
 		Log[int64](t),
-		Delay[int64](50*time.Millisecond),
-		Writef[int64](&buffer, "%d\n", true),
+		chain.Bind(context.Background(), Delay[int64](50*time.Millisecond)),
+		chain.Bind(context.Background(), Writef[int64](&buffer, "%d\n", true)),
 		counter.Add(),
 		ToString[int64](),
 		catenator.Add(),
@@ -169,8 +176,8 @@ func TestCacheFromFile(t *testing.T) {
 	transform := chain.Of7(
 		stopwatch.Start(),
 		Log[string](t),
-		Delay[string](50*time.Millisecond),
-		Writef[string](&buffer, "%s\n", true),
+		chain.Bind(context.Background(), Delay[string](50*time.Millisecond)),
+		chain.Bind(context.Background(), Writef[string](&buffer, "%s\n", true)),
 		counter.Add(),
 		cache.Set(func(s string) string { return s[:1] }),
 		stopwatch.Stop(),
@@ -196,8 +203,8 @@ func TestMultipleCacheFromMultipleFiles(t *testing.T) {
 	transform := chain.Of7(
 		stopwatch.Start(),
 		Log[string](t),
-		Delay[string](50*time.Millisecond),
-		Writef[string](&buffer, "%s\n", true),
+		chain.Bind(context.Background(), Delay[string](50*time.Millisecond)),
+		chain.Bind(context.Background(), Writef[string](&buffer, "%s\n", true)),
 		counter.Add(),
 		multicache.Set(func(s string) string { return s[:1] }),
 		stopwatch.Stop(),
@@ -211,3 +218,584 @@ func TestMultipleCacheFromMultipleFiles(t *testing.T) {
 	}
 	slog.Info("final result", "elapsed", stopwatch.Elapsed().String(), "items", counter.Count(), "multicache", multicache, "buffer", buffer.String())
 }
+
+func TestDelayCancellation(t *testing.T) {
+	test.Setup(t)
+	var buffer bytes.Buffer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	transform := chain.Bind(ctx, chain.OfC2(
+		Delay[int](time.Hour),
+		Writef[int](&buffer, "%d\n", false),
+	))
+	start := time.Now()
+	stats, err := chain.Run(ctx, slices.Values([]int{1, 2, 3}), transform, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("delay was not interrupted (%s)", elapsed)
+	}
+	if !stats.Cancelled || stats.Read != 1 || stats.Failed != 0 || buffer.Len() != 0 {
+		t.Fatalf("unexpected stats: %+v (buffer: %q)", stats, buffer.String())
+	}
+	if _, err := Write[int](&buffer, false)(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	test.Setup(t)
+
+	origin := time.Date(2024, 5, 21, 9, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{0, time.Second, 2 * time.Second, 10 * time.Second, 9 * time.Second}
+	timestamp := func(offset time.Duration) time.Time {
+		return origin.Add(offset)
+	}
+
+	// 1s gaps become 10ms, the 8s gap is capped at 20ms and the value out
+	// of order is not delayed
+	replayer := Replay(timestamp, 100, WithMaxGap(20*time.Millisecond))
+	var elapsed []time.Duration
+	start := time.Now()
+	stats, err := chain.Run(context.Background(), slices.Values(offsets), chain.Bind(context.Background(), replayer.Pace()), func(time.Duration) error {
+		elapsed = append(elapsed, time.Since(start))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 5 || elapsed[3] < 40*time.Millisecond || elapsed[4] > 500*time.Millisecond {
+		t.Fatalf("unexpected pacing: %v (stats %+v)", elapsed, stats)
+	}
+
+	// seeking skips the values before the starting point
+	replayer = Replay(timestamp, 0, WithStartOffset(1500*time.Millisecond))
+	stats, err = chain.Run(context.Background(), slices.Values(offsets), chain.Bind(context.Background(), replayer.Pace()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 3 || replayer.Skipped() != 2 {
+		t.Fatalf("unexpected stats: %+v (skipped %d)", stats, replayer.Skipped())
+	}
+	replayer = Replay(timestamp, 0, WithStartAt(origin.Add(9*time.Second)))
+	if stats, _ = chain.Run(context.Background(), slices.Values(offsets), chain.Bind(context.Background(), replayer.Pace()), nil); stats.Written != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// a long wait is interrupted by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	replayer = Replay(timestamp, 1)
+	start = time.Now()
+	stats, _ = chain.Run(ctx, slices.Values(offsets), chain.Bind(ctx, replayer.Pace()), nil)
+	if !stats.Cancelled || time.Since(start) > 5*time.Second {
+		t.Fatalf("replay was not interrupted: %+v", stats)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	test.Setup(t)
+
+	batcher := Batch[int](3, 0)
+	batches := [][]int{}
+	stats, err := chain.Run(context.Background(), slices.Values([]int{1, 2, 3, 4, 5, 6, 7}), batcher.Add(), func(batch []int) error {
+		batches = append(batches, batch)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || !slices.Equal(batches[1], []int{4, 5, 6}) || stats.Dropped != 5 {
+		t.Fatalf("unexpected batches: %v (stats: %+v)", batches, stats)
+	}
+	if last := batcher.Flush(); !slices.Equal(last, []int{7}) {
+		t.Fatalf("unexpected last batch: %v", last)
+	}
+	if last := batcher.Flush(); last != nil {
+		t.Fatalf("unexpected empty batch: %v", last)
+	}
+
+	// the batch is emitted when its oldest value has waited too long
+	batcher = Batch[int](100, 20*time.Millisecond)
+	add := batcher.Add()
+	if _, err := add(1); !errors.Is(err, chain.Drop) {
+		t.Fatalf("expected Drop, got %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if batch, err := add(2); err != nil || !slices.Equal(batch, []int{1, 2}) {
+		t.Fatalf("unexpected batch: %v, %v", batch, err)
+	}
+
+	// batches are emitted on time even if no more values arrive
+	values := func(yield func(int) bool) {
+		for i := range 5 {
+			if !yield(i) {
+				return
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+		yield(5)
+	}
+	batches = [][]int{}
+	start := time.Now()
+	for batch := range Batch[int](2, 20*time.Millisecond).Seq(context.Background(), values) {
+		if len(batches) == 2 && time.Since(start) > 150*time.Millisecond {
+			t.Fatal("partial batch was not emitted on time")
+		}
+		batches = append(batches, batch)
+	}
+	if len(batches) != 4 || !slices.Equal(batches[2], []int{4}) || !slices.Equal(batches[3], []int{5}) {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+}
+
+type event struct {
+	host string
+	time time.Time
+}
+
+func TestTumblingWindow(t *testing.T) {
+	test.Setup(t)
+
+	base := time.Date(2024, 5, 21, 9, 0, 0, 0, time.UTC)
+	at := func(minutes, seconds int) time.Time {
+		return base.Add(time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second)
+	}
+	events := []event{
+		{"compute-1", at(0, 10)},
+		{"compute-2", at(1, 0)},
+		{"compute-1", at(4, 59)},
+		{"compute-1", at(4, 30)}, // out of order, within the allowed lateness
+		{"compute-2", at(5, 0)},
+		{"compute-1", at(6, 0)},
+		{"compute-2", at(5, 40)},
+		{"compute-1", at(0, 20)}, // late
+		{"compute-1", at(11, 0)},
+	}
+	windower := TumblingWindow(5*time.Minute, func(e event) time.Time { return e.time }, func(e event) string { return e.host }, WithAllowedLateness(30*time.Second))
+
+	counts := map[string][]int{}
+	count := func(windows []*Window[string, event]) {
+		for _, window := range windows {
+			if window.Start.Sub(base)%(5*time.Minute) != 0 || window.End.Sub(window.Start) != 5*time.Minute {
+				t.Fatalf("unexpected window: %s - %s", window.Start, window.End)
+			}
+			counts[window.Key] = append(counts[window.Key], window.Count())
+		}
+	}
+	stats, err := chain.Run(context.Background(), slices.Values(events), windower.Add(), func(windows []*Window[string, event]) error {
+		count(windows)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first windows are closed by the event at 6:00, the second ones by
+	// the one at 11:00
+	if stats.Written != 2 || windower.Late() != 1 || !windower.Watermark().Equal(at(10, 30)) {
+		t.Fatalf("unexpected stats: %+v (late: %d, watermark: %s)", stats, windower.Late(), windower.Watermark())
+	}
+	count(windower.Flush())
+	if !slices.Equal(counts["compute-1"], []int{3, 1, 1}) || !slices.Equal(counts["compute-2"], []int{1, 2}) {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+
+	// replaying the same events produces the same results
+	replay := TumblingWindow(5*time.Minute, func(e event) time.Time { return e.time }, func(e event) string { return e.host }, WithAllowedLateness(30*time.Second))
+	again := map[string][]int{}
+	add := replay.Add()
+	for _, e := range events {
+		windows, _ := add(e)
+		for _, window := range windows {
+			again[window.Key] = append(again[window.Key], window.Count())
+		}
+	}
+	for _, window := range replay.Flush() {
+		again[window.Key] = append(again[window.Key], window.Count())
+	}
+	if !slices.Equal(again["compute-1"], counts["compute-1"]) || !slices.Equal(again["compute-2"], counts["compute-2"]) {
+		t.Fatalf("replay produced different counts: %v", again)
+	}
+
+	if _, err := windower.Add()(event{host: "compute-3"}); !errors.Is(err, ErrNoTimestamp) {
+		t.Fatalf("expected ErrNoTimestamp, got %v", err)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	test.Setup(t)
+
+	base := time.Date(2024, 5, 21, 9, 0, 0, 0, time.UTC)
+	windower := SlidingWindow(10*time.Minute, 5*time.Minute, func(e event) time.Time { return e.time }, func(e event) string { return e.host })
+	add := windower.Add()
+	for _, minutes := range []int{1, 6, 12} {
+		if _, err := add(event{"compute-1", base.Add(time.Duration(minutes) * time.Minute)}); err != nil && !errors.Is(err, chain.Drop) {
+			t.Fatal(err)
+		}
+	}
+	windows := windower.Flush()
+	// [8:55, 9:05) -> 1, [9:00, 9:10) -> 1, 6, [9:05, 9:15) -> 6, 12, [9:10, 9:20) -> 12
+	// but [8:55, 9:05) and [9:00, 9:10) were closed by the event at 9:12
+	if len(windows) != 2 || windows[0].Count() != 2 || windows[1].Count() != 1 || !windows[0].Start.Equal(base.Add(5*time.Minute)) {
+		for _, window := range windows {
+			t.Logf("window %s - %s: %d", window.Start, window.End, window.Count())
+		}
+		t.Fatalf("unexpected windows: %d", len(windows))
+	}
+}
+
+type identified string
+
+func (i identified) ID() string { return string(i) }
+
+func TestDedup(t *testing.T) {
+	test.Setup(t)
+
+	dedup := Dedup[identified](nil, time.Hour, 3)
+	filter := dedup.Filter()
+	values := []identified{"a", "b", "a", "c", "b", "d", "a", "", ""}
+	passed := []identified{}
+	for _, value := range values {
+		if v, err := filter(value); err == nil {
+			passed = append(passed, v)
+		} else if !errors.Is(err, chain.Drop) {
+			t.Fatal(err)
+		}
+	}
+	// "a" is evicted when "d" arrives, as it is the least recently seen
+	// after "b" was seen again
+	if !slices.Equal(passed, []identified{"a", "b", "c", "d", "a", "", ""}) || dedup.Duplicates() != 2 || dedup.Len() != 3 {
+		t.Fatalf("unexpected values: %v (duplicates: %d, entries: %d)", passed, dedup.Duplicates(), dedup.Len())
+	}
+
+	// keys expire after the ttl
+	modulo := func(value int) string { return fmt.Sprintf("%d", value%10) }
+	expiring := Dedup(modulo, 50*time.Millisecond, 0)
+	f := expiring.Filter()
+	if _, err := f(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f(11); !errors.Is(err, chain.Drop) {
+		t.Fatalf("expected Drop, got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := f(21); err != nil {
+		t.Fatalf("expected key to have expired, got %v", err)
+	}
+
+	// the state survives a restart
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	if err := expiring.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	restarted := Dedup(modulo, time.Hour, 0)
+	if err := restarted.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Filter()(31); !errors.Is(err, chain.Drop) {
+		t.Fatalf("expected Drop after restart, got %v", err)
+	}
+	if err := restarted.Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("unexpected error loading missing state: %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	test.Setup(t)
+
+	// the burst goes through at once, then values wait for their turn
+	limiter := RateLimit[int](100, 5)
+	block := chain.Bind(context.Background(), limiter.Block())
+	start := time.Now()
+	for i := range 15 {
+		if _, err := block(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("unexpected elapsed time: %s", elapsed)
+	}
+
+	// blocking is interrupted when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	slow := RateLimit[int](0.1, 1).Block()
+	if _, err := slow(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := slow(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// excess values are dropped
+	limiter = RateLimit[int](1, 3)
+	stats, err := chain.Run(context.Background(), slices.Values(make([]int, 10)), limiter.Drop(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 3 || stats.Dropped != 7 || limiter.Dropped() != 7 {
+		t.Fatalf("unexpected stats: %+v (dropped: %d)", stats, limiter.Dropped())
+	}
+}
+
+func TestSample(t *testing.T) {
+	test.Setup(t)
+
+	stats, err := chain.Run(context.Background(), slices.Values(make([]int, 10_000)), Sample[int](0.1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written < 800 || stats.Written > 1200 {
+		t.Fatalf("unexpected number of samples: %d", stats.Written)
+	}
+	if stats, _ := chain.Run(context.Background(), slices.Values(make([]int, 100)), Sample[int](1), nil); stats.Written != 100 {
+		t.Fatalf("unexpected number of samples: %d", stats.Written)
+	}
+}
+
+func TestSampleByKey(t *testing.T) {
+	test.Setup(t)
+
+	// an audit storm of "exists" events, with a few rare ones in between
+	events := []string{}
+	for i := range 1000 {
+		if i%250 == 0 {
+			events = append(events, "compute.instance.create.error")
+		} else {
+			events = append(events, "compute.instance.exists")
+		}
+	}
+	sampler := SampleByKey(func(e string) string { return e }, 10)
+	counts := map[string]int{}
+	if _, err := chain.Run(context.Background(), slices.Values(events), sampler.Filter(), func(e string) error {
+		counts[e]++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if counts["compute.instance.create.error"] != 4 || counts["compute.instance.exists"] < 10 || counts["compute.instance.exists"] > 20 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+	if dropped := sampler.Dropped(); dropped["compute.instance.exists"] != int64(996-counts["compute.instance.exists"]) || dropped["compute.instance.create.error"] != 0 {
+		t.Fatalf("unexpected dropped counts: %v", dropped)
+	}
+}
+
+func TestTemplateAndMatch(t *testing.T) {
+	test.Setup(t)
+
+	type event struct {
+		EventType string
+		Host      string
+	}
+	events := []event{
+		{"compute.instance.create.end", "compute-01"},
+		{"identity.authenticate", "keystone-01"},
+		{"compute.instance.delete.end", "compute-02"},
+	}
+
+	match, err := Match[event](`hasPrefix "compute.instance." .EventType`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render, err := Template[event]("{{ .Host }}: {{ .EventType }}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if _, err := chain.Run(context.Background(), slices.Values(events), chain.Of2(match, render), func(s string) error {
+		buffer.WriteString(s)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "compute-01: compute.instance.create.end\ncompute-02: compute.instance.delete.end\n" {
+		t.Fatalf("unexpected output: %q", buffer.String())
+	}
+
+	// conditions on missing fields are false
+	if missing, err := Match[event](`eq .Missing "x"`); err != nil {
+		t.Fatal(err)
+	} else if _, err := missing(events[0]); !errors.Is(err, chain.Drop) {
+		t.Fatalf("expected drop, got %v", err)
+	}
+	if _, err := Match[event](`eq .EventType "x`); err == nil {
+		t.Fatal("expected error on invalid condition")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	test.Setup(t)
+
+	dir := t.TempDir()
+	render := func(value string) (string, error) { return value + "\n", nil }
+	// with at most 2 open files, "a" is closed when "c" arrives and is
+	// appended to when it is written again
+	splitter := Split(dir, ".txt", func(value string) string {
+		return strings.SplitN(value, "-", 2)[0]
+	}, render, 2)
+	write := splitter.Write()
+	for _, value := range []string{"a-1", "b-1", "c-1", "a-2", "-1", "../x-1"} {
+		if _, err := write(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := splitter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"a.txt":                           "a-1\na-2\n",
+		"b.txt":                           "b-1\n",
+		"c.txt":                           "c-1\n",
+		"_.txt":                           "-1\n",
+		SanitizeFilename("../x") + ".txt": "../x-1\n",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != content {
+			t.Fatalf("unexpected content of %s: %q (error: %v)", name, data, err)
+		}
+	}
+	if counts := splitter.Counts(); len(counts) != 5 || counts[filepath.Join(dir, "a.txt")] != 2 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+
+	for key, name := range map[string]string{"compute.instance.create.end": "compute.instance.create.end", "": "_"} {
+		if sanitized := SanitizeFilename(key); sanitized != name {
+			t.Fatalf("unexpected name for %q: %q", key, sanitized)
+		}
+	}
+	for _, key := range []string{"..", "a/b", "a b", strings.Repeat("x", 300)} {
+		if sanitized := SanitizeFilename(key); sanitized == key || strings.ContainsAny(sanitized, "/ ") || strings.HasPrefix(sanitized, ".") || len(sanitized) > 200 {
+			t.Fatalf("unsafe name for %q: %q", key, sanitized)
+		}
+	}
+	if SanitizeFilename("a/b") == SanitizeFilename("a b") {
+		t.Fatal("different keys share a name")
+	}
+
+	// a file that cannot be closed when evicted is not used again, but
+	// reopened when its key is written again
+	splitter = Split(t.TempDir(), ".txt", func(value string) string {
+		return strings.SplitN(value, "-", 2)[0]
+	}, render, 1)
+	write = splitter.Write()
+	if _, err := write("a-1"); err != nil {
+		t.Fatal(err)
+	}
+	splitter.files["a"].file.Close()
+	if _, err := write("b-1"); err == nil {
+		t.Fatal("expected error closing the evicted file")
+	}
+	if splitter.files["a"].file != nil {
+		t.Fatal("evicted file still set")
+	}
+	for _, value := range []string{"a-2", "b-1"} {
+		if _, err := write(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := splitter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentStatefulTransformers(t *testing.T) {
+	test.Setup(t)
+
+	const n = 10_000
+	input := make([]int, n)
+	for i := range input {
+		input[i] = i
+	}
+
+	stopwatch := &StopWatch[int, int]{}
+	counter := &Counter[int]{}
+	multicounter := &MultiCounter[int, int]{}
+	cache := &Cache[int, int]{}
+	multicache := &MultiCache[int, int]{}
+	accumulator := &Accumulator[int]{}
+	modulo := func(i int) int { return i % 7 }
+
+	xform := chain.Of8(
+		stopwatch.Start(),
+		counter.Add(),
+		multicounter.Add(modulo),
+		// a second transformer on the same MultiCounter must not reset it
+		multicounter.AddIf(modulo, func(i int) bool { return i%2 == 0 }),
+		cache.Set(modulo),
+		multicache.Set(modulo),
+		accumulator.Add(),
+		stopwatch.Stop(),
+	)
+
+	// reset the counters while the chain is running: the counts taken
+	// by the resets and the final ones must add up
+	var (
+		done  = make(chan struct{})
+		reset = make(chan int64)
+	)
+	go func() {
+		total := int64(0)
+		for {
+			select {
+			case <-done:
+				reset <- total
+				return
+			default:
+				total += counter.Reset()
+				_, _ = multicounter.Count()
+				_ = cache.Contents()
+				_ = multicache.Contents()
+				_ = accumulator.Values()
+				_ = stopwatch.Elapsed()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}()
+
+	stats, err := chain.Run(context.Background(), slices.Values(input), xform, nil, chain.WithWorkers[int](8, false))
+	close(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := <-reset + counter.Count(); total != n || stats.Written != n {
+		t.Fatalf("unexpected count: %d (stats: %+v)", total, stats)
+	}
+	counts, total := multicounter.Reset()
+	if total != n+n/2 || counts[0] != int64(n/7+1+n/14+1) {
+		t.Fatalf("unexpected counts: %v (total %d)", counts, total)
+	}
+	if counts, total := multicounter.Count(); len(counts) != 0 || total != 0 {
+		t.Fatalf("counts were not reset: %v (total %d)", counts, total)
+	}
+	if contents := cache.Contents(); len(contents) != 7 {
+		t.Fatalf("unexpected cache contents: %v", contents)
+	}
+	if value, ok := cache.Get(3); !ok || value%7 != 3 {
+		t.Fatalf("unexpected cached value: %d, %t", value, ok)
+	}
+	contents := multicache.Reset()
+	items := 0
+	for _, values := range contents {
+		items += len(values)
+	}
+	if len(contents) != 7 || items != n || len(multicache.Contents()) != 0 {
+		t.Fatalf("unexpected multicache contents: %d keys, %d items", len(contents), items)
+	}
+	values := accumulator.Values()
+	slices.Sort(values)
+	if !slices.Equal(values, input) {
+		t.Fatal("unexpected accumulated values")
+	}
+	if _, count := stopwatch.Total(); count != n {
+		t.Fatalf("unexpected stopwatch count: %d", count)
+	}
+}
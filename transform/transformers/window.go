@@ -0,0 +1,204 @@
+package transformers
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dihedron/snoop/transform/chain"
+)
+
+// ErrNoTimestamp is returned by a Windower when a value has no timestamp and
+// cannot be assigned to a window.
+var ErrNoTimestamp = errors.New("value has no timestamp")
+
+// Window is a group of values with the same key, whose timestamps fall in the
+// [Start, End) time interval.
+type Window[K comparable, T any] struct {
+	// Key is the key shared by all values in the window.
+	Key K `json:"key" yaml:"key"`
+	// Start is the start of the window (inclusive).
+	Start time.Time `json:"start" yaml:"start"`
+	// End is the end of the window (exclusive).
+	End time.Time `json:"end" yaml:"end"`
+	// Values are the values in the window, in order of arrival.
+	Values []T `json:"values" yaml:"values"`
+	// sequence is used to sort windows with the same start by creation
+	sequence int64
+}
+
+// Count returns the number of values in the window.
+func (w *Window[K, T]) Count() int {
+	return len(w.Values)
+}
+
+// WindowOption is the type for functional options to the Windower.
+type WindowOption func(*windowOptions)
+
+type windowOptions struct {
+	lateness time.Duration
+}
+
+// WithAllowedLateness lets values arrive out of order by up to the given
+// duration: the watermark lags the latest timestamp seen by this much, so a
+// window is only closed when no more of its values are expected.
+func WithAllowedLateness(lateness time.Duration) WindowOption {
+	return func(o *windowOptions) {
+		if lateness > 0 {
+			o.lateness = lateness
+		}
+	}
+}
+
+// Windower groups the values flowing through the chain into time windows,
+// keyed by a user-provided function. Time is measured by the values' own
+// timestamps (e.g. the notification timestamp), not by the wall clock, so
+// replaying a recording produces the same windows as live operation: the
+// watermark is the latest timestamp seen (minus the allowed lateness), and a
+// window is closed and emitted when the watermark passes its end. Values
+// that arrive after all their windows have been closed are dropped and
+// counted as late.
+type Windower[K comparable, T any] struct {
+	size      time.Duration
+	slide     time.Duration
+	lateness  time.Duration
+	timestamp func(value T) time.Time
+	keyer     func(value T) K
+	lock      sync.Mutex
+	windows   map[windowID[K]]*Window[K, T]
+	watermark time.Time
+	sequence  int64
+	late      int64
+}
+
+type windowID[K comparable] struct {
+	key   K
+	start int64
+}
+
+// TumblingWindow returns a Windower that groups values into contiguous,
+// non-overlapping windows of the given size, aligned to multiples of the
+// size (e.g. every 5 minutes, on the hour, at 5 past etc.).
+func TumblingWindow[K comparable, T any](size time.Duration, timestamp func(value T) time.Time, keyer func(value T) K, options ...WindowOption) *Windower[K, T] {
+	return SlidingWindow(size, size, timestamp, keyer, options...)
+}
+
+// SlidingWindow returns a Windower that groups values into windows of the
+// given size, starting every slide (e.g. the last 10 minutes, every minute);
+// when slide is smaller than size, windows overlap and each value belongs to
+// several of them.
+func SlidingWindow[K comparable, T any](size time.Duration, slide time.Duration, timestamp func(value T) time.Time, keyer func(value T) K, options ...WindowOption) *Windower[K, T] {
+	if slide <= 0 || slide > size {
+		slide = size
+	}
+	o := &windowOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return &Windower[K, T]{
+		size:      size,
+		slide:     slide,
+		lateness:  o.lateness,
+		timestamp: timestamp,
+		keyer:     keyer,
+		windows:   map[windowID[K]]*Window[K, T]{},
+	}
+}
+
+// Add assigns the value flowing into the transformer to its windows, then
+// advances the watermark and returns the windows it closed, ordered by start
+// time; if no window was closed, the value is dropped from the chain. Call
+// Flush at the end of the stream to get the windows that are still open.
+func (w *Windower[K, T]) Add() chain.X[T, []*Window[K, T]] {
+	return func(value T) ([]*Window[K, T], error) {
+		timestamp := w.timestamp(value)
+		if timestamp.IsZero() {
+			return nil, ErrNoTimestamp
+		}
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		key := w.keyer(value)
+		// the latest window the value belongs to starts at the last multiple of
+		// slide; earlier ones start every slide before it, as long as they
+		// still include the timestamp
+		last := timestamp.Truncate(w.slide)
+		if !last.Add(w.size).After(w.watermark) {
+			w.late++
+			slog.Debug("dropping late value", "timestamp", timestamp, "watermark", w.watermark)
+			return nil, chain.Drop
+		}
+		for start := last; start.Add(w.size).After(timestamp); start = start.Add(-w.slide) {
+			end := start.Add(w.size)
+			if !end.After(w.watermark) {
+				// this window has already been emitted
+				break
+			}
+			id := windowID[K]{key: key, start: start.UnixNano()}
+			window, ok := w.windows[id]
+			if !ok {
+				w.sequence++
+				window = &Window[K, T]{Key: key, Start: start, End: end, sequence: w.sequence}
+				w.windows[id] = window
+			}
+			window.Values = append(window.Values, value)
+		}
+
+		if watermark := timestamp.Add(-w.lateness); watermark.After(w.watermark) {
+			w.watermark = watermark
+		}
+		closed := w.collect(func(window *Window[K, T]) bool {
+			return !window.End.After(w.watermark)
+		})
+		if len(closed) == 0 {
+			return nil, chain.Drop
+		}
+		return closed, nil
+	}
+}
+
+// Flush returns all the windows that are still open, ordered by start time,
+// and removes them.
+func (w *Windower[K, T]) Flush() []*Window[K, T] {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.collect(func(*Window[K, T]) bool { return true })
+}
+
+// Watermark returns the current watermark: all windows ending before it have
+// been emitted.
+func (w *Windower[K, T]) Watermark() time.Time {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.watermark
+}
+
+// Late returns the number of values that were dropped because they arrived
+// after their windows had been closed.
+func (w *Windower[K, T]) Late() int64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.late
+}
+
+// collect removes and returns the windows matching the given predicate,
+// ordered by start time and creation; it must be called with the lock held.
+func (w *Windower[K, T]) collect(match func(*Window[K, T]) bool) []*Window[K, T] {
+	var windows []*Window[K, T]
+	for id, window := range w.windows {
+		if match(window) {
+			windows = append(windows, window)
+			delete(w.windows, id)
+		}
+	}
+	slices.SortFunc(windows, func(a, b *Window[K, T]) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.sequence, b.sequence)
+	})
+	return windows
+}